@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"vito-local/internal/audit"
+	"vito-local/internal/config"
+)
+
+// runAudit implements the "audit" subcommand and returns the exit code.
+func runAudit(args []string) int {
+	if len(args) == 0 || args[0] != "verify" {
+		fmt.Fprintln(os.Stderr, "usage: vito-root-service audit verify [-config path] [-file path]")
+		return 2
+	}
+
+	fs := flag.NewFlagSet("audit verify", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Configuration file naming the audit log (default "+config.DefaultPath+")")
+	file := fs.String("file", "", "Path to the audit log (default: audit_log from the configuration)")
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	path, err := auditLogPath(*file, *configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	// Rotated files are verified with the log, oldest first
+	res, err := audit.VerifyFiles(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "FAILED after %d valid records: %v\n", res.Records, err)
+		if errors.Is(err, audit.ErrChainBroken) {
+			return 3
+		}
+		return 1
+	}
+
+	fmt.Printf("OK: %d records verified\n", res.Records)
+	if res.TornTails > 0 {
+		fmt.Printf("torn records: %d (see %q records)\n", res.TornTails, audit.TornTailAction)
+	}
+	if res.FirstSeq > 1 {
+		fmt.Printf("first seq: %d (earlier rotated files were removed)\n", res.FirstSeq)
+	}
+	if res.Records > 0 {
+		fmt.Printf("last seq:  %d\nlast hash: %s\n", res.LastSeq, res.LastHash)
+	}
+	return 0
+}
+
+// auditLogPath returns file if given, and otherwise the audit log named
+// by the configuration, loaded as the daemon would load it, so that the
+// subcommands read the log the service writes.
+func auditLogPath(file, configPath string) (string, error) {
+	if file != "" {
+		return file, nil
+	}
+	cfg, err := config.Load(config.LoadOptions{Path: configPath, Environ: os.Environ()})
+	if err != nil {
+		return "", fmt.Errorf("loading configuration: %w", err)
+	}
+	if cfg.AuditLog == "" {
+		return "", errors.New("the audit log is disabled in the configuration; name a file with -file")
+	}
+	return cfg.AuditLog, nil
+}
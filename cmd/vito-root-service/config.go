@@ -0,0 +1,192 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"vito-local/internal/audit"
+	"vito-local/internal/config"
+	"vito-local/internal/journald"
+	"vito-local/internal/metrics"
+	"vito-local/internal/redact"
+	"vito-local/internal/tracing"
+)
+
+// runConfig implements the "config" subcommand and returns the exit code.
+// It loads the configuration exactly as the daemon would, from the same
+// file, drop-ins, environment and flags, so it can gate a restart.
+func runConfig(args []string) int {
+	if len(args) == 0 || (args[0] != "check" && args[0] != "show") {
+		fmt.Fprintln(os.Stderr, "usage: vito-root-service config check|show [-json] [service flags]")
+		return 2
+	}
+	sub := args[0]
+
+	fs := flag.NewFlagSet("config "+sub, flag.ContinueOnError)
+	cfgFlags := config.RegisterFlags(fs)
+	jsonOutput := fs.Bool("json", false, "Print the result as JSON")
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: vito-root-service config %s [-json] [service flags]\n", sub)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	cfg, err := config.Load(config.LoadOptions{Environ: os.Environ(), Flags: cfgFlags})
+	if err == nil {
+		err = checkConfig(cfg)
+	}
+	if err != nil {
+		if *jsonOutput {
+			_ = json.NewEncoder(os.Stdout).Encode(map[string]any{"valid": false, "error": err.Error()})
+		} else {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		}
+		return 1
+	}
+
+	report := configReport{
+		Valid:          true,
+		ConfigFile:     cfg.ConfigPath,
+		ConfigFileRead: fileExists(cfg.ConfigPath),
+		AllowedUser:    cfg.AllowedUser,
+		AllowedUID:     cfg.AllowedUID,
+		SocketOwner:    cfg.SocketOwner,
+		SocketOwnerUID: cfg.SocketOwnerUID,
+		SocketGroup:    cfg.SocketGroup,
+		SocketGroupGID: cfg.SocketGroupGID,
+	}
+	for _, l := range cfg.Listeners {
+		report.Listeners = append(report.Listeners, listenerReport{
+			Name:     l.Name,
+			Socket:   l.SocketPath,
+			Users:    l.Users,
+			Role:     l.Role,
+			Commands: l.Commands,
+			Owner:    l.SocketOwner,
+			Group:    l.SocketGroup,
+			Mode:     fmt.Sprintf("%04o", l.SocketMode),
+		})
+	}
+	if sub == "show" {
+		report.Settings = cfg.Entries()
+	}
+
+	if *jsonOutput {
+		if err := json.NewEncoder(os.Stdout).Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	file := report.ConfigFile
+	if !report.ConfigFileRead {
+		file += " (not found, using defaults)"
+	}
+	if sub == "check" {
+		fmt.Printf("OK: configuration is valid\nconfig file:  %s\nallowed user: %s (uid %d)\nsocket:       %s:%s (uid %d, gid %d)\n",
+			file, report.AllowedUser, report.AllowedUID,
+			report.SocketOwner, report.SocketGroup, report.SocketOwnerUID, report.SocketGroupGID)
+		for _, l := range report.Listeners {
+			fmt.Printf("listener:     %s (%s) on %s for %s\n", l.Name, l.Role, l.Socket, strings.Join(l.Users, ", "))
+		}
+		return 0
+	}
+
+	fmt.Printf("# config file:  %s\n# allowed user: %s (uid %d)\n# socket:       %s:%s (uid %d, gid %d)\n",
+		file, report.AllowedUser, report.AllowedUID,
+		report.SocketOwner, report.SocketGroup, report.SocketOwnerUID, report.SocketGroupGID)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+	for _, e := range report.Settings {
+		value := e.Value
+		if value == "" {
+			value = `""`
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", e.Key, value, e.Source)
+	}
+	w.Flush()
+
+	for _, l := range report.Listeners {
+		fmt.Printf("\n# listener %s\nsocket    %s (%s:%s %s)\nusers     %s\nrole      %s\n",
+			l.Name, l.Socket, l.Owner, l.Group, l.Mode, strings.Join(l.Users, ", "), l.Role)
+		for _, c := range l.Commands {
+			fmt.Printf("command   %s\n", c)
+		}
+	}
+	return 0
+}
+
+// configReport is the output of "config check" and "config show".
+type configReport struct {
+	Valid          bool             `json:"valid"`
+	ConfigFile     string           `json:"config_file"`
+	ConfigFileRead bool             `json:"config_file_read"`
+	AllowedUser    string           `json:"allowed_user"`
+	AllowedUID     uint32           `json:"allowed_uid"`
+	SocketOwner    string           `json:"socket_owner"`
+	SocketOwnerUID uint32           `json:"socket_owner_uid"`
+	SocketGroup    string           `json:"socket_group"`
+	SocketGroupGID uint32           `json:"socket_group_gid"`
+	Listeners      []listenerReport `json:"listeners,omitempty"`
+	Settings       []config.Entry   `json:"settings,omitempty"`
+}
+
+// listenerReport describes one additional listener.
+type listenerReport struct {
+	Name     string   `json:"name"`
+	Socket   string   `json:"socket"`
+	Users    []string `json:"users"`
+	Role     string   `json:"role"`
+	Commands []string `json:"commands,omitempty"`
+	Owner    string   `json:"socket_owner"`
+	Group    string   `json:"socket_group"`
+	Mode     string   `json:"socket_mode"`
+}
+
+// checkConfig runs the checks the daemon makes while starting up that
+// config.Load leaves to the components using the settings, without
+// opening any file, socket or connection.
+func checkConfig(cfg *config.Config) error {
+	var errs []error
+	if _, err := redact.New(cfg.RedactPatterns); err != nil {
+		errs = append(errs, fmt.Errorf("redact_patterns: %w", err))
+	}
+	if cfg.LogFormat == "journald" && !journald.Available() {
+		errs = append(errs, fmt.Errorf("log_format: journald logging requested but %s does not exist", journald.SocketPath))
+	}
+	if cfg.AuditSyslog != "" {
+		opts := audit.SyslogOptions{
+			URL:      cfg.AuditSyslog,
+			CAFile:   cfg.AuditSyslogCA,
+			CertFile: cfg.AuditSyslogCert,
+			KeyFile:  cfg.AuditSyslogKey,
+		}
+		if err := opts.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("audit.syslog: %w", err))
+		}
+	}
+	if cfg.MetricsAddr != "" {
+		if err := metrics.ValidateAddr(cfg.MetricsAddr); err != nil {
+			errs = append(errs, fmt.Errorf("metrics.addr: %w", err))
+		}
+	}
+	if cfg.OTLPEndpoint != "" {
+		if err := tracing.ValidateEndpoint(cfg.OTLPEndpoint); err != nil {
+			errs = append(errs, fmt.Errorf("tracing.otlp_endpoint: %w", err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
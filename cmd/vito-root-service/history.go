@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"vito-local/internal/audit"
+	"vito-local/internal/config"
+)
+
+// runHistory implements the "history" subcommand and returns the exit code.
+// It reads the audit log directly, so it works for root on the host even
+// when the service is stopped.
+func runHistory(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	configPath := fs.String("config", "", "Configuration file naming the audit log (default "+config.DefaultPath+")")
+	file := fs.String("file", "", "Path to the audit log (default: audit_log from the configuration)")
+	since := fs.String("since", "", "Only executions started after this time (RFC 3339) or this long ago (e.g. 1h)")
+	until := fs.String("until", "", "Only executions started before this time (RFC 3339) or this long ago")
+	exitCode := fs.String("exit-code", "", "Only executions with this exit code")
+	failed := fs.Bool("failed", false, "Only executions that errored or exited non-zero")
+	peerUID := fs.String("peer-uid", "", "Only executions from this peer UID")
+	peerPID := fs.String("peer-pid", "", "Only executions from this peer PID")
+	peerExe := fs.String("peer-exe", "", "Only executions from this peer executable")
+	command := fs.String("command", "", "Only executions whose command contains this text")
+	limit := fs.Int("limit", audit.DefaultQueryLimit, "Maximum number of executions to show (0 = all)")
+	jsonOutput := fs.Bool("json", false, "Print one JSON record per line")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: vito-root-service history [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	q := audit.Query{
+		Failed:  *failed,
+		PeerExe: *peerExe,
+		Command: *command,
+		Limit:   *limit,
+	}
+	if q.Limit == 0 {
+		q.Limit = -1
+	}
+
+	now := time.Now()
+	var err error
+	if q.Since, err = parseTimeFlag(*since, now); err != nil {
+		return usageError("-since", err)
+	}
+	if q.Until, err = parseTimeFlag(*until, now); err != nil {
+		return usageError("-until", err)
+	}
+	if *exitCode != "" {
+		code, err := strconv.Atoi(*exitCode)
+		if err != nil {
+			return usageError("-exit-code", err)
+		}
+		q.ExitCode = &code
+	}
+	if *peerUID != "" {
+		uid, err := strconv.ParseUint(*peerUID, 10, 32)
+		if err != nil {
+			return usageError("-peer-uid", err)
+		}
+		u := uint32(uid)
+		q.PeerUID = &u
+	}
+	if *peerPID != "" {
+		pid, err := strconv.ParseInt(*peerPID, 10, 32)
+		if err != nil {
+			return usageError("-peer-pid", err)
+		}
+		p := int32(pid)
+		q.PeerPID = &p
+	}
+
+	path, err := auditLogPath(*file, *configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	records, more, err := audit.NewHistory(path).Query(q)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+	if more {
+		defer fmt.Fprintf(os.Stderr, "showing the newest %d matches; raise -limit (0 = all) to see more\n", len(records))
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		for _, rec := range records {
+			if err := enc.Encode(rec); err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				return 1
+			}
+		}
+		return 0
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "START\tDURATION\tJOB\tUID\tPID\tEXIT\tCOMMAND")
+	for _, rec := range records {
+		exit := "-"
+		if rec.ExitCode != nil {
+			exit = strconv.Itoa(*rec.ExitCode)
+		} else if rec.Error != "" {
+			exit = "error"
+		}
+		what := rec.Command
+		if what == "" {
+			what = "[" + rec.Action + "]"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\n",
+			rec.Start.Local().Format("2006-01-02 15:04:05"),
+			rec.End.Sub(rec.Start).Round(time.Millisecond),
+			rec.JobID, rec.PeerUID, rec.PeerPID, exit,
+			strings.ReplaceAll(what, "\n", " "),
+		)
+	}
+	w.Flush()
+	return 0
+}
+
+// parseTimeFlag accepts an RFC 3339 timestamp or a duration before now.
+func parseTimeFlag(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%q is neither a duration nor an RFC 3339 time", value)
+	}
+	return t, nil
+}
+
+func usageError(flagName string, err error) int {
+	fmt.Fprintf(os.Stderr, "invalid %s: %v\n", flagName, err)
+	return 2
+}
@@ -5,24 +5,47 @@ import (
 	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"vito-local/internal/audit"
 	"vito-local/internal/config"
+	"vito-local/internal/executor"
+	"vito-local/internal/journald"
+	"vito-local/internal/metrics"
+	"vito-local/internal/recording"
+	"vito-local/internal/redact"
+	"vito-local/internal/sdnotify"
 	"vito-local/internal/server"
+	"vito-local/internal/tracing"
 )
 
 var version = "dev"
 
 func main() {
-	socketPath := flag.String("socket", "/run/vito-root.sock", "Path to the Unix socket")
-	allowedUser := flag.String("user", "vito", "Allowed connecting user")
-	logLevel := flag.String("log-level", "info", "Log level (debug, info, warn, error)")
-	logJSON := flag.Bool("log-json", false, "Output logs as JSON")
-	maxExecTimeout := flag.Duration("max-exec-timeout", 0, "Maximum command execution time (0 = no limit)")
-	maxConnections := flag.Int("max-connections", 100, "Maximum concurrent connections")
+	// The sandbox helper re-executes this binary; handle it before any
+	// flag parsing or logging.
+	if len(os.Args) > 1 && os.Args[1] == executor.HelperCommand {
+		executor.RunHelper(os.Args[2:])
+	}
+
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "audit":
+			os.Exit(runAudit(os.Args[2:]))
+		case "history":
+			os.Exit(runHistory(os.Args[2:]))
+		case "config":
+			os.Exit(runConfig(os.Args[2:]))
+		case "rollback":
+			os.Exit(runRollback(os.Args[2:]))
+		}
+	}
+
+	cfgFlags := config.RegisterFlags(flag.CommandLine)
 	showVersion := flag.Bool("version", false, "Show version and exit")
 	flag.Parse()
 
@@ -31,17 +54,31 @@ func main() {
 		os.Exit(0)
 	}
 
-	// Initialize logger
-	logger := initLogger(*logLevel, *logJSON)
+	// Load configuration: defaults, config file, drop-ins, environment,
+	// then flags.
+	cfg, err := config.Load(config.LoadOptions{Environ: os.Environ(), Flags: cfgFlags})
+	if err == nil {
+		err = checkConfig(cfg)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "vito-root-service: failed to load configuration:", err)
+		os.Exit(1)
+	}
+
+	// Initialize logger. The level can change on reload.
+	level := new(slog.LevelVar)
+	level.Set(parseLevel(cfg.LogLevel))
+	logger, err := initLogger(level, cfg.LogFormat)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "vito-root-service:", err)
+		os.Exit(1)
+	}
 
-	// Load configuration
-	cfg, err := config.New(*socketPath, *allowedUser, *logLevel, *logJSON)
+	redactor, err := redact.New(cfg.RedactPatterns)
 	if err != nil {
-		logger.Error("failed to load configuration", slog.String("error", err.Error()))
+		logger.Error("invalid redaction pattern", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
-	cfg.MaxExecTimeout = *maxExecTimeout
-	cfg.MaxConnections = *maxConnections
 
 	// Get the path to our own binary for self-update
 	binaryPath, err := os.Executable()
@@ -51,41 +88,182 @@ func main() {
 		binaryPath = ""
 	}
 
-	// Create and start server with version and binary path for self-update
-	srv := server.New(cfg, logger,
+	opts := []server.Option{
 		server.WithVersion(version),
 		server.WithBinaryPath(binaryPath),
-	)
+		server.WithRedactor(redactor),
+	}
+
+	// The hash-chained log must come first so forwarded records carry
+	// their sequence number and hash.
+	var auditSinks []audit.Sink
+	if cfg.AuditLog != "" {
+		auditLog, err := audit.Open(cfg.AuditLog, cfg.AuditMaxSize, cfg.AuditMaxFiles)
+		if err != nil {
+			logger.Error("failed to open audit log (check it with 'vito-root-service audit verify')",
+				slog.String("path", cfg.AuditLog),
+				slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		defer auditLog.Close()
+		auditSinks = append(auditSinks, auditLog)
+
+		opts = append(opts, server.WithHistory(audit.NewHistory(cfg.AuditLog)))
+	}
+	if cfg.AuditSyslog != "" {
+		syslogSink, err := audit.NewSyslogSink(audit.SyslogOptions{
+			URL:       cfg.AuditSyslog,
+			CAFile:    cfg.AuditSyslogCA,
+			CertFile:  cfg.AuditSyslogCert,
+			KeyFile:   cfg.AuditSyslogKey,
+			SpoolPath: cfg.AuditSyslogSpool,
+			Logger:    logger.With(slog.String("component", "audit-syslog")),
+		})
+		if err != nil {
+			logger.Error("failed to set up audit forwarding", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		defer syslogSink.Close()
+		auditSinks = append(auditSinks, syslogSink)
+	}
+	if cfg.RecordDir != "" {
+		recorder, err := recording.New(cfg.RecordDir, cfg.RecordMaxSize, cfg.RecordMaxAge)
+		if err != nil {
+			logger.Error("failed to set up output recording", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		opts = append(opts, server.WithRecorder(recorder))
+	}
+
+	var traceExporter *tracing.OTLPExporter
+	if cfg.OTLPEndpoint != "" {
+		traceExporter, err = tracing.NewOTLPExporter(tracing.OTLPOptions{
+			Endpoint:       cfg.OTLPEndpoint,
+			ServiceName:    "vito-root-service",
+			ServiceVersion: version,
+			Logger:         logger.With(slog.String("component", "tracing")),
+		})
+		if err != nil {
+			logger.Error("failed to set up tracing", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+		opts = append(opts, server.WithTracer(tracing.New(traceExporter)))
+	}
+
+	if len(auditSinks) > 0 {
+		opts = append(opts, server.WithAudit(audit.Multi(auditSinks...)))
+	}
+
+	if disableIdleExit(cfg) {
+		logger.Info("idle exit disabled while the metrics endpoint is enabled")
+	}
+
+	// Create and start server with version and binary path for self-update
+	srv := server.New(cfg, logger, opts...)
 
 	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
 	defer stop()
 
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// Create the metrics socket before the server starts: its mode comes
+	// from a process-wide umask, which commands started meanwhile would
+	// inherit.
+	var metricsListener net.Listener
+	if cfg.MetricsAddr != "" {
+		metricsListener, err = metrics.Listen(cfg.MetricsAddr, 0660, int(cfg.SocketGroupGID))
+		if err != nil {
+			logger.Error("failed to start metrics endpoint", slog.String("error", err.Error()))
+			os.Exit(1)
+		}
+	}
+
 	if err := srv.Start(ctx); err != nil {
 		logger.Error("failed to start server", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
 
+	var metricsServer *metrics.Server
+	if metricsListener != nil {
+		metricsServer = metrics.Serve(metricsListener, srv.Metrics(), logger)
+		logger.Info("metrics endpoint listening", slog.String("addr", cfg.MetricsAddr))
+	}
+
 	logger.Info("server running", slog.String("version", version))
+	if err := sdnotify.Ready(srv.StatusLine()); err != nil {
+		logger.Warn("failed to notify systemd of readiness", slog.String("error", err.Error()))
+	}
+	go notifyLoop(ctx, srv, logger)
 
-	// Wait for shutdown signal or restart request
-	var restartRequested bool
-	select {
-	case <-ctx.Done():
-		logger.Info("received shutdown signal")
-	case <-srv.RestartChan():
-		logger.Info("restart requested for update")
-		restartRequested = true
-		stop() // Cancel the signal context
+	confirmFailed := make(chan error, 1)
+	if binaryPath != "" {
+		go func() {
+			if err := confirmUpdate(ctx, srv, binaryPath, logger); err != nil {
+				confirmFailed <- err
+			}
+		}()
 	}
 
+	// Wait for shutdown signal or restart request, reloading the
+	// configuration on SIGHUP.
+	var restartRequested, updateFailed bool
+wait:
+	for {
+		select {
+		case err := <-confirmFailed:
+			logger.Error("updated binary failed to start serving, exiting so the previous version is restored",
+				slog.String("error", err.Error()))
+			updateFailed = true
+			stop()
+			break wait
+		case <-hup:
+			cfg = reload(srv, cfg, cfgFlags, level, logger)
+		case <-ctx.Done():
+			logger.Info("received shutdown signal")
+			break wait
+		case <-srv.RestartChan():
+			logger.Info("restart requested for update")
+			restartRequested = true
+			stop() // Cancel the signal context
+			break wait
+		case <-srv.IdleChan():
+			// Leave ctx alone: a connection accepted in the meantime must be
+			// allowed to finish during the drain.
+			logger.Info("exiting while idle, systemd will restart on the next connection")
+			break wait
+		}
+	}
+
+	_ = sdnotify.Stopping()
+
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	if metricsServer != nil {
+		_ = metricsServer.Shutdown(shutdownCtx)
+	}
+
 	if err := srv.Shutdown(shutdownCtx); err != nil {
 		logger.Error("shutdown error", slog.String("error", err.Error()))
 		os.Exit(1)
 	}
 
+	// Flush the spans of the requests that just drained.
+	if traceExporter != nil {
+		_ = traceExporter.Shutdown(shutdownCtx)
+	}
+
+	if updateFailed {
+		// Leave the start attempt recorded so the rollback guard restores
+		// the previous binary.
+		os.Exit(1)
+	}
+	if binaryPath != "" {
+		resetUpdateAttempt(binaryPath, logger)
+	}
+
 	if restartRequested {
 		logger.Info("server stopped for restart, exiting with code 0 for systemd restart")
 		// Exit with code 0 so systemd will restart us with the new binary
@@ -95,27 +273,59 @@ func main() {
 	logger.Info("server stopped")
 }
 
-func initLogger(level string, jsonOutput bool) *slog.Logger {
-	var slogLevel slog.Level
+// disableIdleExit turns off idle exit while the metrics endpoint is
+// enabled, reporting whether it did. The metrics listener is not
+// socket-activated, so exiting when idle would take it down until the next
+// command.
+func disableIdleExit(cfg *config.Config) bool {
+	if cfg.MetricsAddr == "" || cfg.IdleTimeout <= 0 {
+		return false
+	}
+	cfg.IdleTimeout = 0
+	return true
+}
+
+// parseLevel converts a configured log level to a slog.Level.
+func parseLevel(level string) slog.Level {
 	switch level {
 	case "debug":
-		slogLevel = slog.LevelDebug
+		return slog.LevelDebug
 	case "warn":
-		slogLevel = slog.LevelWarn
+		return slog.LevelWarn
 	case "error":
-		slogLevel = slog.LevelError
+		return slog.LevelError
 	default:
-		slogLevel = slog.LevelInfo
+		return slog.LevelInfo
 	}
+}
 
-	opts := &slog.HandlerOptions{Level: slogLevel}
+// initLogger builds the logger for the given level and format. The
+// journald format writes natively to the systemd journal so attributes
+// become queryable fields (journalctl VITO_JOB_ID=...).
+func initLogger(level slog.Leveler, format string) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: level}
 
 	var handler slog.Handler
-	if jsonOutput {
-		handler = slog.NewJSONHandler(os.Stdout, opts)
-	} else {
+	switch format {
+	case "text":
 		handler = slog.NewTextHandler(os.Stdout, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stdout, opts)
+	case "journald":
+		if !journald.Available() {
+			return nil, fmt.Errorf("journald logging requested but %s does not exist", journald.SocketPath)
+		}
+		h, err := journald.NewHandler(&journald.Options{
+			Level:      level,
+			Identifier: "vito-root-service",
+		})
+		if err != nil {
+			return nil, err
+		}
+		handler = h
+	default:
+		return nil, fmt.Errorf("invalid log format %q (valid: text, json, journald)", format)
 	}
 
-	return slog.New(handler)
+	return slog.New(handler), nil
 }
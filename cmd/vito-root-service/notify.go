@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"vito-local/internal/sdnotify"
+	"vito-local/internal/server"
+)
+
+// statusInterval is how often the status line shown by systemctl status is
+// refreshed when the watchdog does not require more frequent updates.
+const statusInterval = 10 * time.Second
+
+// notifyLoop keeps systemd informed while the server runs: it refreshes the
+// status line and, when WatchdogSec= is set, sends keep-alives at half the
+// watchdog interval. Keep-alives are withheld while the server fails its
+// liveness check or does not answer a health request sent through its own
+// socket, so systemd restarts a daemon that can no longer accept or
+// handle requests.
+func notifyLoop(ctx context.Context, srv *server.Server, logger *slog.Logger) {
+	if !sdnotify.Enabled() {
+		return
+	}
+	watchdog := sdnotify.WatchdogInterval()
+	interval := statusInterval
+	if watchdog > 0 {
+		interval = min(watchdog/2, statusInterval)
+		logger.Info("systemd watchdog enabled", slog.Duration("interval", watchdog))
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	alive := true
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := srv.Alive()
+		if err == nil {
+			err = srv.Ping(ctx)
+		}
+		if err != nil {
+			if alive {
+				logger.Error("liveness check failed, withholding watchdog keep-alive",
+					slog.String("error", err.Error()))
+			}
+			alive = false
+			_ = sdnotify.Status("Unhealthy: " + err.Error())
+			continue
+		}
+		if !alive {
+			logger.Info("liveness check recovered")
+			alive = true
+		}
+
+		assignments := []string{"STATUS=" + srv.StatusLine()}
+		if watchdog > 0 {
+			assignments = append(assignments, "WATCHDOG=1")
+		}
+		if err := sdnotify.Notify(assignments...); err != nil {
+			logger.Warn("failed to notify systemd", slog.String("error", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+
+	"vito-local/internal/config"
+	"vito-local/internal/redact"
+	"vito-local/internal/sdnotify"
+	"vito-local/internal/server"
+)
+
+// reload re-reads the configuration from the sources used at startup and
+// applies it to new connections. Running jobs are not interrupted. If the
+// new configuration is invalid the error is logged and the running one
+// stays in effect, as do settings that need a restart. It returns the
+// configuration now in effect.
+func reload(srv *server.Server, current *config.Config, flags *config.Flags, level *slog.LevelVar, logger *slog.Logger) *config.Config {
+	logger.Info("reloading configuration")
+	if err := sdnotify.Reloading(); err != nil {
+		logger.Warn("failed to notify systemd of reload", slog.String("error", err.Error()))
+	}
+	defer func() { _ = sdnotify.Ready(srv.StatusLine()) }()
+
+	next, err := config.Load(config.LoadOptions{Environ: os.Environ(), Flags: flags})
+	if err == nil {
+		err = checkConfig(next)
+	}
+	if err != nil {
+		logger.Error("reload failed, keeping the running configuration", slog.String("error", err.Error()))
+		return current
+	}
+	redactor, err := redact.New(next.RedactPatterns)
+	if err != nil {
+		logger.Error("reload failed, keeping the running configuration",
+			slog.String("error", "invalid redaction pattern: "+err.Error()))
+		return current
+	}
+	disableIdleExit(next)
+
+	changed, restart := current.Changes(next)
+	if len(restart) > 0 {
+		logger.Warn("some changed settings take effect only after a restart", slog.Any("keys", restart))
+	}
+	if len(changed) == 0 {
+		if len(restart) == 0 {
+			logger.Info("configuration unchanged")
+		}
+		return current
+	}
+
+	// Settings that need a restart keep their running values, so the
+	// result reflects what is in effect and the next reload warns again.
+	applied := current.Applied(next)
+	level.Set(parseLevel(applied.LogLevel))
+	srv.Reload(applied, redactor)
+	logger.Info("applied configuration changes", slog.Any("keys", changed))
+	return applied
+}
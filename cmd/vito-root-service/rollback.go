@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"vito-local/internal/updater"
+)
+
+// serviceUnit is the systemd unit restarted after a rollback.
+const serviceUnit = "vito-root.service"
+
+// runRollback implements the "rollback" subcommand and returns the exit
+// code. It reinstates the binary kept by the last update and restarts the
+// service if it is running; a socket-activated service that is stopped
+// starts the reinstated binary on its next connection.
+func runRollback(args []string) int {
+	fs := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	binary := fs.String("binary", "", "Path to the installed binary (default: this executable)")
+	noRestart := fs.Bool("no-restart", false, "Do not restart the service after rolling back")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: vito-root-service rollback [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	binaryPath := *binary
+	if binaryPath == "" {
+		var err error
+		if binaryPath, err = os.Executable(); err != nil {
+			fmt.Fprintf(os.Stderr, "error: locating this executable: %v\n", err)
+			return 1
+		}
+	}
+
+	u := updater.New(version, binaryPath)
+	result, err := u.Rollback(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error: %s\n", result.Message)
+		return 1
+	}
+	fmt.Println(result.Message)
+
+	if *noRestart {
+		fmt.Printf("restart %s to run %s\n", serviceUnit, result.LatestVersion)
+		return 0
+	}
+	cmd := exec.Command("systemctl", "try-restart", serviceUnit)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(os.Stderr, "error: restarting %s: %v\n", serviceUnit, err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"vito-local/internal/server"
+	"vito-local/internal/updater"
+)
+
+// updateConfirmGrace is how long a newly updated binary must keep running
+// before it confirms the update, so a version that crashes shortly after
+// starting is still rolled back.
+const updateConfirmGrace = 10 * time.Second
+
+// updateConfirmTimeout is how long a newly updated binary has to start
+// answering requests before it gives up and leaves the previous one to be
+// restored.
+const updateConfirmTimeout = 30 * time.Second
+
+// confirmUpdate confirms a pending self-update once the server has been
+// up for updateConfirmGrace and a health request has made the round trip
+// through its socket. If that does not happen within updateConfirmTimeout,
+// confirmUpdate returns an error; the caller shuts down and exits with a
+// failure, and the service unit's rollback guard restores the previous
+// binary on the restart. When ctx is cancelled first, the update stays
+// unconfirmed and resetUpdateAttempt lets the next start try it again.
+func confirmUpdate(ctx context.Context, srv *server.Server, binaryPath string, logger *slog.Logger) error {
+	pending, err := updater.Pending(binaryPath)
+	if err != nil {
+		logger.Warn("failed to read pending update", slog.String("error", err.Error()))
+		return nil
+	}
+	if pending == nil {
+		return nil
+	}
+
+	deadline := time.Now().Add(updateConfirmTimeout)
+	select {
+	case <-time.After(updateConfirmGrace):
+	case <-ctx.Done():
+		return nil
+	}
+	for {
+		err := srv.Ping(ctx)
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("not answering requests %s after the update: %w", updateConfirmTimeout, err)
+		}
+		select {
+		case <-time.After(200 * time.Millisecond):
+		case <-ctx.Done():
+			return nil
+		}
+	}
+
+	// The new version is serving; failing to clear the markers must not
+	// get it rolled back.
+	if err := updater.ConfirmUpdate(binaryPath); err != nil {
+		logger.Warn("failed to confirm update", slog.String("error", err.Error()))
+		return nil
+	}
+	logger.Info("update confirmed",
+		slog.String("from_version", pending.FromVersion),
+		slog.String("to_version", pending.ToVersion),
+		slog.String("previous_binary", updater.PrevPath(binaryPath)),
+	)
+	return nil
+}
+
+// resetUpdateAttempt runs after a clean shutdown. A stop during the grace
+// period (systemctl restart, a reboot) says nothing about the new version,
+// so its start attempt is cleared and the rollback guard does not restore
+// the previous binary over it.
+func resetUpdateAttempt(binaryPath string, logger *slog.Logger) {
+	pending, err := updater.Pending(binaryPath)
+	if err != nil || pending == nil {
+		return
+	}
+	if err := updater.ResetAttempt(binaryPath); err != nil {
+		logger.Warn("failed to reset update attempt", slog.String("error", err.Error()))
+	}
+}
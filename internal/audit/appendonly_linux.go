@@ -0,0 +1,40 @@
+//go:build linux
+
+package audit
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	fsIocGetFlags = 0x80086601
+	fsIocSetFlags = 0x40086602
+	fsAppendFl    = 0x00000020
+)
+
+// setAppendOnly sets the filesystem append-only attribute (chattr +a).
+func setAppendOnly(f *os.File) error {
+	return changeAppendOnly(f, true)
+}
+
+// clearAppendOnly clears the append-only attribute (chattr -a).
+func clearAppendOnly(f *os.File) error {
+	return changeAppendOnly(f, false)
+}
+
+func changeAppendOnly(f *os.File, on bool) error {
+	var flags int32
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocGetFlags, uintptr(unsafe.Pointer(&flags))); errno != 0 {
+		return errno
+	}
+	if (flags&fsAppendFl != 0) == on {
+		return nil
+	}
+	flags ^= fsAppendFl
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), fsIocSetFlags, uintptr(unsafe.Pointer(&flags))); errno != 0 {
+		return errno
+	}
+	return nil
+}
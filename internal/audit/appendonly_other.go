@@ -0,0 +1,15 @@
+//go:build !linux
+
+package audit
+
+import "os"
+
+// setAppendOnly is a no-op on platforms without chattr-style attributes.
+func setAppendOnly(f *os.File) error {
+	return nil
+}
+
+// clearAppendOnly is a no-op on platforms without chattr-style attributes.
+func clearAppendOnly(f *os.File) error {
+	return nil
+}
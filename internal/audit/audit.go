@@ -0,0 +1,566 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// markAppendOnly is replaced in tests, where the attribute would stop the
+// temporary directory from being removed.
+var markAppendOnly = setAppendOnly
+
+// maxRecordSize bounds a single audit line when reading the log back.
+const maxRecordSize = 1 << 20
+
+// Record describes one request handled by the service. Records are chained:
+// each carries the hash of the previous record, so removing, reordering or
+// editing any record breaks the chain from that point on.
+type Record struct {
+	Seq     uint64 `json:"seq"`
+	JobID   string `json:"job_id"`
+	PeerUID uint32 `json:"peer_uid"`
+	PeerPID int32  `json:"peer_pid"`
+	PeerExe string `json:"peer_exe,omitempty"`
+	// Listener names the listener the request arrived on; empty for the
+	// main socket.
+	Listener    string    `json:"listener,omitempty"`
+	Action      string    `json:"action,omitempty"`
+	Command     string    `json:"command,omitempty"`
+	Cwd         string    `json:"cwd,omitempty"`
+	EnvKeys     []string  `json:"env_keys,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	ExitCode    *int      `json:"exit_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	StdoutBytes int64     `json:"stdout_bytes"`
+	StderrBytes int64     `json:"stderr_bytes"`
+	// Recording is the path of the output recording, if one was made.
+	Recording string `json:"recording,omitempty"`
+	PrevHash  string `json:"prev_hash"`
+	Hash      string `json:"hash"`
+}
+
+// Sink receives completed audit records.
+type Sink interface {
+	Write(rec *Record) error
+}
+
+// computeHash returns the hex SHA-256 of the record's JSON encoding with
+// the Hash field cleared. PrevHash is part of the encoding, which is what
+// links each record to its predecessor.
+func computeHash(rec Record) (string, error) {
+	rec.Hash = ""
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return "", fmt.Errorf("encoding record: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Log is an append-only, hash-chained audit log file. Once the file
+// reaches maxSize it is rotated: renamed with the sequence number of its
+// last record as a suffix, and replaced by a file whose first record, a
+// RotatedAction marker, continues the chain.
+type Log struct {
+	mu       sync.Mutex
+	f        *os.File
+	path     string
+	seq      uint64
+	lastHash string
+	// size is the current file's length.
+	size     int64
+	maxSize  int64
+	maxFiles int
+}
+
+// TornTailAction marks the record Open writes after a torn final line,
+// such as one left by a crash mid-write. Verify accepts one unparseable
+// line only when the next record is such a marker.
+const TornTailAction = "torn_tail"
+
+// RotatedAction marks the first record of a file started by rotation. It
+// links to the last record of the previous file, so Verify accepts it in
+// place of seq 1 at the start of a file.
+const RotatedAction = "rotated"
+
+// readBlockSize is how much of the log readLast reads at a time.
+const readBlockSize = 64 * 1024
+
+// tail describes the end of an existing log.
+type tail struct {
+	// last is the last valid record, or nil if there is none.
+	last *Record
+	// torn is the length of an unparseable final line, or 0.
+	torn int
+	// unterminated is set if the file does not end with a newline.
+	unterminated bool
+}
+
+// Open opens (or creates) the audit log at path and resumes the chain from
+// its last record. The file is created with mode 0600 and, where the
+// filesystem supports it, marked append-only so existing records cannot be
+// rewritten even by root without first clearing the flag.
+//
+// The log is rotated once it reaches maxSize bytes, and only the newest
+// maxFiles rotated files are kept. Zero disables either limit.
+//
+// A torn final line cannot be removed from an append-only file, so it is
+// left in place: the chain resumes from the record before it and a
+// TornTailAction record noting the discarded bytes is appended.
+func Open(path string, maxSize int64, maxFiles int) (*Log, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("creating audit directory: %w", err)
+	}
+
+	end, err := readTail(path)
+	if err != nil {
+		return nil, err
+	}
+	// An empty log continues the newest rotated file, in case the
+	// service stopped between renaming the old file and writing the
+	// marker to the new one.
+	rotated := false
+	if end.last == nil && end.torn == 0 {
+		files, err := RotatedFiles(path)
+		if err != nil {
+			return nil, err
+		}
+		if len(files) > 0 {
+			if end, err = readTail(files[len(files)-1]); err != nil {
+				return nil, err
+			}
+			rotated = end.last != nil
+			end.torn, end.unterminated = 0, false
+		}
+	}
+
+	f, size, err := openLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{f: f, path: path, size: size, maxSize: maxSize, maxFiles: maxFiles}
+	if end.last != nil {
+		l.seq = end.last.Seq
+		l.lastHash = end.last.Hash
+	}
+	if end.unterminated {
+		if _, err := f.Write([]byte{'\n'}); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("terminating audit log: %w", err)
+		}
+		l.size++
+	}
+	if rotated {
+		if err := l.writeMarker(RotatedAction, ""); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	if end.torn > 0 {
+		if err := l.writeMarker(TornTailAction, fmt.Sprintf("discarded a torn record of %d bytes after seq %d", end.torn, l.seq)); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// openLogFile opens path for appending, creating it if needed, marks it
+// append-only and returns its size.
+func openLogFile(path string) (*os.File, int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, 0, fmt.Errorf("opening audit log: %w", err)
+	}
+	// Best effort: fails on filesystems without attribute support or
+	// without CAP_LINUX_IMMUTABLE.
+	_ = markAppendOnly(f)
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, fmt.Errorf("opening audit log: %w", err)
+	}
+	return f, info.Size(), nil
+}
+
+// writeMarker appends a record the log writes about itself.
+func (l *Log) writeMarker(action, note string) error {
+	now := time.Now().UTC()
+	return l.writeLocked(&Record{Action: action, Start: now, End: now, Error: note})
+}
+
+// RotatedFiles returns the rotated files of the log at path, oldest first.
+func RotatedFiles(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("listing rotated audit logs: %w", err)
+	}
+	type rotatedFile struct {
+		path string
+		seq  uint64
+	}
+	var files []rotatedFile
+	for _, m := range matches {
+		seq, err := strconv.ParseUint(strings.TrimPrefix(m, path+"."), 10, 64)
+		if err == nil {
+			files = append(files, rotatedFile{m, seq})
+		}
+	}
+	slices.SortFunc(files, func(a, b rotatedFile) int { return cmp.Compare(a.seq, b.seq) })
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths, nil
+}
+
+// readTail finds the last record in the log, reading backwards from the
+// end so startup does not scan the whole file. Only the final line may be
+// unparseable; anything earlier is reported as an error.
+func readTail(path string) (*tail, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &tail{}, nil
+		}
+		return nil, fmt.Errorf("opening audit log: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("reading audit log: %w", err)
+	}
+
+	r := &backwardReader{f: f, off: info.Size()}
+	end := &tail{}
+	for first := true; ; first = false {
+		line, ok, err := r.line()
+		if err != nil {
+			return nil, fmt.Errorf("reading audit log: %w", err)
+		}
+		if !ok {
+			return end, nil
+		}
+		if first {
+			end.unterminated = len(line) > 0
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			continue
+		}
+
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			if end.torn > 0 {
+				return nil, fmt.Errorf("parsing audit record before the torn tail: %w", err)
+			}
+			end.torn = len(line)
+			continue
+		}
+		end.last = &rec
+		return end, nil
+	}
+}
+
+// backwardReader returns the lines of a file from last to first.
+type backwardReader struct {
+	f *os.File
+	// off is the start of the data already read into buf.
+	off  int64
+	buf  []byte
+	done bool
+}
+
+// line returns the line before those already returned, without its
+// newline, and false once the start of the file has been passed. The
+// first call returns whatever follows the final newline, usually nothing.
+func (r *backwardReader) line() ([]byte, bool, error) {
+	for {
+		if i := bytes.LastIndexByte(r.buf, '\n'); i >= 0 {
+			line := r.buf[i+1:]
+			r.buf = r.buf[:i]
+			return line, true, nil
+		}
+		if r.off == 0 {
+			if r.done {
+				return nil, false, nil
+			}
+			r.done = true
+			return r.buf, true, nil
+		}
+		if len(r.buf) > maxRecordSize {
+			return nil, false, fmt.Errorf("record longer than %d bytes", maxRecordSize)
+		}
+
+		n := min(int64(readBlockSize), r.off)
+		r.off -= n
+		chunk := make([]byte, int(n)+len(r.buf))
+		if _, err := r.f.ReadAt(chunk[:n], r.off); err != nil {
+			return nil, false, err
+		}
+		copy(chunk[n:], r.buf)
+		r.buf = chunk
+	}
+}
+
+// Path returns the path of the log file.
+func (l *Log) Path() string {
+	return l.path
+}
+
+// Write assigns the record its sequence number and chain hashes, then
+// appends it to the log and syncs it to disk, rotating the log first if it
+// has reached its maximum size.
+func (l *Log) Write(rec *Record) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxSize > 0 && l.size >= l.maxSize {
+		if err := l.rotate(); err != nil {
+			return fmt.Errorf("rotating audit log: %w", err)
+		}
+	}
+	return l.writeLocked(rec)
+}
+
+func (l *Log) writeLocked(rec *Record) error {
+	rec.Seq = l.seq + 1
+	rec.PrevHash = l.lastHash
+	hash, err := computeHash(*rec)
+	if err != nil {
+		return err
+	}
+	rec.Hash = hash
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encoding record: %w", err)
+	}
+	data = append(data, '\n')
+	if _, err := l.f.Write(data); err != nil {
+		return fmt.Errorf("writing audit record: %w", err)
+	}
+	if err := l.f.Sync(); err != nil {
+		return fmt.Errorf("syncing audit log: %w", err)
+	}
+
+	l.seq = rec.Seq
+	l.lastHash = rec.Hash
+	l.size += int64(len(data))
+	return nil
+}
+
+// rotate renames the current file after its last record, starts a new one
+// with a RotatedAction marker and removes the oldest rotated files beyond
+// maxFiles. The append-only attribute prevents renaming and removal, so
+// it is cleared for those steps and set again on the rotated file.
+func (l *Log) rotate() error {
+	rotated := fmt.Sprintf("%s.%d", l.path, l.seq)
+	_ = clearAppendOnly(l.f)
+	renameErr := os.Rename(l.path, rotated)
+	if renameErr != nil {
+		// Keep writing to the current file.
+		_ = markAppendOnly(l.f)
+		return renameErr
+	}
+
+	f, size, err := openLogFile(l.path)
+	if err != nil {
+		// The old descriptor still appends to the renamed file.
+		_ = markAppendOnly(l.f)
+		return err
+	}
+	_ = markAppendOnly(l.f)
+	l.f.Close()
+	l.f, l.size = f, size
+	if err := l.writeMarker(RotatedAction, ""); err != nil {
+		return err
+	}
+
+	if l.maxFiles <= 0 {
+		return nil
+	}
+	files, err := RotatedFiles(l.path)
+	if err != nil {
+		return err
+	}
+	for _, old := range files[:max(len(files)-l.maxFiles, 0)] {
+		if err := removeLogFile(old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeLogFile removes a rotated log, clearing its append-only attribute.
+func removeLogFile(path string) error {
+	if f, err := os.Open(path); err == nil {
+		_ = clearAppendOnly(f)
+		f.Close()
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing rotated audit log: %w", err)
+	}
+	return nil
+}
+
+// Close closes the log file.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// ErrChainBroken is wrapped by verification errors caused by tampering.
+var ErrChainBroken = errors.New("audit chain broken")
+
+// VerifyResult summarizes a successful verification.
+type VerifyResult struct {
+	Records int
+	// FirstSeq is the sequence number of the first record. A log that
+	// starts with a RotatedAction marker continues the chain of the file
+	// before it from PrevHash.
+	FirstSeq uint64
+	PrevHash string
+	LastSeq  uint64
+	LastHash string
+	// TornTails counts torn lines followed by a TornTailAction record.
+	TornTails int
+}
+
+// Verify reads a log and checks that sequence numbers are consecutive, each
+// record's hash matches its contents, and each record links to the hash of
+// its predecessor, starting from seq 1 with an empty previous hash or from
+// a RotatedAction marker. Errors identify the first offending line. A line
+// that does not parse is accepted only if the next record is a
+// TornTailAction marker.
+//
+// Truncation of the most recent records cannot be detected from the file
+// alone; compare LastSeq/LastHash with a previously recorded value (or a
+// remote copy) to catch that.
+func Verify(r io.Reader) (*VerifyResult, error) {
+	res := &VerifyResult{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxRecordSize)
+
+	var prevHash string
+	var prevSeq uint64
+	var tornLine int
+	var tornErr error
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var rec Record
+		if err := json.Unmarshal(line, &rec); err != nil {
+			if tornLine != 0 {
+				break
+			}
+			// Possibly a torn write; acceptable only if the next record
+			// is the marker Open writes after one.
+			tornLine, tornErr = lineNo, err
+			continue
+		}
+		if tornLine != 0 {
+			if rec.Action != TornTailAction {
+				break
+			}
+			tornLine = 0
+			res.TornTails++
+		}
+		if res.Records == 0 {
+			if rec.Action == RotatedAction && rec.Seq > 0 {
+				prevSeq, prevHash = rec.Seq-1, rec.PrevHash
+			}
+			res.FirstSeq, res.PrevHash = rec.Seq, rec.PrevHash
+		}
+
+		if rec.Seq != prevSeq+1 {
+			return res, fmt.Errorf("line %d: %w: sequence gap (expected %d, got %d)", lineNo, ErrChainBroken, prevSeq+1, rec.Seq)
+		}
+		if rec.PrevHash != prevHash {
+			return res, fmt.Errorf("line %d (seq %d): %w: previous hash mismatch", lineNo, rec.Seq, ErrChainBroken)
+		}
+		want, err := computeHash(rec)
+		if err != nil {
+			return res, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if rec.Hash != want {
+			return res, fmt.Errorf("line %d (seq %d): %w: record hash mismatch (contents modified)", lineNo, rec.Seq, ErrChainBroken)
+		}
+
+		prevHash = rec.Hash
+		prevSeq = rec.Seq
+		res.Records++
+		res.LastSeq = rec.Seq
+		res.LastHash = rec.Hash
+	}
+	if tornLine != 0 {
+		return res, fmt.Errorf("line %d: %w: invalid record: %v", tornLine, ErrChainBroken, tornErr)
+	}
+	if err := scanner.Err(); err != nil {
+		return res, fmt.Errorf("reading audit log: %w", err)
+	}
+	return res, nil
+}
+
+// VerifyFiles verifies the log at path together with its rotated files,
+// oldest first, and checks that each file continues the chain of the one
+// before it. Only the oldest file kept may start from a RotatedAction
+// marker whose predecessor was removed. The result covers every file.
+func VerifyFiles(path string) (*VerifyResult, error) {
+	files, err := RotatedFiles(path)
+	if err != nil {
+		return &VerifyResult{}, err
+	}
+	total := &VerifyResult{}
+	for i, file := range append(files, path) {
+		res, err := verifyFile(file)
+		if i == 0 {
+			total.FirstSeq, total.PrevHash = res.FirstSeq, res.PrevHash
+		}
+		if err == nil && i > 0 && res.Records > 0 &&
+			(res.FirstSeq != total.LastSeq+1 || res.PrevHash != total.LastHash) {
+			err = fmt.Errorf("%w: does not continue the chain of the previous file (seq %d)", ErrChainBroken, total.LastSeq)
+		}
+		total.Records += res.Records
+		total.TornTails += res.TornTails
+		if res.Records > 0 {
+			total.LastSeq, total.LastHash = res.LastSeq, res.LastHash
+		}
+		if err != nil {
+			return total, fmt.Errorf("%s: %w", file, err)
+		}
+	}
+	return total, nil
+}
+
+func verifyFile(path string) (*VerifyResult, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return &VerifyResult{}, err
+	}
+	defer f.Close()
+	return Verify(f)
+}
@@ -0,0 +1,282 @@
+package audit
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	markAppendOnly = func(*os.File) error { return nil }
+	os.Exit(m.Run())
+}
+
+func writeRecords(t *testing.T, path string, n int) {
+	t.Helper()
+	l, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	defer l.Close()
+
+	for i := 0; i < n; i++ {
+		code := i
+		rec := &Record{
+			JobID:    "job",
+			PeerUID:  1000,
+			Command:  "echo " + strings.Repeat("x", i),
+			Start:    time.Now().UTC(),
+			End:      time.Now().UTC(),
+			ExitCode: &code,
+		}
+		if err := l.Write(rec); err != nil {
+			t.Fatalf("failed to write record: %v", err)
+		}
+	}
+}
+
+func TestLog_WriteAndVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeRecords(t, path, 3)
+
+	// Reopening resumes the chain
+	writeRecords(t, path, 2)
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	defer f.Close()
+
+	res, err := Verify(f)
+	if err != nil {
+		t.Fatalf("unexpected verification error: %v", err)
+	}
+	if res.Records != 5 || res.LastSeq != 5 {
+		t.Errorf("expected 5 records ending at seq 5, got %+v", res)
+	}
+	if res.LastHash == "" {
+		t.Error("expected last hash")
+	}
+}
+
+func TestVerify_DetectsTampering(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeRecords(t, path, 4)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read log: %v", err)
+	}
+	lines := strings.SplitAfter(strings.TrimSuffix(string(data), "\n"), "\n")
+
+	tests := []struct {
+		name     string
+		modified string
+		wantMsg  string
+	}{
+		{
+			name:     "edited record",
+			modified: strings.Join(lines[:1], "") + strings.Replace(lines[1], "echo x", "echo y", 1) + strings.Join(lines[2:], ""),
+			wantMsg:  "contents modified",
+		},
+		{
+			name:     "deleted record",
+			modified: lines[0] + strings.Join(lines[2:], ""),
+			wantMsg:  "sequence gap",
+		},
+		{
+			name:     "deleted first record",
+			modified: strings.Join(lines[1:], ""),
+			wantMsg:  "sequence gap",
+		},
+		{
+			name:     "reordered records",
+			modified: lines[0] + lines[2] + lines[1] + lines[3],
+			wantMsg:  "sequence gap",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Verify(strings.NewReader(tt.modified))
+			if err == nil {
+				t.Fatal("expected verification to fail")
+			}
+			if !errors.Is(err, ErrChainBroken) {
+				t.Errorf("expected ErrChainBroken, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.wantMsg) {
+				t.Errorf("expected %q in error, got %v", tt.wantMsg, err)
+			}
+		})
+	}
+}
+
+func TestVerify_Empty(t *testing.T) {
+	res, err := Verify(bytes.NewReader(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if res.Records != 0 {
+		t.Errorf("expected 0 records, got %d", res.Records)
+	}
+}
+
+func TestOpen_TornLastRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeRecords(t, path, 2)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	f.WriteString(`{"seq":3,"job_`)
+	f.Close()
+
+	// The torn record is kept, a marker chains on from seq 2, and new
+	// records follow the marker.
+	writeRecords(t, path, 1)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := Verify(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected verification error: %v", err)
+	}
+	if res.Records != 4 || res.LastSeq != 4 || res.TornTails != 1 {
+		t.Errorf("expected 4 records with one torn tail, got %+v", res)
+	}
+	if !bytes.Contains(data, []byte(`"action":"`+TornTailAction+`"`)) {
+		t.Error("expected a torn tail marker in the log")
+	}
+
+	// Reopening again must not add another marker.
+	writeRecords(t, path, 1)
+	f2, _ := os.Open(path)
+	defer f2.Close()
+	if res, err := Verify(f2); err != nil || res.TornTails != 1 || res.LastSeq != 5 {
+		t.Errorf("after reopening: %+v, %v", res, err)
+	}
+}
+
+func TestOpen_CorruptEarlierRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeRecords(t, path, 1)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	f.WriteString("{\"seq\":2,\"job_\n{garbage\n")
+	f.Close()
+
+	if _, err := Open(path, 0, 0); err == nil {
+		t.Error("expected error for a corrupt record before the last line")
+	}
+}
+
+func TestOpen_ResumesAcrossBlocks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	// The log is several read blocks long, so lines straddle blocks.
+	writeRecords(t, path, 400)
+
+	l, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to reopen log: %v", err)
+	}
+	defer l.Close()
+	if l.seq != 400 {
+		t.Errorf("resumed at seq %d, want 400", l.seq)
+	}
+}
+
+func TestVerify_TornLineWithoutMarker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeRecords(t, path, 2)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := bytes.SplitAfter(data, []byte("\n"))
+	// Replace the first record with garbage: the next record is not a
+	// marker, so this is tampering rather than a torn write.
+	tampered := append([]byte("{\"seq\":1,\n"), lines[1]...)
+	if _, err := Verify(bytes.NewReader(tampered)); !errors.Is(err, ErrChainBroken) {
+		t.Errorf("expected ErrChainBroken, got %v", err)
+	}
+}
+
+func TestLog_Rotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, 1024, 2)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	for i := 0; i < 40; i++ {
+		now := time.Now().UTC()
+		if err := l.Write(&Record{Command: "echo " + strings.Repeat("x", 40), Start: now, End: now}); err != nil {
+			t.Fatalf("failed to write record: %v", err)
+		}
+	}
+	l.Close()
+
+	files, err := RotatedFiles(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("expected 2 rotated files kept, got %v", files)
+	}
+	for _, file := range append(files, path) {
+		if info, err := os.Stat(file); err != nil || info.Size() > 1024+512 {
+			t.Errorf("%s: expected a file near the size limit, got %v %v", file, info, err)
+		}
+	}
+
+	// The oldest kept file starts from a marker whose predecessor is gone
+	res, err := VerifyFiles(path)
+	if err != nil {
+		t.Fatalf("unexpected verification error: %v", err)
+	}
+	if res.FirstSeq <= 1 || res.LastSeq != l.seq {
+		t.Errorf("expected a chain from a rotated marker to seq %d, got %+v", l.seq, res)
+	}
+
+	// Removing a file in the middle breaks the chain
+	if err := os.Remove(files[1]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := VerifyFiles(path); !errors.Is(err, ErrChainBroken) {
+		t.Errorf("expected a broken chain without the middle file, got %v", err)
+	}
+}
+
+func TestOpen_ResumesFromRotatedFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	writeRecords(t, path, 3)
+	// The service stopped after renaming the log, before the new file
+	// was written.
+	if err := os.Rename(path, path+".3"); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	l.Close()
+	if l.seq != 4 {
+		t.Errorf("expected a rotated marker at seq 4, got seq %d", l.seq)
+	}
+	res, err := VerifyFiles(path)
+	if err != nil || res.Records != 4 {
+		t.Errorf("expected 4 verified records, got %+v, %v", res, err)
+	}
+}
@@ -0,0 +1,250 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultQueryLimit and MaxQueryLimit bound the number of records a query
+// returns.
+const (
+	DefaultQueryLimit = 100
+	MaxQueryLimit     = 1000
+)
+
+// Query filters history records. Zero-valued fields do not filter.
+type Query struct {
+	// Since and Until bound the record's start time (inclusive).
+	Since time.Time
+	Until time.Time
+	// ExitCode matches records with exactly this exit code.
+	ExitCode *int
+	// Failed matches records that errored or exited non-zero.
+	Failed bool
+	// PeerUID and PeerPID match the connecting process.
+	PeerUID *uint32
+	PeerPID *int32
+	// PeerExe matches the peer's executable path exactly.
+	PeerExe string
+	// Command matches records whose (redacted) command or action contains
+	// this substring.
+	Command string
+	// Limit caps the number of records returned, newest first. Zero means
+	// DefaultQueryLimit; negative means no limit.
+	Limit int
+}
+
+func (q *Query) matches(rec *Record) bool {
+	if !q.Since.IsZero() && rec.Start.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && rec.Start.After(q.Until) {
+		return false
+	}
+	if q.ExitCode != nil && (rec.ExitCode == nil || *rec.ExitCode != *q.ExitCode) {
+		return false
+	}
+	if q.Failed && rec.Error == "" && (rec.ExitCode == nil || *rec.ExitCode == 0) {
+		return false
+	}
+	if q.PeerUID != nil && rec.PeerUID != *q.PeerUID {
+		return false
+	}
+	if q.PeerPID != nil && rec.PeerPID != *q.PeerPID {
+		return false
+	}
+	if q.PeerExe != "" && rec.PeerExe != q.PeerExe {
+		return false
+	}
+	if q.Command != "" && !strings.Contains(rec.Command, q.Command) && !strings.Contains(rec.Action, q.Command) {
+		return false
+	}
+	return true
+}
+
+// historyBlock is the number of records covered by one index entry.
+const historyBlock = 256
+
+// indexEntry describes one block of consecutive records in the log.
+type indexEntry struct {
+	// offset is where the block's first record starts.
+	offset int64
+	// records is the number of lines in the block.
+	records int
+	// minStart is the earliest start time in the block.
+	minStart time.Time
+	// maxEnd is the latest end time in this block and every block before
+	// it, so a query can stop at the first block that ends too early.
+	maxEnd time.Time
+}
+
+// History answers queries over the audit log file through a sparse index
+// of record offsets and times. The index holds one entry per block of
+// records rather than the records themselves, so it stays small however
+// long the log grows, and a query reads only the blocks that can match.
+// The index is built by the first query, not when the service starts, and
+// new records are indexed on the next query. Only the current log file is
+// indexed; records in rotated files are not returned.
+type History struct {
+	mu     sync.Mutex
+	path   string
+	blocks []indexEntry
+	// size is the number of bytes of the log indexed so far, and file
+	// the log they were read from.
+	size int64
+	file os.FileInfo
+}
+
+// NewHistory returns a History over the audit log at path. Nothing is
+// read until the first query, and a missing log yields no records until
+// it is created.
+func NewHistory(path string) *History {
+	return &History{path: path}
+}
+
+// recordTimes is the part of a record the index needs.
+type recordTimes struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+}
+
+// update indexes records appended since the last call. A final line
+// without a newline is still being written (or is torn) and is left for
+// a later call. Lines that do not parse, such as a torn record followed
+// by a marker, are skipped when read.
+func (h *History) update() error {
+	f, err := os.Open(h.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("opening audit log: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("reading audit log: %w", err)
+	}
+	if h.file != nil && (!os.SameFile(h.file, info) || info.Size() < h.size) {
+		// The log was rotated or replaced; start over.
+		h.blocks, h.size = nil, 0
+	}
+	h.file = info
+
+	br := bufio.NewReaderSize(io.NewSectionReader(f, h.size, info.Size()-h.size), 64*1024)
+	for {
+		line, err := br.ReadSlice('\n')
+		if errors.Is(err, bufio.ErrBufferFull) {
+			// A long record: gather the rest of the line.
+			full := append([]byte(nil), line...)
+			for errors.Is(err, bufio.ErrBufferFull) && len(full) <= maxRecordSize {
+				line, err = br.ReadSlice('\n')
+				full = append(full, line...)
+			}
+			line = full
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			if errors.Is(err, bufio.ErrBufferFull) {
+				return fmt.Errorf("reading audit log: record at offset %d longer than %d bytes", h.size, maxRecordSize)
+			}
+			return fmt.Errorf("reading audit log: %w", err)
+		}
+
+		var times recordTimes
+		_ = json.Unmarshal(line, &times)
+		h.index(h.size, times)
+		h.size += int64(len(line))
+	}
+}
+
+// index adds the record at offset to the last block, starting a new one
+// when it is full.
+func (h *History) index(offset int64, times recordTimes) {
+	if n := len(h.blocks); n == 0 || h.blocks[n-1].records >= historyBlock {
+		b := indexEntry{offset: offset}
+		if n > 0 {
+			b.maxEnd = h.blocks[n-1].maxEnd
+		}
+		h.blocks = append(h.blocks, b)
+	}
+	b := &h.blocks[len(h.blocks)-1]
+	b.records++
+	// A line that did not parse has no times; a block holding one is
+	// never skipped by Until.
+	if times.Start.IsZero() || b.records == 1 || times.Start.Before(b.minStart) {
+		b.minStart = times.Start
+	}
+	if times.End.After(b.maxEnd) {
+		b.maxEnd = times.End
+	}
+}
+
+// Query returns matching records, newest first. more reports that further
+// records matched beyond the limit.
+func (h *History) Query(q Query) (records []Record, more bool, err error) {
+	limit := q.Limit
+	if limit == 0 {
+		limit = DefaultQueryLimit
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if err := h.update(); err != nil {
+		return nil, false, err
+	}
+	if len(h.blocks) == 0 {
+		return nil, false, nil
+	}
+
+	f, err := os.Open(h.path)
+	if err != nil {
+		return nil, false, fmt.Errorf("opening audit log: %w", err)
+	}
+	defer f.Close()
+
+	end := h.size
+	for i := len(h.blocks) - 1; i >= 0; i-- {
+		b := h.blocks[i]
+		start := b.offset
+		blockEnd := end
+		end = start
+
+		// A record starts no later than it ends, so nothing here or in
+		// earlier blocks can start after Since.
+		if !q.Since.IsZero() && b.maxEnd.Before(q.Since) {
+			break
+		}
+		if !q.Until.IsZero() && b.minStart.After(q.Until) {
+			continue
+		}
+
+		data := make([]byte, blockEnd-start)
+		if _, err := f.ReadAt(data, start); err != nil {
+			return nil, false, fmt.Errorf("reading audit log: %w", err)
+		}
+		lines := bytes.Split(bytes.TrimSuffix(data, []byte{'\n'}), []byte{'\n'})
+		for j := len(lines) - 1; j >= 0; j-- {
+			var rec Record
+			if json.Unmarshal(lines[j], &rec) != nil || !q.matches(&rec) {
+				continue
+			}
+			if limit > 0 && len(records) >= limit {
+				return records, true, nil
+			}
+			records = append(records, rec)
+		}
+	}
+	return records, false, nil
+}
@@ -0,0 +1,155 @@
+package audit
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// historyLog writes records to a new audit log and returns its path.
+func historyLog(t *testing.T, records ...*Record) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	defer l.Close()
+	for _, rec := range records {
+		if err := l.Write(rec); err != nil {
+			t.Fatalf("failed to write record: %v", err)
+		}
+	}
+	return path
+}
+
+func querySeqs(t *testing.T, h *History, q Query) ([]uint64, bool) {
+	t.Helper()
+	records, more, err := h.Query(q)
+	if err != nil {
+		t.Fatalf("query failed: %v", err)
+	}
+	seqs := make([]uint64, len(records))
+	for i, rec := range records {
+		seqs[i] = rec.Seq
+	}
+	return seqs, more
+}
+
+func TestHistory_Query(t *testing.T) {
+	base := time.Date(2026, 1, 2, 3, 0, 0, 0, time.UTC)
+	var records []*Record
+	for i := 0; i < 10; i++ {
+		code := i % 3
+		records = append(records, &Record{
+			PeerUID:  uint32(1000 + i%2),
+			PeerPID:  int32(100 + i),
+			Command:  "systemctl restart php8." + string(rune('0'+i)),
+			Start:    base.Add(time.Duration(i) * time.Minute),
+			End:      base.Add(time.Duration(i)*time.Minute + time.Second),
+			ExitCode: &code,
+		})
+	}
+	records = append(records, &Record{Action: "update", Start: base.Add(20 * time.Minute), End: base.Add(21 * time.Minute), Error: "download failed"})
+	h := NewHistory(historyLog(t, records...))
+
+	zero := 0
+	uid := uint32(1001)
+	pid := int32(104)
+	tests := []struct {
+		name string
+		q    Query
+		want []uint64
+		more bool
+	}{
+		{"default newest first", Query{}, []uint64{11, 10, 9, 8, 7, 6, 5, 4, 3, 2, 1}, false},
+		{"limit", Query{Limit: 2}, []uint64{11, 10}, true},
+		{"since", Query{Since: base.Add(8 * time.Minute)}, []uint64{11, 10, 9}, false},
+		{"until", Query{Until: base.Add(1 * time.Minute)}, []uint64{2, 1}, false},
+		{"exit code", Query{ExitCode: &zero}, []uint64{10, 7, 4, 1}, false},
+		{"failed", Query{Failed: true, Since: base.Add(6 * time.Minute)}, []uint64{11, 9, 8}, false},
+		{"peer uid", Query{PeerUID: &uid, Limit: 3}, []uint64{10, 8, 6}, true},
+		{"peer pid", Query{PeerPID: &pid}, []uint64{5}, false},
+		{"command", Query{Command: "php8.3"}, []uint64{4}, false},
+		{"action", Query{Command: "update"}, []uint64{11}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, more := querySeqs(t, h, tt.q)
+			if len(got) != len(tt.want) {
+				t.Fatalf("expected %v, got %v", tt.want, got)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("record %d: expected seq %d, got %d", i, tt.want[i], got[i])
+				}
+			}
+			if more != tt.more {
+				t.Errorf("more = %v, want %v", more, tt.more)
+			}
+		})
+	}
+}
+
+func TestHistory_ManyBlocks(t *testing.T) {
+	base := time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)
+	n := historyBlock*4 + 7
+	records := make([]*Record, n)
+	for i := range records {
+		start := base.Add(time.Duration(i) * time.Minute)
+		records[i] = &Record{Command: "true", Start: start, End: start.Add(time.Second)}
+	}
+	h := NewHistory(historyLog(t, records...))
+	if len(h.blocks) != 0 {
+		t.Errorf("expected the index to wait for the first query, got %d blocks", len(h.blocks))
+	}
+
+	// Every record is reachable, including the oldest.
+	got, _ := querySeqs(t, h, Query{Until: base.Add(2 * time.Minute)})
+	if len(got) != 3 || got[2] != 1 {
+		t.Errorf("oldest records = %v, want seqs 3, 2, 1", got)
+	}
+	if len(h.blocks) != 5 {
+		t.Errorf("expected 5 index blocks, got %d", len(h.blocks))
+	}
+	got, _ = querySeqs(t, h, Query{
+		Since: base.Add(time.Duration(historyBlock) * time.Minute),
+		Until: base.Add(time.Duration(historyBlock+1) * time.Minute),
+	})
+	if want := uint64(historyBlock + 1); len(got) != 2 || got[1] != want {
+		t.Errorf("block boundary = %v, want seqs %d and %d", got, want+1, want)
+	}
+	if got, _ := querySeqs(t, h, Query{Limit: -1}); len(got) != n {
+		t.Errorf("unlimited query returned %d records, want %d", len(got), n)
+	}
+}
+
+func TestHistory_SeesNewRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	h := NewHistory(path)
+	if got, _ := querySeqs(t, h, Query{}); len(got) != 0 {
+		t.Errorf("expected no records, got %v", got)
+	}
+
+	writeRecords(t, path, 2)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A record still being written is not returned until complete.
+	f.WriteString(`{"seq":3,"job_`)
+	f.Close()
+
+	if got, _ := querySeqs(t, h, Query{}); len(got) != 2 || got[0] != 2 {
+		t.Errorf("expected seqs 2 and 1, got %v", got)
+	}
+
+	// Reopening the log marks the torn line, which queries skip.
+	writeRecords(t, path, 1)
+	got, _ := querySeqs(t, h, Query{})
+	if len(got) != 4 || got[0] != 4 || got[1] != 3 {
+		t.Errorf("expected seqs 4 to 1, got %v", got)
+	}
+}
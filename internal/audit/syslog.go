@@ -0,0 +1,541 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultSpoolPath is where records wait while the syslog collector is
+// unreachable.
+const DefaultSpoolPath = "/var/lib/vito-root/syslog.spool"
+
+// defaultMaxSpool bounds the spool so an unreachable collector cannot fill
+// the disk.
+const defaultMaxSpool = 64 << 20
+
+const (
+	// syslogFacility is LOG_AUTHPRIV, used for security/authorization
+	// messages that should not go to world-readable logs.
+	syslogFacility = 10
+	sevWarning     = 4
+	sevNotice      = 5
+
+	syslogAppName = "vito-root-service"
+	syslogMsgID   = "audit"
+	// syslogSDID uses the enterprise number reserved for documentation
+	// (RFC 5612), as the service has no registered number of its own.
+	syslogSDID = "vito@32473"
+
+	// maxUDPMessage is the largest message sent over UDP. RFC 5426 asks
+	// receivers to accept 2048 octets; anything longer risks EMSGSIZE or
+	// being dropped, so longer messages are truncated.
+	maxUDPMessage = 2048
+
+	dialTimeout   = 5 * time.Second
+	writeTimeout  = 10 * time.Second
+	retryInterval = 10 * time.Second
+)
+
+// SyslogOptions configure a SyslogSink.
+type SyslogOptions struct {
+	// URL is the collector address: udp://host:port, tcp://host:port or
+	// tls://host:port.
+	URL string
+	// CAFile verifies the collector's certificate (tls only). The system
+	// roots are used when empty.
+	CAFile string
+	// CertFile and KeyFile present a client certificate (tls only).
+	CertFile string
+	KeyFile  string
+	// SpoolPath holds records not yet delivered. Defaults to
+	// DefaultSpoolPath.
+	SpoolPath string
+	// MaxSpool is the spool size limit in bytes. Defaults to 64 MiB.
+	MaxSpool int64
+	// Hostname overrides the HOSTNAME field.
+	Hostname string
+	Logger   *slog.Logger
+}
+
+// SyslogSink forwards audit records to a remote collector as RFC 5424
+// messages. Every record is first appended to a local spool file and then
+// delivered by a background sender, so records written while the collector
+// is unreachable are sent, in order, once it is back. Delivery is
+// at-least-once: a record may be resent after a crash or reconnect, and
+// collectors can deduplicate on the seq and hash parameters.
+//
+// TCP and TLS use octet-counting framing (RFC 6587, RFC 5425). UDP has no
+// delivery feedback, so records sent over UDP may be lost silently, and
+// messages longer than 2048 bytes are truncated.
+type SyslogSink struct {
+	network  string
+	addr     string
+	tls      *tls.Config
+	hostname string
+	logger   *slog.Logger
+	maxSpool int64
+
+	mu     sync.Mutex
+	spool  *os.File
+	size   int64
+	offset int64
+
+	conn   net.Conn
+	failed bool
+
+	wake chan struct{}
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// ParseSyslogURL splits a collector URL into network and address.
+func ParseSyslogURL(raw string) (network, addr string, err error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid syslog URL %q: %w", raw, err)
+	}
+	switch u.Scheme {
+	case "udp", "tcp", "tls":
+	default:
+		return "", "", fmt.Errorf("invalid syslog URL %q: scheme must be udp, tcp or tls", raw)
+	}
+	if u.Hostname() == "" || u.Port() == "" {
+		return "", "", fmt.Errorf("invalid syslog URL %q: host and port are required", raw)
+	}
+	return u.Scheme, u.Host, nil
+}
+
+// Validate checks the collector URL and loads the TLS certificates, without
+// connecting or opening the spool.
+func (o SyslogOptions) Validate() error {
+	network, addr, err := ParseSyslogURL(o.URL)
+	if err != nil {
+		return err
+	}
+	if network == "tls" {
+		_, err = clientTLSConfig(addr, o)
+	}
+	return err
+}
+
+// NewSyslogSink opens the spool and starts the background sender.
+func NewSyslogSink(opts SyslogOptions) (*SyslogSink, error) {
+	network, addr, err := ParseSyslogURL(opts.URL)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SyslogSink{
+		network:  network,
+		addr:     addr,
+		hostname: opts.Hostname,
+		logger:   opts.Logger,
+		maxSpool: opts.MaxSpool,
+		wake:     make(chan struct{}, 1),
+		done:     make(chan struct{}),
+	}
+	if s.logger == nil {
+		s.logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	if s.maxSpool <= 0 {
+		s.maxSpool = defaultMaxSpool
+	}
+	if s.hostname == "" {
+		s.hostname, _ = os.Hostname()
+	}
+	if network == "tls" {
+		if s.tls, err = clientTLSConfig(addr, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	spoolPath := opts.SpoolPath
+	if spoolPath == "" {
+		spoolPath = DefaultSpoolPath
+	}
+	if err := os.MkdirAll(filepath.Dir(spoolPath), 0700); err != nil {
+		return nil, fmt.Errorf("creating spool directory: %w", err)
+	}
+	s.spool, err = os.OpenFile(spoolPath, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("opening syslog spool: %w", err)
+	}
+	info, err := s.spool.Stat()
+	if err != nil {
+		s.spool.Close()
+		return nil, fmt.Errorf("opening syslog spool: %w", err)
+	}
+	s.size = info.Size()
+
+	s.wg.Add(1)
+	go s.run()
+	s.notify()
+	return s, nil
+}
+
+func clientTLSConfig(addr string, opts SyslogOptions) (*tls.Config, error) {
+	host, _, _ := net.SplitHostPort(addr)
+	cfg := &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("reading syslog CA: %w", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", opts.CAFile)
+		}
+	}
+	if opts.CertFile != "" || opts.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading syslog client certificate: %w", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Write appends the record to the spool and wakes the sender. It fails
+// only if the spool cannot be written, e.g. because it is full.
+func (s *SyslogSink) Write(rec *Record) error {
+	msg, err := formatSyslog(rec, s.hostname, os.Getpid())
+	if err != nil {
+		return err
+	}
+	frame := strconv.Itoa(len(msg)) + " " + msg
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size+int64(len(frame)) > s.maxSpool {
+		return fmt.Errorf("syslog spool full (%d bytes), record %d not forwarded", s.size, rec.Seq)
+	}
+	if _, err := s.spool.WriteAt([]byte(frame), s.size); err != nil {
+		return fmt.Errorf("writing syslog spool: %w", err)
+	}
+	s.size += int64(len(frame))
+	s.notify()
+	return nil
+}
+
+func (s *SyslogSink) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Close stops the sender after a final delivery attempt.
+func (s *SyslogSink) Close() error {
+	close(s.done)
+	s.wg.Wait()
+	s.drain()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.Close()
+	}
+	return s.spool.Close()
+}
+
+func (s *SyslogSink) run() {
+	defer s.wg.Done()
+	ticker := time.NewTicker(retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.wake:
+		case <-ticker.C:
+		}
+		s.drain()
+	}
+}
+
+// drain sends spooled frames until the spool is empty or sending fails.
+func (s *SyslogSink) drain() {
+	for {
+		s.mu.Lock()
+		offset, size := s.offset, s.size
+		if offset == size {
+			// Everything delivered: reclaim the space.
+			if size > 0 {
+				if err := s.spool.Truncate(0); err == nil {
+					s.offset, s.size = 0, 0
+				}
+			}
+			s.mu.Unlock()
+			return
+		}
+		s.mu.Unlock()
+
+		msg, n, err := readSpoolFrame(s.spool, offset, size)
+		if err != nil {
+			next := nextFrame(s.spool, offset, size)
+			s.logger.Error("corrupt syslog spool, skipping to the next intact record",
+				slog.Int64("bytes", next-offset),
+				slog.String("error", err.Error()))
+			s.mu.Lock()
+			s.offset = next
+			s.mu.Unlock()
+			continue
+		}
+
+		if err := s.send(msg); err != nil {
+			if !s.failed {
+				s.logger.Warn("syslog collector unreachable, spooling audit records",
+					slog.String("collector", s.addr),
+					slog.String("error", err.Error()))
+				s.failed = true
+			}
+			return
+		}
+		if s.failed {
+			s.logger.Info("syslog collector reachable again, sending spooled records",
+				slog.String("collector", s.addr))
+			s.failed = false
+		}
+
+		s.mu.Lock()
+		s.offset += n
+		s.mu.Unlock()
+	}
+}
+
+// readFrame reads one octet-counted frame of at most max bytes, returning
+// the message and the number of bytes consumed.
+func readFrame(r io.Reader, max int64) (string, int64, error) {
+	br := bufio.NewReader(r)
+	prefix, err := br.ReadString(' ')
+	if err != nil {
+		return "", 0, fmt.Errorf("reading frame length: %w", err)
+	}
+	length, err := strconv.ParseInt(strings.TrimSuffix(prefix, " "), 10, 64)
+	if err != nil || length <= 0 || length > max {
+		return "", 0, fmt.Errorf("invalid frame length %q", prefix)
+	}
+	msg := make([]byte, length)
+	if _, err := io.ReadFull(br, msg); err != nil {
+		return "", 0, fmt.Errorf("reading frame: %w", err)
+	}
+	if msg[0] != '<' {
+		return "", 0, fmt.Errorf("frame does not hold a syslog message")
+	}
+	return string(msg), int64(len(prefix)) + length, nil
+}
+
+// frameStart matches the start of a spooled frame: the octet count and the
+// PRI and VERSION of the message.
+var (
+	frameStart   = regexp.MustCompile(`[1-9][0-9]{0,9} <[0-9]{1,3}>1 `)
+	frameStartAt = regexp.MustCompile(`^` + frameStart.String())
+)
+
+// readSpoolFrame reads the frame at off in a spool ending at end. A frame
+// is intact only if another frame or the end of the spool follows it, so
+// a torn frame whose count runs into the next one is rejected.
+func readSpoolFrame(r io.ReaderAt, off, end int64) (string, int64, error) {
+	msg, n, err := readFrame(io.NewSectionReader(r, off, end-off), end-off)
+	if err != nil {
+		return "", 0, err
+	}
+	if off+n < end {
+		var next [32]byte
+		k, _ := r.ReadAt(next[:min(int64(len(next)), end-off-n)], off+n)
+		if !frameStartAt.Match(next[:k]) {
+			return "", 0, fmt.Errorf("frame of %d bytes runs into the next one", n)
+		}
+	}
+	return msg, n, nil
+}
+
+// nextFrame returns the offset of the first intact frame in the spool
+// after the corrupt one at from, or end if there is none. Only records
+// damaged by a torn write are lost, not everything spooled after them.
+func nextFrame(r io.ReaderAt, from, end int64) int64 {
+	const block = 64 << 10
+	// overlap keeps a frame start split across blocks matchable.
+	const overlap = 32
+	buf := make([]byte, block)
+	for pos := from + 1; pos < end; pos += block - overlap {
+		n, err := r.ReadAt(buf[:min(block, end-pos)], pos)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return end
+		}
+		for _, loc := range frameStart.FindAllIndex(buf[:n], -1) {
+			start := pos + int64(loc[0])
+			// A match inside a longer number is not a frame start.
+			var prev [1]byte
+			if _, err := r.ReadAt(prev[:], start-1); err == nil && prev[0] >= '0' && prev[0] <= '9' {
+				continue
+			}
+			if _, _, err := readSpoolFrame(r, start, end); err == nil {
+				return start
+			}
+		}
+	}
+	return end
+}
+
+// send delivers one message, connecting first if needed. Any error drops
+// the connection so the next attempt reconnects.
+func (s *SyslogSink) send(msg string) error {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+
+	data := msg
+	if s.network == "udp" {
+		data = truncateMessage(msg, maxUDPMessage)
+	} else {
+		data = strconv.Itoa(len(msg)) + " " + msg
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := io.WriteString(s.conn, data); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// truncateMessage cuts msg to at most max bytes without splitting a UTF-8
+// sequence. The header and structured data, which carry seq and hash, come
+// first, so only the JSON body is lost.
+func truncateMessage(msg string, max int) string {
+	if len(msg) <= max {
+		return msg
+	}
+	cut := max
+	for cut > 0 && !utf8.RuneStart(msg[cut]) {
+		cut--
+	}
+	return msg[:cut]
+}
+
+func (s *SyslogSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: dialTimeout}
+	switch s.network {
+	case "tls":
+		return tls.DialWithDialer(dialer, "tcp", s.addr, s.tls)
+	default:
+		return dialer.Dial(s.network, s.addr)
+	}
+}
+
+// formatSyslog renders a record as an RFC 5424 message. Key fields are
+// carried as structured data; the full record is the JSON message body.
+func formatSyslog(rec *Record, hostname string, pid int) (string, error) {
+	body, err := json.Marshal(rec)
+	if err != nil {
+		return "", fmt.Errorf("encoding record: %w", err)
+	}
+
+	severity := sevNotice
+	if rec.Error != "" || (rec.ExitCode != nil && *rec.ExitCode != 0) {
+		severity = sevWarning
+	}
+
+	ts := rec.End
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+
+	params := [][2]string{
+		{"seq", strconv.FormatUint(rec.Seq, 10)},
+		{"job_id", rec.JobID},
+		{"peer_uid", strconv.FormatUint(uint64(rec.PeerUID), 10)},
+		{"peer_pid", strconv.FormatInt(int64(rec.PeerPID), 10)},
+	}
+	if rec.Action != "" {
+		params = append(params, [2]string{"action", rec.Action})
+	}
+	if rec.ExitCode != nil {
+		params = append(params, [2]string{"exit_code", strconv.Itoa(*rec.ExitCode)})
+	}
+	if rec.Hash != "" {
+		params = append(params, [2]string{"hash", rec.Hash})
+	}
+
+	var sd strings.Builder
+	sd.WriteString("[" + syslogSDID)
+	for _, p := range params {
+		sd.WriteString(" " + p[0] + `="` + escapeSDValue(p[1]) + `"`)
+	}
+	sd.WriteString("]")
+
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		syslogFacility*8+severity,
+		ts.UTC().Format("2006-01-02T15:04:05.000000Z07:00"),
+		headerField(hostname, 255),
+		syslogAppName,
+		pid,
+		syslogMsgID,
+		sd.String(),
+		body,
+	), nil
+}
+
+// escapeSDValue escapes the characters RFC 5424 reserves in PARAM-VALUE.
+func escapeSDValue(v string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(v)
+}
+
+// headerField returns v restricted to printable US-ASCII without spaces,
+// truncated to max, or the NILVALUE "-" if empty.
+func headerField(v string, max int) string {
+	v = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, v)
+	if len(v) > max {
+		v = v[:max]
+	}
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// Multi returns a Sink that writes each record to every sink in order. The
+// hash-chained Log should come first so later sinks see the assigned seq
+// and hash. Errors from all sinks are joined.
+func Multi(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+type multiSink []Sink
+
+func (m multiSink) Write(rec *Record) error {
+	var errs []error
+	for _, s := range m {
+		if err := s.Write(rec); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
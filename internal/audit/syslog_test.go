@@ -0,0 +1,408 @@
+package audit
+
+import (
+	"bufio"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func testRecord(seq uint64, exitCode int) *Record {
+	return &Record{
+		Seq:      seq,
+		JobID:    "job" + string(rune('0'+seq)),
+		PeerUID:  1000,
+		PeerPID:  42,
+		Command:  "echo hi",
+		End:      time.Date(2026, 1, 2, 3, 4, 5, 678901000, time.UTC),
+		ExitCode: &exitCode,
+		Hash:     "abc",
+	}
+}
+
+// readFrames reads octet-counted frames from a stream connection.
+func readFrames(t *testing.T, conn net.Conn, n int) []string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	br := bufio.NewReader(conn)
+	var msgs []string
+	for i := 0; i < n; i++ {
+		msg, _, err := readFrame(br, 1<<20)
+		if err != nil {
+			t.Fatalf("failed to read frame %d: %v", i, err)
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs
+}
+
+func TestFormatSyslog(t *testing.T) {
+	rec := testRecord(7, 2)
+	rec.JobID = `a"b]c`
+
+	msg, err := formatSyslog(rec, "web 1", 99)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	wantHeader := `<84>1 2026-01-02T03:04:05.678901Z web1 vito-root-service 99 audit [vito@32473 seq="7" job_id="a\"b\]c" peer_uid="1000" peer_pid="42" exit_code="2" hash="abc"] {`
+	if !strings.HasPrefix(msg, wantHeader) {
+		t.Errorf("unexpected message:\n got %s\nwant %s...", msg, wantHeader)
+	}
+
+	ok, _ := formatSyslog(testRecord(1, 0), "", 1)
+	if !strings.HasPrefix(ok, "<85>1 ") || !strings.Contains(ok, " - vito-root-service ") {
+		t.Errorf("expected notice severity and nil hostname, got %s", ok)
+	}
+}
+
+func TestParseSyslogURL(t *testing.T) {
+	for _, raw := range []string{"udp://10.0.0.1:514", "tcp://logs:601", "tls://logs.example.com:6514"} {
+		if _, _, err := ParseSyslogURL(raw); err != nil {
+			t.Errorf("ParseSyslogURL(%q): unexpected error %v", raw, err)
+		}
+	}
+	for _, raw := range []string{"http://logs:514", "tcp://logs", "logs:514"} {
+		if _, _, err := ParseSyslogURL(raw); err == nil {
+			t.Errorf("ParseSyslogURL(%q): expected error", raw)
+		}
+	}
+}
+
+func TestSyslogOptions_Validate(t *testing.T) {
+	dir := t.TempDir()
+	_, caFile := selfSignedCert(t, dir)
+
+	if err := (SyslogOptions{URL: "tls://logs.example.com:6514", CAFile: caFile}).Validate(); err != nil {
+		t.Errorf("Validate: unexpected error %v", err)
+	}
+	if err := (SyslogOptions{URL: "tls://logs.example.com:6514", CAFile: filepath.Join(dir, "missing.pem")}).Validate(); err == nil {
+		t.Error("Validate: expected error for a missing CA file")
+	}
+	if err := (SyslogOptions{URL: "tcp://logs"}).Validate(); err == nil {
+		t.Error("Validate: expected error for a URL without a port")
+	}
+}
+
+func TestSyslogSink_TCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	sink, err := NewSyslogSink(SyslogOptions{
+		URL:       "tcp://" + l.Addr().String(),
+		SpoolPath: filepath.Join(t.TempDir(), "spool"),
+	})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	defer sink.Close()
+
+	sink.Write(testRecord(1, 0))
+	sink.Write(testRecord(2, 1))
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+	defer conn.Close()
+
+	msgs := readFrames(t, conn, 2)
+	if !strings.Contains(msgs[0], `seq="1"`) || !strings.Contains(msgs[1], `seq="2"`) {
+		t.Errorf("unexpected messages: %v", msgs)
+	}
+}
+
+func TestSyslogSink_UDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer pc.Close()
+
+	sink, err := NewSyslogSink(SyslogOptions{
+		URL:       "udp://" + pc.LocalAddr().String(),
+		SpoolPath: filepath.Join(t.TempDir(), "spool"),
+	})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	defer sink.Close()
+
+	sink.Write(testRecord(1, 0))
+
+	buf := make([]byte, 65536)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("failed to read datagram: %v", err)
+	}
+	if msg := string(buf[:n]); !strings.HasPrefix(msg, "<85>1 ") {
+		t.Errorf("expected unframed message, got %q", msg)
+	}
+}
+
+func TestSyslogSink_UDPTruncatesLongRecords(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer pc.Close()
+
+	sink, err := NewSyslogSink(SyslogOptions{
+		URL:       "udp://" + pc.LocalAddr().String(),
+		SpoolPath: filepath.Join(t.TempDir(), "spool"),
+	})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	defer sink.Close()
+
+	long := testRecord(1, 0)
+	long.Command = strings.Repeat("é", 100000)
+	sink.Write(long)
+	sink.Write(testRecord(2, 0))
+
+	buf := make([]byte, 65536)
+	for seq := 1; seq <= 2; seq++ {
+		pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("failed to read datagram %d: %v", seq, err)
+		}
+		msg := buf[:n]
+		if n > maxUDPMessage || !utf8.Valid(msg) {
+			t.Errorf("datagram %d: %d bytes, valid UTF-8 %v", seq, n, utf8.Valid(msg))
+		}
+		if want := `seq="` + strconv.Itoa(seq) + `"`; !strings.Contains(string(msg), want) {
+			t.Errorf("datagram %d does not carry %s", seq, want)
+		}
+	}
+}
+
+func TestSyslogSink_TLS(t *testing.T) {
+	dir := t.TempDir()
+	cert, caFile := selfSignedCert(t, dir)
+
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	sink, err := NewSyslogSink(SyslogOptions{
+		URL:       "tls://" + l.Addr().String(),
+		CAFile:    caFile,
+		SpoolPath: filepath.Join(dir, "spool"),
+	})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	defer sink.Close()
+
+	sink.Write(testRecord(1, 0))
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+	defer conn.Close()
+
+	if msgs := readFrames(t, conn, 1); !strings.Contains(msgs[0], `seq="1"`) {
+		t.Errorf("unexpected message: %v", msgs)
+	}
+}
+
+func TestSyslogSink_SpoolsWhileUnreachable(t *testing.T) {
+	// Reserve a port, then close it so the collector is down.
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	spool := filepath.Join(t.TempDir(), "spool")
+	sink, err := NewSyslogSink(SyslogOptions{URL: "tcp://" + addr, SpoolPath: spool})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	for seq := uint64(1); seq <= 3; seq++ {
+		if err := sink.Write(testRecord(seq, 0)); err != nil {
+			t.Fatalf("write failed: %v", err)
+		}
+	}
+	sink.Close()
+
+	if info, err := os.Stat(spool); err != nil || info.Size() == 0 {
+		t.Fatalf("expected records in spool, got %v %v", info, err)
+	}
+
+	// Collector comes back; a new sink (e.g. after restart) delivers the
+	// spooled records in order.
+	l, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("could not re-listen on %s: %v", addr, err)
+	}
+	defer l.Close()
+
+	sink, err = NewSyslogSink(SyslogOptions{URL: "tcp://" + addr, SpoolPath: spool})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	defer sink.Close()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+	defer conn.Close()
+
+	msgs := readFrames(t, conn, 3)
+	for i, msg := range msgs {
+		if want := `seq="` + string(rune('1'+i)) + `"`; !strings.Contains(msg, want) {
+			t.Errorf("message %d: expected %s, got %s", i, want, msg)
+		}
+	}
+}
+
+func TestSyslogSink_SkipsTornFrame(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	defer l.Close()
+
+	frame := func(seq uint64) string {
+		msg, err := formatSyslog(testRecord(seq, 0), "host", 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return strconv.Itoa(len(msg)) + " " + msg
+	}
+	// A write torn part way through record 2, followed by records spooled
+	// after a restart.
+	torn := frame(2)
+	data := frame(1) + torn[:len(torn)/2] + frame(3) + "9999999999 <85>1 " + frame(4)
+	spool := filepath.Join(t.TempDir(), "spool")
+	if err := os.WriteFile(spool, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	sink, err := NewSyslogSink(SyslogOptions{URL: "tcp://" + l.Addr().String(), SpoolPath: spool})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	defer sink.Close()
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatalf("failed to accept: %v", err)
+	}
+	defer conn.Close()
+
+	msgs := readFrames(t, conn, 3)
+	for i, seq := range []string{"1", "3", "4"} {
+		// Only the first structured data element counts: a torn frame
+		// would carry the next record's text in its body.
+		if _, sd, _ := strings.Cut(msgs[i], "[vito@32473 "); !strings.HasPrefix(sd, `seq="`+seq+`"`) {
+			t.Errorf("message %d: expected seq %s, got %s", i, seq, msgs[i])
+		}
+	}
+}
+
+func TestSyslogSink_SpoolLimit(t *testing.T) {
+	sink, err := NewSyslogSink(SyslogOptions{
+		URL:       "tcp://127.0.0.1:1",
+		SpoolPath: filepath.Join(t.TempDir(), "spool"),
+		MaxSpool:  100,
+	})
+	if err != nil {
+		t.Fatalf("failed to create sink: %v", err)
+	}
+	defer sink.Close()
+
+	if err := sink.Write(testRecord(1, 0)); err == nil || !strings.Contains(err.Error(), "spool full") {
+		t.Errorf("expected spool full error, got %v", err)
+	}
+}
+
+func TestMulti(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	l, err := Open(path, 0, 0)
+	if err != nil {
+		t.Fatalf("failed to open log: %v", err)
+	}
+	defer l.Close()
+
+	var seen *Record
+	sink := Multi(l, sinkFunc(func(rec *Record) error {
+		seen = rec
+		return nil
+	}))
+	if err := sink.Write(&Record{JobID: "j"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if seen == nil || seen.Seq != 1 || seen.Hash == "" {
+		t.Errorf("expected later sink to see chained record, got %+v", seen)
+	}
+}
+
+type sinkFunc func(*Record) error
+
+func (f sinkFunc) Write(rec *Record) error { return f(rec) }
+
+func selfSignedCert(t *testing.T, dir string) (tls.Certificate, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v", err)
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("failed to marshal key: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(caFile, certPEM, 0600); err != nil {
+		t.Fatalf("failed to write CA: %v", err)
+	}
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("failed to load key pair: %v", err)
+	}
+	return cert, caFile
+}
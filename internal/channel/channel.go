@@ -0,0 +1,257 @@
+// Package channel selects the releases self-updates move to: stable, beta,
+// a pinned version or a semantic version constraint, with versions ordered
+// by semantic versioning precedence.
+package channel
+
+import (
+	"cmp"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Channels that follow the newest release.
+const (
+	// Stable follows the release GitHub marks as latest, which is
+	// never a prerelease.
+	Stable = "stable"
+	// Beta follows the newest release, prereleases included.
+	Beta = "beta"
+)
+
+// Channel selects the release the updater moves to. The zero value is
+// the stable channel.
+type Channel struct {
+	spec string
+	// pin is the tag of a pinned version.
+	pin string
+	// lower and upper bound a constraint: lower <= v < upper.
+	lower, upper *version
+}
+
+// Parse parses an update channel:
+//
+//   - "stable" (or "") and "beta".
+//   - An exact version such as "v1.4.2" or "1.4.2-beta.1", which pins
+//     the service to that release, downgrading if necessary.
+//   - A constraint on releases: "~1.4" allows 1.4.x, "~1" allows 1.x,
+//     "^1.4" allows 1.4 up to 2.0 (and "^0.4" allows 0.4.x). Constraints
+//     never select prereleases.
+func Parse(s string) (Channel, error) {
+	spec := strings.TrimSpace(s)
+	switch spec {
+	case "", Stable:
+		return Channel{}, nil
+	case Beta:
+		return Channel{spec: Beta}, nil
+	}
+
+	invalid := func(err error) (Channel, error) {
+		return Channel{}, fmt.Errorf("invalid update channel %q: %w", s, err)
+	}
+
+	if op := spec[0]; op == '~' || op == '^' {
+		nums, err := parseNumbers(normalizeVersion(spec[1:]))
+		if err != nil {
+			return invalid(err)
+		}
+		// ~ allows changes below the given minor version (or major, if
+		// only that is given); ^ allows changes below the first non-zero
+		// part.
+		bump := 0
+		if op == '~' && len(nums) > 1 {
+			bump = 1
+		}
+		if op == '^' {
+			bump = len(nums) - 1
+			for i, n := range nums {
+				if n != 0 {
+					bump = i
+					break
+				}
+			}
+		}
+		lower := &version{nums: append(nums, make([]int, 3-len(nums))...)}
+		upper := &version{nums: make([]int, 3)}
+		copy(upper.nums, lower.nums[:bump])
+		upper.nums[bump] = lower.nums[bump] + 1
+		return Channel{spec: spec, lower: lower, upper: upper}, nil
+	}
+
+	v := normalizeVersion(strings.TrimPrefix(spec, "="))
+	core, _, _ := strings.Cut(strings.SplitN(v, "+", 2)[0], "-")
+	if nums, err := parseNumbers(core); err != nil {
+		return invalid(err)
+	} else if len(nums) != 3 {
+		return invalid(fmt.Errorf("a pinned version needs major, minor and patch"))
+	}
+	return Channel{spec: spec, pin: "v" + v}, nil
+}
+
+// parseNumbers parses one to three dot-separated non-negative integers.
+func parseNumbers(s string) ([]int, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("too many version parts in %q", s)
+	}
+	nums := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("want stable, beta, a version such as v1.4.2 or a constraint such as ~1.4")
+		}
+		nums[i] = n
+	}
+	return nums, nil
+}
+
+// String returns the channel as configured.
+func (c Channel) String() string {
+	if c.spec == "" {
+		return Stable
+	}
+	return c.spec
+}
+
+// Latest reports whether c is the stable channel, which follows the
+// release GitHub marks as latest.
+func (c Channel) Latest() bool {
+	return c.spec == ""
+}
+
+// Pinned returns the tag of the pinned release, or "" if c follows newer
+// releases.
+func (c Channel) Pinned() string {
+	return c.pin
+}
+
+// Allows reports whether c may select the release tagged tag, which GitHub
+// may mark as a prerelease.
+func (c Channel) Allows(tag string, prerelease bool) bool {
+	v := normalizeVersion(tag)
+	if v == "" || v[0] < '0' || v[0] > '9' {
+		return false
+	}
+	switch {
+	case c.pin != "":
+		return tag == c.pin
+	case c.lower != nil:
+		parsed := parseVersion(v)
+		return !prerelease && len(parsed.pre) == 0 &&
+			compareVersions(parsed, *c.lower) >= 0 && compareVersions(parsed, *c.upper) < 0
+	case c.spec == Beta:
+		return true
+	}
+	return !prerelease
+}
+
+// Wants reports whether moving from current to the release tagged tag is
+// an update on this channel: any change for a pinned version, otherwise
+// only a newer version.
+func (c Channel) Wants(current, tag string) bool {
+	if c.pin != "" {
+		return normalizeVersion(current) != normalizeVersion(tag)
+	}
+	return isNewerVersion(normalizeVersion(current), normalizeVersion(tag))
+}
+
+// Compare returns -1, 0 or +1 as the version tagged a ranks below, equal
+// to or above the one tagged b.
+func Compare(a, b string) int {
+	return compareVersions(parseVersion(normalizeVersion(a)), parseVersion(normalizeVersion(b)))
+}
+
+// normalizeVersion removes the "v" prefix and any leading/trailing whitespace.
+func normalizeVersion(version string) string {
+	v := strings.TrimSpace(version)
+	v = strings.TrimPrefix(v, "v")
+	return v
+}
+
+// isNewerVersion returns true if latest is newer than current, ordering
+// versions by semantic versioning precedence: a prerelease such as
+// 1.4.0-beta.2 ranks below 1.4.0 and above 1.4.0-beta.1.
+func isNewerVersion(current, latest string) bool {
+	// Handle "dev" or empty versions - always consider updates available
+	if current == "" || current == "dev" {
+		return latest != "" && latest != "dev"
+	}
+	return compareVersions(parseVersion(latest), parseVersion(current)) > 0
+}
+
+// version is a parsed semver-like version.
+type version struct {
+	// nums are the dot-separated numeric parts, e.g. [1 4 0].
+	nums []int
+	// pre are the dot-separated prerelease identifiers, e.g. ["beta" "2"];
+	// empty for a release.
+	pre []string
+}
+
+// parseVersion parses a version string such as "1.4.0-beta.2". Build
+// metadata after "+" is ignored.
+func parseVersion(v string) version {
+	v, _, _ = strings.Cut(v, "+")
+	core, pre, hasPre := strings.Cut(v, "-")
+
+	var result version
+	for _, part := range strings.Split(core, ".") {
+		var num int
+		_, _ = fmt.Sscanf(part, "%d", &num) // Ignore error; non-numeric parts become 0
+		result.nums = append(result.nums, num)
+	}
+	if hasPre {
+		result.pre = strings.Split(pre, ".")
+	}
+	return result
+}
+
+// compareVersions returns -1, 0 or +1 as a ranks below, equal to or
+// above b. Missing numeric parts count as 0, so 1.4 equals 1.4.0.
+func compareVersions(a, b version) int {
+	for i := 0; i < max(len(a.nums), len(b.nums)); i++ {
+		if c := cmp.Compare(partAt(a.nums, i), partAt(b.nums, i)); c != 0 {
+			return c
+		}
+	}
+
+	// A release ranks above its prereleases.
+	switch {
+	case len(a.pre) == 0 && len(b.pre) == 0:
+		return 0
+	case len(a.pre) == 0:
+		return 1
+	case len(b.pre) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.pre) && i < len(b.pre); i++ {
+		if c := comparePrerelease(a.pre[i], b.pre[i]); c != 0 {
+			return c
+		}
+	}
+	// 1.4.0-beta ranks below 1.4.0-beta.1.
+	return cmp.Compare(len(a.pre), len(b.pre))
+}
+
+func partAt(nums []int, i int) int {
+	if i < len(nums) {
+		return nums[i]
+	}
+	return 0
+}
+
+// comparePrerelease compares two prerelease identifiers: numeric ones
+// numerically, below alphanumeric ones, which compare in ASCII order.
+func comparePrerelease(a, b string) int {
+	an, aErr := strconv.ParseUint(a, 10, 64)
+	bn, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		return cmp.Compare(an, bn)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
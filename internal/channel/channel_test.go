@@ -0,0 +1,157 @@
+package channel
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		spec    string
+		allowed []string
+		denied  []string
+	}{
+		{"", []string{"v1.4.0"}, []string{"v1.5.0-beta.1"}},
+		{"stable", []string{"v1.4.0"}, []string{"v1.5.0-beta.1"}},
+		{"beta", []string{"v1.4.0", "v1.5.0-beta.1"}, []string{"nightly"}},
+		{"v1.4.2", []string{"v1.4.2"}, []string{"v1.4.3", "v1.4.2-rc.1"}},
+		{"1.5.0-beta.1", []string{"v1.5.0-beta.1"}, []string{"v1.5.0"}},
+		{"~1.4", []string{"v1.4.0", "v1.4.9", "1.4.12"}, []string{"v1.3.9", "v1.5.0", "v1.4.10-beta.1"}},
+		{"~1.4.2", []string{"v1.4.2", "v1.4.3"}, []string{"v1.4.1", "v1.5.0"}},
+		{"~1", []string{"v1.0.0", "v1.9.3"}, []string{"v2.0.0", "v0.9.0"}},
+		{"^1.4", []string{"v1.4.0", "v1.9.0"}, []string{"v1.3.0", "v2.0.0"}},
+		{"^0.4", []string{"v0.4.0", "v0.4.7"}, []string{"v0.5.0", "v0.3.9"}},
+		{"^0.0.3", []string{"v0.0.3"}, []string{"v0.0.4"}},
+	}
+	for _, tt := range tests {
+		ch, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		for _, tag := range tt.allowed {
+			if !ch.Allows(tag, strings.Contains(tag, "-")) {
+				t.Errorf("channel %q should allow %s", tt.spec, tag)
+			}
+		}
+		for _, tag := range tt.denied {
+			if ch.Allows(tag, strings.Contains(tag, "-")) {
+				t.Errorf("channel %q should not allow %s", tt.spec, tag)
+			}
+		}
+	}
+
+	for _, spec := range []string{"nightly", "~", "~1.x", "^1.2.3.4", "1.4", "v1", "~-1"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) should fail", spec)
+		}
+	}
+
+	if ch, _ := Parse("1.4.2"); ch.Pinned() != "v1.4.2" || ch.String() != "1.4.2" {
+		t.Errorf("pinned channel = %q (%s), want tag v1.4.2", ch.Pinned(), ch)
+	}
+	if ch, _ := Parse(""); ch.String() != Stable {
+		t.Errorf("default channel = %s, want stable", ch)
+	}
+}
+
+func TestNormalizeVersion(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"v1.2.3", "1.2.3"},
+		{"1.2.3", "1.2.3"},
+		{"  v1.2.3  ", "1.2.3"},
+		{"v0.1.0", "0.1.0"},
+		{"", ""},
+	}
+
+	for _, tc := range tests {
+		result := normalizeVersion(tc.input)
+		if result != tc.expected {
+			t.Errorf("normalizeVersion(%q) = %q, expected %q", tc.input, result, tc.expected)
+		}
+	}
+}
+
+func TestIsNewerVersion(t *testing.T) {
+	tests := []struct {
+		current  string
+		latest   string
+		expected bool
+	}{
+		{"1.0.0", "1.0.1", true},
+		{"1.0.0", "1.1.0", true},
+		{"1.0.0", "2.0.0", true},
+		{"1.0.1", "1.0.0", false},
+		{"1.1.0", "1.0.0", false},
+		{"2.0.0", "1.0.0", false},
+		{"1.0.0", "1.0.0", false},
+		{"dev", "1.0.0", true},
+		{"", "1.0.0", true},
+		{"1.0.0", "dev", false},
+		{"0.1.0", "0.1.1", true},
+		{"0.1.10", "0.1.9", false},
+		{"0.1.9", "0.1.10", true},
+		{"1.0", "1.0.0", false},
+		{"1.4.0", "1.4.0-beta.1", false},
+		{"1.4.0-beta.1", "1.4.0", true},
+		{"1.3.9", "1.4.0-beta.1", true},
+		{"1.4.0-beta.1", "1.4.0-beta.2", true},
+		{"1.4.0-beta.2", "1.4.0-beta.10", true},
+		{"1.4.0-beta.10", "1.4.0-beta.2", false},
+		{"1.4.0-beta", "1.4.0-beta.1", true},
+		{"1.4.0-alpha.3", "1.4.0-beta", true},
+		{"1.4.0-beta", "1.4.0-rc.1", true},
+		{"1.4.0-1", "1.4.0-alpha", true},
+		{"1.4.0-rc.1", "1.4.0-rc.1+build.5", false},
+	}
+
+	for _, tc := range tests {
+		result := isNewerVersion(tc.current, tc.latest)
+		if result != tc.expected {
+			t.Errorf("isNewerVersion(%q, %q) = %v, expected %v", tc.current, tc.latest, result, tc.expected)
+		}
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		input string
+		nums  []int
+		pre   []string
+	}{
+		{"1.2.3", []int{1, 2, 3}, nil},
+		{"0.1.0", []int{0, 1, 0}, nil},
+		{"1.0.0-beta", []int{1, 0, 0}, []string{"beta"}},
+		{"1.0.0-rc.1+build.7", []int{1, 0, 0}, []string{"rc", "1"}},
+		{"2.0", []int{2, 0}, nil},
+		{"1", []int{1}, nil},
+	}
+
+	for _, tc := range tests {
+		result := parseVersion(tc.input)
+		if fmt.Sprint(result.nums) != fmt.Sprint(tc.nums) || fmt.Sprint(result.pre) != fmt.Sprint(tc.pre) {
+			t.Errorf("parseVersion(%q) = %v %v, expected %v %v", tc.input, result.nums, result.pre, tc.nums, tc.pre)
+		}
+	}
+}
+
+func TestCompare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.4.10", "v1.4.9", 1},
+		{"v1.5.0-beta.2", "1.5.0-beta.10", -1},
+		{"v1.5.0", "v1.5.0-rc.1", 1},
+		{"v1.4", "1.4.0", 0},
+	}
+	for _, tt := range tests {
+		if got := Compare(tt.a, tt.b); got != tt.want {
+			t.Errorf("Compare(%s, %s) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
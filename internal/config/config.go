@@ -6,20 +6,145 @@ import (
 	"strconv"
 	"strings"
 	"time"
+
+	"vito-local/internal/channel"
+	"vito-local/internal/executor"
+	"vito-local/internal/secrets"
 )
 
+// ParseList splits a comma-separated flag value into trimmed, non-empty entries.
+func ParseList(value string) []string {
+	var result []string
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			result = append(result, part)
+		}
+	}
+	return result
+}
+
 // Config holds the service configuration.
 type Config struct {
-	SocketPath     string
-	AllowedUser    string
-	AllowedUID     uint32
+	// ConfigPath is the configuration file Load read, if any.
+	ConfigPath string
+
+	SocketPath  string
+	AllowedUser string
+	AllowedUID  uint32
+	// SocketOwner, SocketGroup and SocketMode are the ownership and
+	// permissions of the socket file: applied in standalone mode and
+	// verified under socket activation. SocketGroup defaults to the group
+	// named like AllowedUser; either way it must exist.
+	SocketOwner    string
+	SocketOwnerUID uint32
 	SocketGroup    string
 	SocketGroupGID uint32
 	SocketMode     uint32
 	LogLevel       string
 	LogJSON        bool
+	// LogFormat is "text", "json" or "journald".
+	LogFormat      string
 	MaxExecTimeout time.Duration
 	MaxConnections int
+	// IdleTimeout makes a socket-activated server exit after this long
+	// without connections or running jobs; systemd starts it again on the
+	// next connection. Zero keeps it running.
+	IdleTimeout time.Duration
+	// EnvAllowlist enables allowlist mode for client-supplied environment
+	// variables when non-empty. Entries ending in "*" match by prefix.
+	EnvAllowlist []string
+	// SecretsDir holds root-only files referenced by Request.Secrets.
+	SecretsDir string
+	// RedactPatterns are extra regular expressions masked in command output
+	// and logs, in addition to redact.DefaultPatterns.
+	RedactPatterns []string
+	// Policy restricts what executed commands may do.
+	Policy Policy
+	// CommandRateLimit and ActionRateLimit are per-principal budgets in
+	// requests per minute, with bursts up to the matching Burst value.
+	// A principal is a peer UID and, separately, a peer executable.
+	// Zero disables the limit.
+	CommandRateLimit int
+	CommandBurst     int
+	ActionRateLimit  int
+	ActionBurst      int
+	// AbuseThreshold is the number of rejected requests (authorization,
+	// rate limit or policy) from one principal within a minute that triggers a suspicious-activity warning.
+	// Zero disables detection.
+	AbuseThreshold int
+	// AuditLog is the path of the hash-chained audit log. Empty disables it.
+	// The log is rotated at AuditMaxSize bytes, keeping AuditMaxFiles
+	// rotated files. Zero disables either limit.
+	AuditLog      string
+	AuditMaxSize  int64
+	AuditMaxFiles int
+	// AuditSyslog forwards audit records to a remote collector
+	// (udp://, tcp:// or tls://host:port) when set. The CA, client
+	// certificate and key apply to tls:// only. Undelivered records wait
+	// in AuditSyslogSpool.
+	AuditSyslog      string
+	AuditSyslogCA    string
+	AuditSyslogCert  string
+	AuditSyslogKey   string
+	AuditSyslogSpool string
+	// RecordDir enables output recordings (asciicast files) when set.
+	// Recordings beyond RecordMaxSize bytes in total, or older than
+	// RecordMaxAge, are pruned oldest first. Zero disables either limit.
+	RecordDir     string
+	RecordMaxSize int64
+	RecordMaxAge  time.Duration
+	// MetricsAddr serves OpenMetrics at /metrics when set: a Unix socket
+	// path, or a loopback host:port.
+	MetricsAddr string
+	// OTLPEndpoint exports request traces to this OTLP/HTTP collector URL
+	// (e.g. http://127.0.0.1:4318/v1/traces) when set.
+	OTLPEndpoint string
+	// Listeners are additional sockets with their own users, role and
+	// policy, sorted by name.
+	Listeners []Listener
+	// UpdateChannel selects the releases self-updates move to: "stable",
+	// "beta", a pinned version or a constraint such as "~1.4". See
+	// channel.Parse.
+	UpdateChannel string
+
+	// sources maps file keys to where their values came from.
+	sources map[string]string
+}
+
+// Policy restricts what executed commands may do. The zero value imposes
+// no restrictions.
+type Policy struct {
+	// ReadPaths and WritePaths confine commands to these filesystem
+	// subtrees (via Landlock) when either is non-empty. Requests may narrow
+	// the set further but never widen it.
+	ReadPaths  []string
+	WritePaths []string
+	// Capabilities, when non-nil, is the exact set of Linux capabilities
+	// commands keep; everything else is dropped. Requests may ask for a
+	// subset. An empty, non-nil slice drops every capability.
+	Capabilities []string
+}
+
+// Validate checks that all sandbox paths are absolute and all capability
+// names are known.
+func (p Policy) Validate() error {
+	if err := p.Sandbox().Validate(); err != nil {
+		return err
+	}
+	if _, err := executor.ParseCapabilities(p.Capabilities); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Sandbox returns the policy's filesystem sandbox.
+func (p Policy) Sandbox() *executor.Sandbox {
+	return &executor.Sandbox{ReadPaths: p.ReadPaths, WritePaths: p.WritePaths}
+}
+
+// Sandboxed reports whether the policy confines filesystem access.
+func (p Policy) Sandboxed() bool {
+	return len(p.ReadPaths) > 0 || len(p.WritePaths) > 0
 }
 
 var validLogLevels = map[string]bool{
@@ -29,60 +154,171 @@ var validLogLevels = map[string]bool{
 	"error": true,
 }
 
+// Default returns the built-in configuration, before users are resolved.
+func Default() *Config {
+	return &Config{
+		SocketPath:     "/run/vito-root.sock",
+		AllowedUser:    "vito",
+		SocketOwner:    "root",
+		SocketMode:     0660,
+		LogLevel:       "info",
+		LogFormat:      "text",
+		MaxConnections: 100,
+		IdleTimeout:    10 * time.Minute,
+		SecretsDir:     secrets.DefaultDir,
+		AuditLog:       "/var/lib/vito-root/audit.log",
+		AuditMaxSize:   64 << 20,
+		AuditMaxFiles:  10,
+
+		AuditSyslogSpool: "/var/lib/vito-root/syslog.spool",
+		RecordMaxSize:    1 << 30,
+		RecordMaxAge:     30 * 24 * time.Hour,
+
+		CommandRateLimit: 300,
+		CommandBurst:     100,
+		ActionRateLimit:  60,
+		ActionBurst:      20,
+		AbuseThreshold:   30,
+
+		UpdateChannel: channel.Stable,
+	}
+}
+
 // New creates a new Config, resolving the user to a UID.
 func New(socketPath, username, logLevel string, logJSON bool) (*Config, error) {
-	if socketPath == "" {
-		socketPath = "/run/vito-root.sock"
+	c := Default()
+	if socketPath != "" {
+		c.SocketPath = socketPath
+	}
+	c.AllowedUser = username
+	c.LogLevel = logLevel
+	if logJSON {
+		c.LogFormat = "json"
+	}
+	if err := c.resolve(); err != nil {
+		return nil, err
 	}
+	return c, nil
+}
+
+var validLogFormats = map[string]bool{
+	"text":     true,
+	"json":     true,
+	"journald": true,
+}
 
-	if username == "" {
-		return nil, fmt.Errorf("allowed user must be specified")
+// resolve validates the configuration and looks up the allowed user and
+// the socket owner and group.
+func (c *Config) resolve() error {
+	if c.SocketPath == "" {
+		return fmt.Errorf("socket path must be specified")
+	}
+	if c.AllowedUser == "" {
+		return fmt.Errorf("allowed user must be specified")
 	}
 
-	u, err := user.Lookup(username)
+	u, err := user.Lookup(c.AllowedUser)
 	if err != nil {
-		return nil, fmt.Errorf("looking up user %q: %w", username, err)
+		return fmt.Errorf("looking up user %q: %w", c.AllowedUser, err)
 	}
 
 	uid, err := strconv.ParseUint(u.Uid, 10, 32)
 	if err != nil {
-		return nil, fmt.Errorf("parsing UID %q: %w", u.Uid, err)
+		return fmt.Errorf("parsing UID %q: %w", u.Uid, err)
 	}
+	c.AllowedUID = uint32(uid)
 
-	logLevel = strings.ToLower(logLevel)
-	if logLevel == "" {
-		logLevel = "info"
+	c.LogLevel = strings.ToLower(c.LogLevel)
+	if c.LogLevel == "" {
+		c.LogLevel = "info"
 	}
-	if !validLogLevels[logLevel] {
-		return nil, fmt.Errorf("invalid log level %q (valid: debug, info, warn, error)", logLevel)
+	if !validLogLevels[c.LogLevel] {
+		return fmt.Errorf("invalid log level %q (valid: debug, info, warn, error)", c.LogLevel)
 	}
+	if !validLogFormats[c.LogFormat] {
+		return fmt.Errorf("invalid log format %q (valid: text, json, journald)", c.LogFormat)
+	}
+	c.LogJSON = c.LogFormat == "json"
 
-	// Resolve group GID for socket ownership.
-	// Try looking up a group matching the username; fall back to user's primary group.
-	var socketGID uint32
-	grp, grpErr := user.LookupGroup(username)
-	if grpErr == nil {
-		gid, err := strconv.ParseUint(grp.Gid, 10, 32)
-		if err == nil {
-			socketGID = uint32(gid)
-		}
+	if c.MaxConnections <= 0 {
+		return fmt.Errorf("max connections must be positive")
+	}
+
+	if err := c.resolveSocket(); err != nil {
+		return err
+	}
+
+	if err := c.Policy.Validate(); err != nil {
+		return fmt.Errorf("invalid policy: %w", err)
+	}
+	if _, err := channel.Parse(c.UpdateChannel); err != nil {
+		return err
+	}
+	return c.resolveListeners()
+}
+
+// resolveSocket looks up the socket owner and group and checks the mode.
+// The group defaults to the one named like the allowed user; there is no
+// fallback to another group, so a missing group is an error rather than a
+// socket the intended clients cannot open.
+func (c *Config) resolveSocket() error {
+	owner, ownerUID, group, groupGID, err := resolveSocketOwnership(c.SocketOwner, c.SocketGroup, c.AllowedUser)
+	if err != nil {
+		return err
+	}
+	c.SocketOwner, c.SocketOwnerUID, c.SocketGroup, c.SocketGroupGID = owner, ownerUID, group, groupGID
+	return validateSocketMode(c.SocketMode, c.SocketOwner, c.SocketGroup, []string{c.AllowedUser})
+}
+
+// resolveSocketOwnership looks up a socket's owner, defaulting to root,
+// and group, defaulting to the group named defaultGroup.
+func resolveSocketOwnership(owner, group, defaultGroup string) (string, uint32, string, uint32, error) {
+	if owner == "" {
+		owner = "root"
+	}
+	u, err := user.Lookup(owner)
+	if err != nil {
+		return "", 0, "", 0, fmt.Errorf("looking up socket owner %q: %w", owner, err)
 	}
-	if socketGID == 0 && grpErr != nil {
-		gid, err := strconv.ParseUint(u.Gid, 10, 32)
-		if err == nil {
-			socketGID = uint32(gid)
+	uid, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return "", 0, "", 0, fmt.Errorf("parsing UID %q: %w", u.Uid, err)
+	}
+
+	defaulted := group == ""
+	if defaulted {
+		group = defaultGroup
+	}
+	grp, err := user.LookupGroup(group)
+	if err != nil {
+		if defaulted {
+			return "", 0, "", 0, fmt.Errorf("looking up socket group %q (named after the allowed user; set socket_group to choose another): %w", group, err)
 		}
+		return "", 0, "", 0, fmt.Errorf("looking up socket group %q: %w", group, err)
+	}
+	gid, err := strconv.ParseUint(grp.Gid, 10, 32)
+	if err != nil {
+		return "", 0, "", 0, fmt.Errorf("parsing GID %q: %w", grp.Gid, err)
 	}
+	return owner, uint32(uid), group, uint32(gid), nil
+}
 
-	return &Config{
-		SocketPath:     socketPath,
-		AllowedUser:    username,
-		AllowedUID:     uint32(uid),
-		SocketGroup:    username,
-		SocketGroupGID: socketGID,
-		SocketMode:     0660,
-		LogLevel:       logLevel,
-		LogJSON:        logJSON,
-		MaxConnections: 100,
-	}, nil
+// validateSocketMode rejects modes that open a socket to every local
+// user, or that give the group no access while none of the allowed users
+// is the owner.
+func validateSocketMode(mode uint32, owner, group string, users []string) error {
+	if mode&^0777 != 0 {
+		return fmt.Errorf("invalid socket mode %04o", mode)
+	}
+	if mode&0007 != 0 {
+		return fmt.Errorf("socket mode %04o must not grant access to other users; use socket_group instead", mode)
+	}
+	ownerAllowed := false
+	for _, u := range users {
+		ownerAllowed = ownerAllowed || u == owner
+	}
+	if mode&0060 != 0060 && !(ownerAllowed && mode&0600 == 0600) {
+		return fmt.Errorf("socket mode %04o does not let group %q read and write the socket", mode, group)
+	}
+	return nil
 }
@@ -4,6 +4,7 @@ import (
 	"os/user"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestNew_CurrentUser(t *testing.T) {
@@ -38,6 +39,9 @@ func TestNew_CurrentUser(t *testing.T) {
 	if cfg.MaxConnections != 100 {
 		t.Errorf("expected default MaxConnections 100, got %d", cfg.MaxConnections)
 	}
+	if cfg.IdleTimeout != 10*time.Minute {
+		t.Errorf("expected default IdleTimeout 10m, got %v", cfg.IdleTimeout)
+	}
 }
 
 func TestNew_DefaultSocketPath(t *testing.T) {
@@ -132,4 +136,7 @@ func TestNew_LogJSON(t *testing.T) {
 	if !cfg.LogJSON {
 		t.Error("expected LogJSON to be true")
 	}
+	if cfg.LogFormat != "json" {
+		t.Errorf("expected LogFormat json, got %q", cfg.LogFormat)
+	}
 }
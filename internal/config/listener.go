@@ -0,0 +1,319 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Listener roles.
+const (
+	// RoleAdmin may run any command and action, like the main socket.
+	RoleAdmin = "admin"
+	// RoleRestricted may only run the listener's Commands and read-only
+	// actions, without client environment variables or secrets.
+	RoleRestricted = "restricted"
+)
+
+// Listener is an additional socket with its own allowed users, role and
+// policy, configured as a [listener.<name>] table. Listeners are read from
+// configuration files only.
+type Listener struct {
+	// Name identifies the listener in logs and audit records, and matches
+	// FileDescriptorName= of a systemd socket passed for it.
+	Name       string
+	SocketPath string
+	// Users may connect; UIDs are resolved from them.
+	Users []string
+	UIDs  []uint32
+	Role  string
+	// Commands are the exact command lines a restricted listener accepts.
+	// A "*" matches a run of letters, digits and ".-_@", so a pattern can
+	// cover versions or site names but never extra arguments or shell
+	// syntax.
+	Commands []string
+	// SocketOwner, SocketGroup and SocketMode work as for the main socket.
+	// The group defaults to the one named like the first user.
+	SocketOwner    string
+	SocketOwnerUID uint32
+	SocketGroup    string
+	SocketGroupGID uint32
+	SocketMode     uint32
+	// MaxConnections limits the listener's concurrent connections,
+	// counted apart from those of every other socket. It defaults to the
+	// top-level MaxConnections.
+	MaxConnections int
+	// Policy restricts commands run through this listener. Sandbox paths
+	// and capabilities that are not set are taken from the top-level
+	// policy when the configuration is loaded.
+	Policy Policy
+
+	// set records which keys were configured, for policy inheritance.
+	set map[string]bool
+}
+
+// AllowsCommand reports whether a restricted listener accepts command.
+func (l *Listener) AllowsCommand(command string) bool {
+	command = strings.TrimSpace(command)
+	for _, pattern := range l.Commands {
+		if matchCommand(pattern, command) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchCommand matches command against pattern, where "*" stands for a
+// non-empty run of characters accepted by isCommandWordChar. The run may
+// not start with "-", so that a wildcard argument cannot become an option,
+// and may not be "." or "..", so that it cannot leave a directory.
+func matchCommand(pattern, command string) bool {
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return pattern == command
+	}
+	if !strings.HasPrefix(command, pattern[:star]) {
+		return false
+	}
+	command, pattern = command[star:], pattern[star+1:]
+	if strings.HasPrefix(command, "-") {
+		return false
+	}
+	n := 0
+	for n < len(command) && isCommandWordChar(command[n]) {
+		n++
+	}
+	// Try the longest run first, then shorter ones for patterns such as
+	// "php*-fpm" where the run is followed by more word characters.
+	for i := n; i >= 1; i-- {
+		if run := command[:i]; run == "." || run == ".." {
+			continue
+		}
+		if matchCommand(pattern, command[i:]) {
+			return true
+		}
+	}
+	return false
+}
+
+func isCommandWordChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '.' || c == '-' || c == '_' || c == '@'
+}
+
+// listenerFields sets one key of a [listener.<name>] table.
+var listenerFields = map[string]func(l *Listener, v value) error{
+	"socket": func(l *Listener, v value) (err error) {
+		l.SocketPath, err = v.str()
+		return err
+	},
+	"users": func(l *Listener, v value) (err error) {
+		l.Users, err = v.list()
+		return err
+	},
+	"role": func(l *Listener, v value) (err error) {
+		l.Role, err = v.str()
+		return err
+	},
+	"commands": func(l *Listener, v value) (err error) {
+		l.Commands, err = v.list()
+		return err
+	},
+	"socket_owner": func(l *Listener, v value) (err error) {
+		l.SocketOwner, err = v.str()
+		return err
+	},
+	"socket_group": func(l *Listener, v value) (err error) {
+		l.SocketGroup, err = v.str()
+		return err
+	},
+	"socket_mode": func(l *Listener, v value) (err error) {
+		l.SocketMode, err = v.mode()
+		return err
+	},
+	"max_connections": func(l *Listener, v value) error {
+		n, err := v.int()
+		if err != nil {
+			return err
+		}
+		if n <= 0 || n > math.MaxInt32 {
+			return fmt.Errorf("must be between 1 and %d", math.MaxInt32)
+		}
+		l.MaxConnections = int(n)
+		return nil
+	},
+	"sandbox_read": func(l *Listener, v value) (err error) {
+		l.Policy.ReadPaths, err = v.list()
+		return err
+	},
+	"sandbox_write": func(l *Listener, v value) (err error) {
+		l.Policy.WritePaths, err = v.list()
+		return err
+	},
+	"capabilities": func(l *Listener, v value) (err error) {
+		l.Policy.Capabilities, err = v.capabilities()
+		return err
+	},
+}
+
+// applyListener sets a "listener.<name>.<field>" key.
+func (c *Config) applyListener(key string, v value, source string) error {
+	name, field, ok := strings.Cut(strings.TrimPrefix(key, "listener."), ".")
+	set := listenerFields[field]
+	if !ok || name == "" || set == nil {
+		return fmt.Errorf("%s: unknown key %q", source, key)
+	}
+
+	var l *Listener
+	for i := range c.Listeners {
+		if c.Listeners[i].Name == name {
+			l = &c.Listeners[i]
+		}
+	}
+	if l == nil {
+		c.Listeners = append(c.Listeners, Listener{Name: name, set: make(map[string]bool)})
+		l = &c.Listeners[len(c.Listeners)-1]
+	}
+	if err := set(l, v); err != nil {
+		return fmt.Errorf("%s: %s: %w", source, key, err)
+	}
+	l.set[field] = true
+	if c.sources == nil {
+		c.sources = make(map[string]string)
+	}
+	c.sources[key] = source
+	return nil
+}
+
+// Listener returns the listener with the given name, or nil.
+func (c *Config) Listener(name string) *Listener {
+	for i := range c.Listeners {
+		if c.Listeners[i].Name == name {
+			return &c.Listeners[i]
+		}
+	}
+	return nil
+}
+
+// resolveListeners validates every listener and looks up its users and
+// socket ownership.
+func (c *Config) resolveListeners() error {
+	sort.Slice(c.Listeners, func(i, j int) bool { return c.Listeners[i].Name < c.Listeners[j].Name })
+	paths := map[string]string{c.SocketPath: "the main socket"}
+	for i := range c.Listeners {
+		l := &c.Listeners[i]
+		if err := l.resolve(c); err != nil {
+			return fmt.Errorf("listener %q: %w", l.Name, err)
+		}
+		if other, ok := paths[l.SocketPath]; ok {
+			return fmt.Errorf("listener %q: socket %s is already used by %s", l.Name, l.SocketPath, other)
+		}
+		paths[l.SocketPath] = fmt.Sprintf("listener %q", l.Name)
+	}
+	return nil
+}
+
+func (l *Listener) resolve(c *Config) error {
+	if l.SocketPath == "" {
+		return fmt.Errorf("socket must be specified")
+	}
+	if !filepath.IsAbs(l.SocketPath) {
+		return fmt.Errorf("socket path %q must be absolute", l.SocketPath)
+	}
+
+	if len(l.Users) == 0 {
+		return fmt.Errorf("users must list at least one user")
+	}
+	l.UIDs = l.UIDs[:0]
+	for _, name := range l.Users {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return fmt.Errorf("looking up user %q: %w", name, err)
+		}
+		uid, err := strconv.ParseUint(u.Uid, 10, 32)
+		if err != nil {
+			return fmt.Errorf("parsing UID %q: %w", u.Uid, err)
+		}
+		l.UIDs = append(l.UIDs, uint32(uid))
+	}
+
+	switch l.Role {
+	case "":
+		l.Role = RoleRestricted
+	case RoleAdmin, RoleRestricted:
+	default:
+		return fmt.Errorf("invalid role %q (valid: %s, %s)", l.Role, RoleAdmin, RoleRestricted)
+	}
+	if l.Role == RoleAdmin && len(l.Commands) > 0 {
+		return fmt.Errorf("commands only apply to the %s role", RoleRestricted)
+	}
+	for _, pattern := range l.Commands {
+		if strings.TrimSpace(pattern) != pattern || pattern == "" {
+			return fmt.Errorf("command %q must not be empty or have surrounding spaces", pattern)
+		}
+	}
+
+	if !l.set["socket_mode"] {
+		l.SocketMode = 0660
+	}
+	owner, ownerUID, group, groupGID, err := resolveSocketOwnership(l.SocketOwner, l.SocketGroup, l.Users[0])
+	if err != nil {
+		return err
+	}
+	l.SocketOwner, l.SocketOwnerUID, l.SocketGroup, l.SocketGroupGID = owner, ownerUID, group, groupGID
+	if err := validateSocketMode(l.SocketMode, l.SocketOwner, l.SocketGroup, l.Users); err != nil {
+		return err
+	}
+
+	l.inherit(c)
+	if err := l.Policy.Validate(); err != nil {
+		return fmt.Errorf("invalid policy: %w", err)
+	}
+	return nil
+}
+
+// inherit takes the connection limit, sandbox paths and capabilities the
+// listener does not set from the top-level configuration c.
+func (l *Listener) inherit(c *Config) {
+	if !l.set["max_connections"] {
+		l.MaxConnections = c.MaxConnections
+	}
+	if !l.set["sandbox_read"] && !l.set["sandbox_write"] {
+		l.Policy.ReadPaths, l.Policy.WritePaths = c.Policy.ReadPaths, c.Policy.WritePaths
+	}
+	if !l.set["capabilities"] {
+		l.Policy.Capabilities = c.Policy.Capabilities
+	}
+}
+
+// sameSocket reports whether l and o listen on the same socket with the
+// same users, ownership and mode.
+func (l *Listener) sameSocket(o *Listener) bool {
+	return l.SocketPath == o.SocketPath && slices.Equal(l.UIDs, o.UIDs) &&
+		l.SocketOwnerUID == o.SocketOwnerUID && l.SocketGroupGID == o.SocketGroupGID &&
+		l.SocketMode == o.SocketMode
+}
+
+// reloaded returns l as it applies after a reload to next; see
+// Config.Applied.
+func (l *Listener) reloaded(next *Config) Listener {
+	out := *l
+	if n := next.Listener(l.Name); n != nil {
+		out.Role, out.Commands, out.MaxConnections, out.Policy = n.Role, n.Commands, n.MaxConnections, n.Policy
+		return out
+	}
+	out.Policy = Policy{}
+	if l.set["sandbox_read"] || l.set["sandbox_write"] {
+		out.Policy.ReadPaths, out.Policy.WritePaths = l.Policy.ReadPaths, l.Policy.WritePaths
+	}
+	if l.set["capabilities"] {
+		out.Policy.Capabilities = l.Policy.Capabilities
+	}
+	out.inherit(next)
+	return out
+}
@@ -0,0 +1,218 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoad_Listeners(t *testing.T) {
+	u := currentUser(t)
+	path := writeConfig(t, `
+user = "`+u.Username+`"
+
+[policy]
+sandbox_read = ["/etc"]
+capabilities = []
+
+[listener.site]
+socket = "/run/vito-root/site.sock"
+users = ["`+u.Username+`"]
+commands = ["systemctl reload php*-fpm"]
+capabilities = ["CAP_KILL"]
+max_connections = 2
+
+[listener.admin]
+socket = "/run/vito-root/admin.sock"
+users = ["`+u.Username+`"]
+role = "admin"
+socket_mode = "0600"
+`, nil)
+
+	cfg, err := Load(LoadOptions{Path: path})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(cfg.Listeners) != 2 || cfg.Listeners[0].Name != "admin" || cfg.Listeners[1].Name != "site" {
+		t.Fatalf("Listeners = %+v, want admin and site sorted by name", cfg.Listeners)
+	}
+
+	admin := cfg.Listener("admin")
+	if admin.Role != RoleAdmin || admin.SocketMode != 0600 || admin.SocketOwner != "root" || admin.SocketGroup != u.Username {
+		t.Errorf("admin = %+v", admin)
+	}
+	if !reflect.DeepEqual(admin.Policy.ReadPaths, []string{"/etc"}) || admin.Policy.Capabilities == nil {
+		t.Errorf("admin policy = %+v, want the top-level policy", admin.Policy)
+	}
+
+	site := cfg.Listener("site")
+	if site.Role != RoleRestricted || site.SocketMode != 0660 || len(site.UIDs) != 1 || site.UIDs[0] != cfg.AllowedUID {
+		t.Errorf("site = %+v", site)
+	}
+	if site.MaxConnections != 2 || admin.MaxConnections != cfg.MaxConnections {
+		t.Errorf("MaxConnections = %d for site and %d for admin, want its own and the top-level one", site.MaxConnections, admin.MaxConnections)
+	}
+	if !reflect.DeepEqual(site.Policy.Capabilities, []string{"CAP_KILL"}) {
+		t.Errorf("site capabilities = %v, want its own", site.Policy.Capabilities)
+	}
+	if got := cfg.Source("listener.site.socket"); !strings.HasSuffix(got, "config.toml:9") {
+		t.Errorf("Source(listener.site.socket) = %q", got)
+	}
+	if cfg.Listener("missing") != nil {
+		t.Error("Listener(missing) should be nil")
+	}
+
+	next, err := Load(LoadOptions{Path: writeConfig(t, "user = \""+u.Username+"\"\n", nil)})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if _, restart := cfg.Changes(next); !reflect.DeepEqual(restart, []string{"listener.admin", "listener.site"}) {
+		t.Errorf("restart = %v, want removed listeners to need a restart", restart)
+	}
+}
+
+func TestConfig_AppliedListeners(t *testing.T) {
+	u := currentUser(t)
+	listeners := `
+[listener.site]
+socket = "%s"
+users = ["` + u.Username + `"]
+commands = ["systemctl reload php*-fpm"]
+
+[listener.admin]
+socket = "/run/vito-root/admin.sock"
+users = ["` + u.Username + `"]
+role = "admin"
+`
+	load := func(policy, socket string) *Config {
+		t.Helper()
+		cfg, err := Load(LoadOptions{Path: writeConfig(t,
+			"user = \""+u.Username+"\"\n\n[policy]\n"+policy+"\n"+fmt.Sprintf(listeners, socket), nil)})
+		if err != nil {
+			t.Fatalf("Load: %v", err)
+		}
+		return cfg
+	}
+	cfg := load(`capabilities = ["CAP_KILL", "CAP_CHOWN"]`, "/run/vito-root/site.sock")
+
+	// Tightening the top-level policy reaches the listeners inheriting it.
+	next := load(`capabilities = ["CAP_KILL"]`, "/run/vito-root/site.sock")
+	reload, restart := cfg.Changes(next)
+	if !reflect.DeepEqual(reload, []string{"policy.capabilities", "listener.admin", "listener.site"}) || restart != nil {
+		t.Errorf("Changes = %v, %v, want the policy and both listeners reloaded", reload, restart)
+	}
+	applied := cfg.Applied(next)
+	for _, name := range []string{"admin", "site"} {
+		if got := applied.Listener(name).Policy.Capabilities; !reflect.DeepEqual(got, []string{"CAP_KILL"}) {
+			t.Errorf("%s capabilities = %v, want the reloaded top-level ones", name, got)
+		}
+	}
+
+	// A moved socket needs a restart; the rest of the listener reloads,
+	// and a listener removed from the file keeps its socket with the new
+	// top-level policy.
+	next = load(`capabilities = []`, "/run/vito-root/other.sock")
+	next.Listeners = next.Listeners[1:] // drop admin
+	_, restart = cfg.Changes(next)
+	if !reflect.DeepEqual(restart, []string{"listener.admin", "listener.site"}) {
+		t.Errorf("restart = %v", restart)
+	}
+	applied = cfg.Applied(next)
+	site := applied.Listener("site")
+	if site.SocketPath != "/run/vito-root/site.sock" || len(site.Policy.Capabilities) != 0 || site.Policy.Capabilities == nil {
+		t.Errorf("site = %+v, want the running socket with the new policy", site)
+	}
+	admin := applied.Listener("admin")
+	if admin == nil || admin.Role != RoleAdmin || len(admin.Policy.Capabilities) != 0 || admin.Policy.Capabilities == nil {
+		t.Errorf("admin = %+v, want it kept with the new top-level policy", admin)
+	}
+	if _, restart := applied.Changes(next); !reflect.DeepEqual(restart, []string{"listener.admin", "listener.site"}) {
+		t.Errorf("restart after applying = %v, want the pending restart still reported", restart)
+	}
+}
+
+func TestLoad_ListenerErrors(t *testing.T) {
+	u := currentUser(t)
+	base := "user = \"" + u.Username + "\"\n[listener.site]\n"
+	users := "users = [\"" + u.Username + "\"]\n"
+	tests := []struct {
+		name string
+		file string
+		want string
+	}{
+		{"unknown field", base + "sockett = \"/run/x.sock\"\n", `unknown key "listener.site.sockett"`},
+		{"missing socket", base + users, "socket must be specified"},
+		{"relative socket", base + users + "socket = \"x.sock\"\n", "must be absolute"},
+		{"missing users", base + "socket = \"/run/x.sock\"\n", "users must list"},
+		{"unknown user", base + "socket = \"/run/x.sock\"\nusers = [\"no-such-user-xyz\"]\n", "looking up user"},
+		{"bad role", base + users + "socket = \"/run/x.sock\"\nrole = \"owner\"\n", "invalid role"},
+		{"admin commands", base + users + "socket = \"/run/x.sock\"\nrole = \"admin\"\ncommands = [\"true\"]\n", "commands only apply"},
+		{"padded command", base + users + "socket = \"/run/x.sock\"\ncommands = [\" true\"]\n", "surrounding spaces"},
+		{"world-accessible", base + users + "socket = \"/run/x.sock\"\nsocket_mode = 0o666\n", "must not grant access"},
+		{"main socket", base + users + "socket = \"/run/vito-root.sock\"\n", "already used by the main socket"},
+		{"bad policy", base + users + "socket = \"/run/x.sock\"\nsandbox_write = [\"tmp\"]\n", "must be absolute"},
+		{"zero connections", base + users + "socket = \"/run/x.sock\"\nmax_connections = 0\n", "must be between 1 and"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(LoadOptions{Path: writeConfig(t, tt.file, nil)})
+			if err == nil {
+				t.Fatalf("expected error containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestListener_AllowsCommand(t *testing.T) {
+	l := &Listener{Commands: []string{
+		"systemctl reload php*-fpm",
+		"/usr/local/bin/clear-cache *",
+	}}
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{"systemctl reload php8.3-fpm", true},
+		{"  systemctl reload php8.3-fpm\n", true},
+		{"systemctl reload php-fpm", false},
+		{"systemctl reload php8.3-fpm-extra", false},
+		{"systemctl reload php8.3-fpm; rm -rf /", false},
+		{"systemctl reload php$(id)-fpm", false},
+		{"systemctl reload php8.3-fpm nginx", false},
+		{"systemctl restart php8.3-fpm", false},
+		{"/usr/local/bin/clear-cache example.com", true},
+		{"/usr/local/bin/clear-cache example.com other.com", false},
+		{"/usr/local/bin/clear-cache ../etc", false},
+	}
+	for _, tt := range tests {
+		if got := l.AllowsCommand(tt.command); got != tt.want {
+			t.Errorf("AllowsCommand(%q) = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestListener_AllowsCommandWildcardLimits(t *testing.T) {
+	l := &Listener{Commands: []string{"systemctl reload *", "cat /srv/app/*"}}
+	tests := []struct {
+		command string
+		want    bool
+	}{
+		{"systemctl reload nginx", true},
+		{"systemctl reload --all", false},
+		{"systemctl reload -H", false},
+		{"cat /srv/app/config.php", true},
+		{"cat /srv/app/.env", true},
+		{"cat /srv/app/...", true},
+		{"cat /srv/app/.", false},
+		{"cat /srv/app/..", false},
+	}
+	for _, tt := range tests {
+		if got := l.AllowsCommand(tt.command); got != tt.want {
+			t.Errorf("AllowsCommand(%q) = %v, want %v", tt.command, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,354 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// DefaultPath is the default configuration file. Drop-in files are read
+// from DropInDir next to it.
+const DefaultPath = "/etc/vito-root/config.toml"
+
+// DropInDir is the directory, relative to the configuration file's, whose
+// *.toml files are applied after it in lexical order.
+const DropInDir = "conf.d"
+
+// EnvPrefix prefixes environment variable overrides: the file key
+// upper-cased with dots replaced, e.g. VITO_ROOT_AUDIT_SYSLOG for
+// audit.syslog.
+const EnvPrefix = "VITO_ROOT_"
+
+// SourceDefault is the source of values that were not configured.
+const SourceDefault = "default"
+
+// LoadOptions selects the configuration sources.
+type LoadOptions struct {
+	// Path is the configuration file. When empty, DefaultPath is used if
+	// it exists; an explicitly given file must exist.
+	Path string
+	// Environ holds the environment overrides, as returned by os.Environ.
+	Environ []string
+	// Flags holds the command-line overrides, if any.
+	Flags *Flags
+}
+
+// Load builds the configuration from, in increasing precedence: built-in
+// defaults, the configuration file, drop-in files, VITO_ROOT_* environment
+// variables and command-line flags. Unknown keys and variables are errors,
+// so a typo never silently falls back to a default. Users and groups are
+// resolved and the result is validated.
+func Load(opts LoadOptions) (*Config, error) {
+	c := Default()
+
+	path := opts.Path
+	required := path != ""
+	if opts.Flags != nil && opts.Flags.configSet {
+		path, required = opts.Flags.configPath, true
+	}
+	if path == "" {
+		path = DefaultPath
+	}
+	c.ConfigPath = path
+
+	if err := c.applyFile(path, required); err != nil {
+		return nil, err
+	}
+	dropIns, err := filepath.Glob(filepath.Join(filepath.Dir(path), DropInDir, "*.toml"))
+	if err != nil {
+		return nil, fmt.Errorf("listing drop-in files: %w", err)
+	}
+	sort.Strings(dropIns)
+	for _, f := range dropIns {
+		if err := c.applyFile(f, true); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.applyEnv(opts.Environ); err != nil {
+		return nil, err
+	}
+	if opts.Flags != nil {
+		if err := c.applyFlags(opts.Flags); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.resolve(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Source returns where the setting with the given file key came from: a
+// file and line, an environment variable, a flag, or SourceDefault.
+func (c *Config) Source(key string) string {
+	if src, ok := c.sources[key]; ok {
+		return src
+	}
+	return SourceDefault
+}
+
+// Entry describes one setting's effective value.
+type Entry struct {
+	Key    string `json:"key"`
+	Flag   string `json:"flag"`
+	Env    string `json:"env"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+	// Restart is set for settings a reload cannot change.
+	Restart bool `json:"restart,omitempty"`
+}
+
+// Entries lists every setting with its value in c and where the value
+// came from, in the order of the flag help.
+func (c *Config) Entries() []Entry {
+	entries := make([]Entry, len(settings))
+	for i := range settings {
+		s := &settings[i]
+		entries[i] = Entry{
+			Key:     s.key,
+			Flag:    "-" + s.flag,
+			Env:     s.envName(),
+			Value:   formatValue(s.get(c)),
+			Source:  c.Source(s.key),
+			Restart: s.restart,
+		}
+	}
+	return entries
+}
+
+// Changes returns the keys of the settings whose values differ between c
+// and next, split into those a reload applies and those that only take
+// effect after a restart.
+func (c *Config) Changes(next *Config) (reload, restart []string) {
+	for i := range settings {
+		s := &settings[i]
+		if formatValue(s.get(c)) == formatValue(s.get(next)) {
+			continue
+		}
+		if s.restart {
+			restart = append(restart, s.key)
+		} else {
+			reload = append(reload, s.key)
+		}
+	}
+	names := make(map[string]bool)
+	for _, l := range append(append([]Listener{}, c.Listeners...), next.Listeners...) {
+		names[l.Name] = true
+	}
+	for _, name := range slices.Sorted(maps.Keys(names)) {
+		a, b := c.Listener(name), next.Listener(name)
+		switch {
+		case a == nil || b == nil || !a.sameSocket(b):
+			restart = append(restart, "listener."+name)
+		case a.Role != b.Role || !reflect.DeepEqual(a.Commands, b.Commands) || a.MaxConnections != b.MaxConnections ||
+			!reflect.DeepEqual(a.Policy, b.Policy):
+			reload = append(reload, "listener."+name)
+		}
+	}
+	return reload, restart
+}
+
+// Applied returns the configuration in effect once next is reloaded on top
+// of c: next, except that settings a reload cannot change keep their values
+// from c. Listeners keep their sockets and users from c and take their
+// role, commands, connection limit and policy from next; a listener next no longer defines
+// keeps running with the top-level settings of next inherited afresh, and one
+// it adds does not exist until a restart.
+func (c *Config) Applied(next *Config) *Config {
+	out := *next
+	out.sources = make(map[string]string, len(next.sources))
+	for k, v := range next.sources {
+		out.sources[k] = v
+	}
+	for i := range settings {
+		s := &settings[i]
+		if !s.restart {
+			continue
+		}
+		// Values of a resolved configuration always parse again.
+		_ = s.set(&out, value{v: s.get(c)})
+		if src, ok := c.sources[s.key]; ok {
+			out.sources[s.key] = src
+		} else {
+			delete(out.sources, s.key)
+		}
+	}
+	out.SocketOwnerUID, out.SocketGroupGID = c.SocketOwnerUID, c.SocketGroupGID
+	out.LogJSON = c.LogJSON
+
+	out.Listeners = make([]Listener, len(c.Listeners))
+	for i := range c.Listeners {
+		out.Listeners[i] = c.Listeners[i].reloaded(next)
+	}
+	return &out
+}
+
+func (c *Config) apply(s *setting, v value, source string) error {
+	if err := s.set(c, v); err != nil {
+		return fmt.Errorf("%s: %s: %w", source, s.key, err)
+	}
+	if c.sources == nil {
+		c.sources = make(map[string]string)
+	}
+	c.sources[s.key] = source
+	return nil
+}
+
+func (c *Config) applyFile(path string, required bool) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) && !required {
+			return nil
+		}
+		return fmt.Errorf("reading config file: %w", err)
+	}
+	entries, err := parseTOML(string(data))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.key, "listener.") {
+			if err := c.applyListener(e.key, value{v: e.value}, fmt.Sprintf("%s:%d", path, e.line)); err != nil {
+				return err
+			}
+			continue
+		}
+		s := lookupSetting(e.key)
+		if s == nil {
+			return fmt.Errorf("%s:%d: unknown key %q", path, e.line, e.key)
+		}
+		if err := c.apply(s, value{v: e.value}, fmt.Sprintf("%s:%d", path, e.line)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) applyEnv(environ []string) error {
+	byName := make(map[string]*setting, len(settings))
+	for i := range settings {
+		byName[settings[i].envName()] = &settings[i]
+	}
+	// Sort for a deterministic first error.
+	environ = append([]string{}, environ...)
+	sort.Strings(environ)
+	for _, kv := range environ {
+		name, val, _ := strings.Cut(kv, "=")
+		if !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		s := byName[name]
+		if s == nil {
+			return fmt.Errorf("unknown environment variable %s", name)
+		}
+		v := value{v: val}
+		if s.repeat {
+			v.sep = "\n"
+		}
+		if err := c.apply(s, v, "env "+name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *Config) applyFlags(f *Flags) error {
+	if f.logJSON {
+		if err := c.apply(lookupSetting("log_format"), value{v: "json"}, "flag -log-json"); err != nil {
+			return err
+		}
+	}
+	for _, fv := range f.values {
+		if len(fv.values) == 0 {
+			continue
+		}
+		// A repeated flag accumulates; otherwise the last one wins.
+		v := value{v: fv.values[len(fv.values)-1]}
+		if fv.setting.repeat {
+			items := make([]any, len(fv.values))
+			for i, s := range fv.values {
+				items[i] = s
+			}
+			v = value{v: items}
+		}
+		if err := c.apply(fv.setting, v, "flag -"+fv.setting.flag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Flags holds the command-line overrides registered by RegisterFlags.
+type Flags struct {
+	configPath string
+	configSet  bool
+	logJSON    bool
+	values     []*flagValue
+}
+
+// RegisterFlags adds a flag for every setting to fs, plus -config to
+// choose the configuration file. Only flags given on the command line
+// override other sources.
+func RegisterFlags(fs *flag.FlagSet) *Flags {
+	f := &Flags{}
+	fs.Func("config", "Configuration file (default "+DefaultPath+")", func(v string) error {
+		f.configPath, f.configSet = v, true
+		return nil
+	})
+
+	defaults := Default()
+	for i := range settings {
+		s := &settings[i]
+		fv := &flagValue{setting: s, def: formatValue(s.get(defaults))}
+		f.values = append(f.values, fv)
+		fs.Var(fv, s.flag, s.usage)
+	}
+	fs.BoolVar(&f.logJSON, "log-json", false, "Output logs as JSON (same as -log-format json)")
+	return f
+}
+
+// flagValue collects the values given for one setting's flag. They are
+// checked as they are parsed so errors name the flag.
+type flagValue struct {
+	setting *setting
+	def     string
+	values  []string
+}
+
+func (f *flagValue) String() string {
+	if f == nil {
+		return ""
+	}
+	return f.def
+}
+
+func (f *flagValue) Set(v string) error {
+	check := value{v: v}
+	if f.setting.repeat {
+		check.v = []any{v}
+	}
+	if err := f.setting.set(Default(), check); err != nil {
+		return err
+	}
+	f.values = append(f.values, v)
+	return nil
+}
+
+// formatValue renders a setting value as text.
+func formatValue(v any) string {
+	switch x := v.(type) {
+	case []string:
+		return strings.Join(x, ",")
+	default:
+		return fmt.Sprint(x)
+	}
+}
@@ -0,0 +1,323 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"os"
+	"os/user"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file and drop-ins into a temp directory and
+// returns the config file path.
+func writeConfig(t *testing.T, main string, dropIns map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.toml")
+	if err := os.WriteFile(path, []byte(main), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if len(dropIns) > 0 {
+		if err := os.Mkdir(filepath.Join(dir, DropInDir), 0700); err != nil {
+			t.Fatal(err)
+		}
+		for name, content := range dropIns {
+			if err := os.WriteFile(filepath.Join(dir, DropInDir, name), []byte(content), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return path
+}
+
+func parseFlags(t *testing.T, args ...string) *Flags {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	f := RegisterFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("parsing flags: %v", err)
+	}
+	return f
+}
+
+func currentUser(t *testing.T) *user.User {
+	t.Helper()
+	u, err := user.Current()
+	if err != nil {
+		t.Fatalf("failed to get current user: %v", err)
+	}
+	return u
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	u := currentUser(t)
+	path := writeConfig(t, `
+user = "`+u.Username+`"
+max_connections = 10
+log_level = "warn"
+
+[rate_limit]
+commands = 50
+actions = 5
+
+[policy]
+capabilities = []
+`, map[string]string{
+		"20-late.toml":  "max_connections = 30\n",
+		"10-early.toml": "max_connections = 20\nidle_timeout = \"1m\"\n",
+		"ignored.conf":  "not toml at all",
+	})
+
+	cfg, err := Load(LoadOptions{
+		Path:    path,
+		Environ: []string{"VITO_ROOT_RATE_LIMIT_COMMANDS=70", "VITO_ROOT_LOG_LEVEL=error", "HOME=/root"},
+		Flags:   parseFlags(t, "-log-level", "debug", "-record-max-size", "5"),
+	})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if cfg.AllowedUser != u.Username || cfg.AllowedUID == 0 && u.Uid != "0" {
+		t.Errorf("unexpected user %q (%d)", cfg.AllowedUser, cfg.AllowedUID)
+	}
+	if cfg.MaxConnections != 30 {
+		t.Errorf("MaxConnections = %d, want 30 from the last drop-in", cfg.MaxConnections)
+	}
+	if cfg.IdleTimeout != time.Minute {
+		t.Errorf("IdleTimeout = %v, want 1m", cfg.IdleTimeout)
+	}
+	if cfg.CommandRateLimit != 70 || cfg.ActionRateLimit != 5 {
+		t.Errorf("rate limits = %d/%d, want 70/5", cfg.CommandRateLimit, cfg.ActionRateLimit)
+	}
+	if cfg.LogLevel != "debug" {
+		t.Errorf("LogLevel = %q, want debug from the flag", cfg.LogLevel)
+	}
+	if cfg.RecordMaxSize != 5<<20 {
+		t.Errorf("RecordMaxSize = %d, want 5 MiB", cfg.RecordMaxSize)
+	}
+	if cfg.Policy.Capabilities == nil || len(cfg.Policy.Capabilities) != 0 {
+		t.Errorf("Capabilities = %#v, want empty non-nil", cfg.Policy.Capabilities)
+	}
+	if cfg.AuditLog != "/var/lib/vito-root/audit.log" {
+		t.Errorf("AuditLog = %q, want the default", cfg.AuditLog)
+	}
+
+	sources := map[string]string{
+		"user":                path + ":2",
+		"max_connections":     filepath.Join(filepath.Dir(path), DropInDir, "20-late.toml") + ":1",
+		"rate_limit.commands": "env VITO_ROOT_RATE_LIMIT_COMMANDS",
+		"rate_limit.actions":  path + ":8",
+		"log_level":           "flag -log-level",
+		"audit.log":           SourceDefault,
+	}
+	for key, want := range sources {
+		if got := cfg.Source(key); got != want {
+			t.Errorf("Source(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestLoad_MissingFile(t *testing.T) {
+	u := currentUser(t)
+	dir := t.TempDir()
+
+	// The default path may be absent.
+	if _, err := Load(LoadOptions{Flags: parseFlags(t, "-user", u.Username)}); err != nil {
+		t.Errorf("Load without a config file: %v", err)
+	}
+
+	// An explicitly chosen one must exist.
+	_, err := Load(LoadOptions{Flags: parseFlags(t, "-config", filepath.Join(dir, "missing.toml"), "-user", u.Username)})
+	if err == nil || !strings.Contains(err.Error(), "reading config file") {
+		t.Errorf("expected error for a missing -config file, got %v", err)
+	}
+}
+
+func TestLoad_Strict(t *testing.T) {
+	u := currentUser(t)
+	tests := []struct {
+		name    string
+		file    string
+		environ []string
+		want    string
+	}{
+		{"unknown key", "user = \"" + u.Username + "\"\nmax_conections = 5\n", nil, `:2: unknown key "max_conections"`},
+		{"unknown table key", "[audit]\nsyslog_url = \"x\"\n", nil, `unknown key "audit.syslog_url"`},
+		{"wrong type", "max_connections = \"many\"\n", nil, "max_connections: invalid integer"},
+		{"negative", "rate_limit.commands = -1\n", nil, "must not be negative"},
+		{"recording size overflow", "recording.max_size = 9_000_000_000_000\n", nil, "must be at most"},
+		{"bad duration", "idle_timeout = 10\n", nil, "expected a duration string"},
+		{"bad mode", "socket_mode = 0o1777\n", nil, "out of range"},
+		{"unknown env", "user = \"" + u.Username + "\"\n", []string{"VITO_ROOT_MAX_CONECTIONS=5"}, "unknown environment variable VITO_ROOT_MAX_CONECTIONS"},
+		{"bad log format", "user = \"" + u.Username + "\"\nlog_format = \"xml\"\n", nil, "invalid log format"},
+		{"unknown user", "user = \"no-such-user-xyz\"\n", nil, "looking up user"},
+		{"unknown group", "user = \"" + u.Username + "\"\nsocket_group = \"no-such-group-xyz\"\n", nil, "looking up socket group"},
+		{"unknown owner", "user = \"" + u.Username + "\"\nsocket_owner = \"no-such-user-xyz\"\n", nil, "looking up socket owner"},
+		{"world-accessible socket", "user = \"" + u.Username + "\"\nsocket_mode = 0o666\n", nil, "must not grant access to other users"},
+		{"socket closed to group", "user = \"" + u.Username + "\"\nsocket_owner = \"nobody\"\nsocket_mode = \"0600\"\n", nil, "does not let group"},
+		{"bad policy", "user = \"" + u.Username + "\"\n[policy]\nsandbox_read = [\"relative\"]\n", nil, "must be absolute"},
+		{"bad update channel", "user = \"" + u.Username + "\"\n", []string{"VITO_ROOT_UPDATE_CHANNEL=nightly"}, `invalid update channel "nightly"`},
+		{"syntax error", "user = \n", nil, "config.toml: line 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeConfig(t, tt.file, nil)
+			_, err := Load(LoadOptions{Path: path, Environ: tt.environ})
+			if err == nil {
+				t.Fatalf("expected error containing %q", tt.want)
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("error = %q, want it to contain %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoad_Lists(t *testing.T) {
+	u := currentUser(t)
+	path := writeConfig(t, "user = \""+u.Username+"\"\nenv_allowlist = [\"APP_*\"]\n", nil)
+
+	cfg, err := Load(LoadOptions{
+		Path: path,
+		Environ: []string{
+			"VITO_ROOT_POLICY_SANDBOX_READ=/etc, /srv",
+			"VITO_ROOT_REDACT_PATTERNS=a,b{1,2}\nc",
+		},
+		Flags: parseFlags(t, "-capabilities", "none"),
+	})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.EnvAllowlist, []string{"APP_*"}) {
+		t.Errorf("EnvAllowlist = %v", cfg.EnvAllowlist)
+	}
+	if !reflect.DeepEqual(cfg.Policy.ReadPaths, []string{"/etc", "/srv"}) {
+		t.Errorf("ReadPaths = %v", cfg.Policy.ReadPaths)
+	}
+	if !reflect.DeepEqual(cfg.RedactPatterns, []string{"a,b{1,2}", "c"}) {
+		t.Errorf("RedactPatterns = %v, want patterns split on newlines", cfg.RedactPatterns)
+	}
+	if cfg.Policy.Capabilities == nil || len(cfg.Policy.Capabilities) != 0 {
+		t.Errorf("Capabilities = %#v, want empty for \"none\"", cfg.Policy.Capabilities)
+	}
+
+	cfg, err = Load(LoadOptions{
+		Path:  path,
+		Flags: parseFlags(t, "-redact-pattern", "x,y", "-redact-pattern", "z", "-log-json"),
+	})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !reflect.DeepEqual(cfg.RedactPatterns, []string{"x,y", "z"}) {
+		t.Errorf("RedactPatterns = %v, want one entry per flag", cfg.RedactPatterns)
+	}
+	if cfg.LogFormat != "json" || !cfg.LogJSON || cfg.Source("log_format") != "flag -log-json" {
+		t.Errorf("LogFormat = %q from %s, want json from -log-json", cfg.LogFormat, cfg.Source("log_format"))
+	}
+}
+
+func TestConfig_Changes(t *testing.T) {
+	u := currentUser(t)
+	path := writeConfig(t, "user = \""+u.Username+"\"\n", nil)
+	old, err := Load(LoadOptions{Path: path})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	next, err := Load(LoadOptions{Path: path, Flags: parseFlags(t,
+		"-rate-commands", "10", "-sandbox-read", "/etc", "-audit-syslog", "udp://127.0.0.1:514")})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	reload, restart := old.Changes(next)
+	if !reflect.DeepEqual(reload, []string{"rate_limit.commands", "policy.sandbox_read"}) {
+		t.Errorf("reload = %v", reload)
+	}
+	if !reflect.DeepEqual(restart, []string{"audit.syslog"}) {
+		t.Errorf("restart = %v", restart)
+	}
+	if reload, restart := old.Changes(old); reload != nil || restart != nil {
+		t.Errorf("Changes of an unchanged config = %v, %v", reload, restart)
+	}
+}
+
+func TestConfig_Applied(t *testing.T) {
+	u := currentUser(t)
+	path := writeConfig(t, "user = \""+u.Username+"\"\n", nil)
+	old, err := Load(LoadOptions{Path: path})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	next, err := Load(LoadOptions{Path: path, Flags: parseFlags(t,
+		"-rate-commands", "10", "-audit-syslog", "udp://127.0.0.1:514", "-socket-mode", "0600", "-record-max-size", "5", "-log-format", "json")})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	applied := old.Applied(next)
+	if applied.CommandRateLimit != 10 {
+		t.Errorf("CommandRateLimit = %d, want the reloaded 10", applied.CommandRateLimit)
+	}
+	if applied.AuditSyslog != old.AuditSyslog || applied.SocketMode != old.SocketMode ||
+		applied.RecordMaxSize != old.RecordMaxSize || applied.LogFormat != old.LogFormat || applied.LogJSON != old.LogJSON {
+		t.Errorf("applied = %+v, want restart-only settings kept from the running config", applied)
+	}
+	if got := applied.Source("audit.syslog"); got != SourceDefault {
+		t.Errorf("Source(audit.syslog) = %q, want the running value's source", got)
+	}
+	if _, restart := applied.Changes(next); len(restart) != 4 {
+		t.Errorf("restart = %v, want the pending restart still reported", restart)
+	}
+}
+
+func TestConfig_Entries(t *testing.T) {
+	u := currentUser(t)
+	path := writeConfig(t, "user = \""+u.Username+"\"\n[policy]\nsandbox_read = [\"/etc\", \"/srv\"]\n", nil)
+	cfg, err := Load(LoadOptions{Path: path, Environ: []string{"VITO_ROOT_SOCKET_MODE=0600"}})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	entries := cfg.Entries()
+	if len(entries) != len(settings) {
+		t.Fatalf("got %d entries, want one per setting (%d)", len(entries), len(settings))
+	}
+	byKey := make(map[string]Entry)
+	for _, e := range entries {
+		byKey[e.Key] = e
+	}
+	want := map[string]Entry{
+		"socket_mode":         {Key: "socket_mode", Flag: "-socket-mode", Env: "VITO_ROOT_SOCKET_MODE", Value: "0600", Source: "env VITO_ROOT_SOCKET_MODE", Restart: true},
+		"policy.sandbox_read": {Key: "policy.sandbox_read", Flag: "-sandbox-read", Env: "VITO_ROOT_POLICY_SANDBOX_READ", Value: "/etc,/srv", Source: path + ":3"},
+		"max_connections":     {Key: "max_connections", Flag: "-max-connections", Env: "VITO_ROOT_MAX_CONNECTIONS", Value: "100", Source: SourceDefault},
+	}
+	for key, w := range want {
+		if got := byKey[key]; got != w {
+			t.Errorf("entry %s = %+v, want %+v", key, got, w)
+		}
+	}
+}
+
+func TestRegisterFlags_RejectsInvalidValues(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	RegisterFlags(fs)
+	if err := fs.Parse([]string{"-max-connections", "lots"}); err == nil {
+		t.Error("expected a parse error for a non-integer flag")
+	}
+}
+
+func TestSettings_UniqueNames(t *testing.T) {
+	keys, flags, envs := map[string]bool{}, map[string]bool{}, map[string]bool{}
+	for _, s := range settings {
+		if keys[s.key] || flags[s.flag] || envs[s.envName()] {
+			t.Errorf("duplicate setting %q / -%s / %s", s.key, s.flag, s.envName())
+		}
+		keys[s.key], flags[s.flag], envs[s.envName()] = true, true, true
+	}
+}
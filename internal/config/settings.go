@@ -0,0 +1,353 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// setting is one configurable value, addressable as a file key, an
+// environment variable and a command-line flag.
+type setting struct {
+	// key is the dotted configuration file key, e.g. "audit.syslog".
+	key string
+	// flag is the command-line flag name, e.g. "audit-syslog".
+	flag  string
+	usage string
+	// repeat lets the flag be given more than once, each adding an entry.
+	repeat bool
+	// restart marks settings that a reload cannot apply.
+	restart bool
+	set     func(c *Config, v value) error
+	// get returns the value as it would be written in a file.
+	get func(c *Config) any
+}
+
+// envName returns the environment variable overriding the setting.
+func (s *setting) envName() string {
+	return EnvPrefix + strings.ToUpper(strings.NewReplacer(".", "_", "-", "_").Replace(s.key))
+}
+
+// value is a raw setting value: a TOML value from a file (string, int64,
+// bool or []any), or a string from the environment or a flag.
+type value struct {
+	v any
+	// sep splits string values into lists. Defaults to ",".
+	sep string
+}
+
+func (v value) str() (string, error) {
+	s, ok := v.v.(string)
+	if !ok {
+		return "", fmt.Errorf("expected a string, got %s", typeName(v.v))
+	}
+	return s, nil
+}
+
+func (v value) int() (int64, error) {
+	switch x := v.v.(type) {
+	case int64:
+		return x, nil
+	case string:
+		n, err := strconv.ParseInt(strings.TrimSpace(x), 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid integer %q", x)
+		}
+		return n, nil
+	}
+	return 0, fmt.Errorf("expected an integer, got %s", typeName(v.v))
+}
+
+func (v value) duration() (time.Duration, error) {
+	s, ok := v.v.(string)
+	if !ok {
+		return 0, fmt.Errorf(`expected a duration string such as "10m", got %s`, typeName(v.v))
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
+
+func (v value) list() ([]string, error) {
+	switch x := v.v.(type) {
+	case string:
+		if v.sep != "" && v.sep != "," {
+			var out []string
+			for _, part := range strings.Split(x, v.sep) {
+				if part != "" {
+					out = append(out, part)
+				}
+			}
+			return out, nil
+		}
+		return ParseList(x), nil
+	case []any:
+		out := make([]string, 0, len(x))
+		for _, item := range x {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("expected an array of strings, found %s", typeName(item))
+			}
+			out = append(out, s)
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("expected an array of strings, got %s", typeName(v.v))
+}
+
+// mode parses a file mode: an integer (0o660 in a file) or an octal
+// string such as "0660".
+func (v value) mode() (uint32, error) {
+	var mode int64
+	var err error
+	if s, ok := v.v.(string); ok {
+		mode, err = strconv.ParseInt(strings.TrimPrefix(strings.TrimSpace(s), "0o"), 8, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid octal mode %q", s)
+		}
+	} else if mode, err = v.int(); err != nil {
+		return 0, err
+	}
+	if mode < 0 || mode > 0777 {
+		return 0, fmt.Errorf("mode %#o out of range", mode)
+	}
+	return uint32(mode), nil
+}
+
+// capabilities parses a capability list. In a string, "" means
+// unrestricted (nil) and "none" drops every capability; in a file, an
+// empty array drops every capability.
+func (v value) capabilities() ([]string, error) {
+	if s, ok := v.v.(string); ok {
+		switch strings.TrimSpace(s) {
+		case "":
+			return nil, nil
+		case "none":
+			return []string{}, nil
+		}
+	}
+	return v.list()
+}
+
+func typeName(v any) string {
+	switch v.(type) {
+	case string:
+		return "a string"
+	case int64:
+		return "an integer"
+	case bool:
+		return "a boolean"
+	case []any:
+		return "an array"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func stringSetting(key, flag, usage string, field func(*Config) *string) setting {
+	return setting{
+		key: key, flag: flag, usage: usage,
+		set: func(c *Config, v value) error {
+			s, err := v.str()
+			if err != nil {
+				return err
+			}
+			*field(c) = s
+			return nil
+		},
+		get: func(c *Config) any { return *field(c) },
+	}
+}
+
+func intSetting(key, flag, usage string, field func(*Config) *int) setting {
+	return setting{
+		key: key, flag: flag, usage: usage,
+		set: func(c *Config, v value) error {
+			n, err := v.int()
+			if err != nil {
+				return err
+			}
+			if n < 0 {
+				return fmt.Errorf("must not be negative")
+			}
+			*field(c) = int(n)
+			return nil
+		},
+		get: func(c *Config) any { return int64(*field(c)) },
+	}
+}
+
+// mibSetting is a size in bytes, configured in MiB.
+func mibSetting(key, flag, usage string, field func(*Config) *int64) setting {
+	return setting{
+		key: key, flag: flag, usage: usage,
+		set: func(c *Config, v value) error {
+			n, err := v.int()
+			if err != nil {
+				return err
+			}
+			if n < 0 {
+				return fmt.Errorf("must not be negative")
+			}
+			if n > math.MaxInt64>>20 {
+				return fmt.Errorf("must be at most %d MiB", int64(math.MaxInt64>>20))
+			}
+			*field(c) = n << 20
+			return nil
+		},
+		get: func(c *Config) any { return *field(c) >> 20 },
+	}
+}
+
+func durationSetting(key, flag, usage string, field func(*Config) *time.Duration) setting {
+	return setting{
+		key: key, flag: flag, usage: usage,
+		set: func(c *Config, v value) error {
+			d, err := v.duration()
+			if err != nil {
+				return err
+			}
+			if d < 0 {
+				return fmt.Errorf("must not be negative")
+			}
+			*field(c) = d
+			return nil
+		},
+		get: func(c *Config) any { return field(c).String() },
+	}
+}
+
+func listSetting(key, flag, usage string, field func(*Config) *[]string) setting {
+	return setting{
+		key: key, flag: flag, usage: usage,
+		set: func(c *Config, v value) error {
+			l, err := v.list()
+			if err != nil {
+				return err
+			}
+			*field(c) = l
+			return nil
+		},
+		get: func(c *Config) any { return *field(c) },
+	}
+}
+
+// restartOnly marks s as taking effect only after a restart.
+func restartOnly(s setting) setting {
+	s.restart = true
+	return s
+}
+
+// settings lists every configurable value. Order is the order of
+// "config show" and the flag help.
+var settings = []setting{
+	restartOnly(stringSetting("socket", "socket", "Path to the Unix socket",
+		func(c *Config) *string { return &c.SocketPath })),
+	{
+		key: "socket_mode", flag: "socket-mode", usage: "Socket file mode in octal (standalone mode)", restart: true,
+		set: func(c *Config, v value) (err error) {
+			c.SocketMode, err = v.mode()
+			return err
+		},
+		get: func(c *Config) any { return fmt.Sprintf("%04o", c.SocketMode) },
+	},
+	restartOnly(stringSetting("socket_owner", "socket-owner", "User owning the socket",
+		func(c *Config) *string { return &c.SocketOwner })),
+	restartOnly(stringSetting("socket_group", "socket-group", "Group owning the socket (default: the group named like the allowed user)",
+		func(c *Config) *string { return &c.SocketGroup })),
+	stringSetting("user", "user", "Allowed connecting user",
+		func(c *Config) *string { return &c.AllowedUser }),
+	stringSetting("log_level", "log-level", "Log level (debug, info, warn, error)",
+		func(c *Config) *string { return &c.LogLevel }),
+	restartOnly(stringSetting("log_format", "log-format", "Log format: text, json or journald",
+		func(c *Config) *string { return &c.LogFormat })),
+	durationSetting("max_exec_timeout", "max-exec-timeout", "Maximum command execution time (0 = no limit)",
+		func(c *Config) *time.Duration { return &c.MaxExecTimeout }),
+	intSetting("max_connections", "max-connections", "Maximum concurrent connections",
+		func(c *Config) *int { return &c.MaxConnections }),
+	restartOnly(durationSetting("idle_timeout", "idle-timeout", "Exit after this long without connections when socket-activated (0 = never)",
+		func(c *Config) *time.Duration { return &c.IdleTimeout })),
+	listSetting("env_allowlist", "env-allowlist", "Comma-separated env vars clients may set (enables allowlist mode; \"PREFIX_*\" matches by prefix)",
+		func(c *Config) *[]string { return &c.EnvAllowlist }),
+	stringSetting("secrets_dir", "secrets-dir", "Directory of root-only secret files",
+		func(c *Config) *string { return &c.SecretsDir }),
+	func() setting {
+		s := listSetting("redact_patterns", "redact-pattern", "Extra regular expression to redact from output and logs (repeatable)",
+			func(c *Config) *[]string { return &c.RedactPatterns })
+		s.repeat = true
+		return s
+	}(),
+
+	intSetting("rate_limit.commands", "rate-commands", "Commands per minute per peer UID and per executable (0 = unlimited)",
+		func(c *Config) *int { return &c.CommandRateLimit }),
+	intSetting("rate_limit.commands_burst", "rate-commands-burst", "Command burst size per principal",
+		func(c *Config) *int { return &c.CommandBurst }),
+	intSetting("rate_limit.actions", "rate-actions", "Actions per minute per peer UID and per executable (0 = unlimited)",
+		func(c *Config) *int { return &c.ActionRateLimit }),
+	intSetting("rate_limit.actions_burst", "rate-actions-burst", "Action burst size per principal",
+		func(c *Config) *int { return &c.ActionBurst }),
+	intSetting("rate_limit.abuse_threshold", "abuse-threshold", "Rejected requests per minute from one principal before logging suspicious activity (0 = off)",
+		func(c *Config) *int { return &c.AbuseThreshold }),
+
+	restartOnly(stringSetting("audit.log", "audit-log", "Path to the tamper-evident audit log (empty = disabled)",
+		func(c *Config) *string { return &c.AuditLog })),
+	restartOnly(mibSetting("audit.max_size", "audit-max-size", "Size of the audit log in MiB before it is rotated (0 = never rotate)",
+		func(c *Config) *int64 { return &c.AuditMaxSize })),
+	restartOnly(intSetting("audit.max_files", "audit-max-files", "Rotated audit logs to keep, oldest removed first (0 = keep all)",
+		func(c *Config) *int { return &c.AuditMaxFiles })),
+	restartOnly(stringSetting("audit.syslog", "audit-syslog", "Forward audit records to a syslog collector (udp://, tcp:// or tls://host:port)",
+		func(c *Config) *string { return &c.AuditSyslog })),
+	restartOnly(stringSetting("audit.syslog_ca", "audit-syslog-ca", "CA certificate file for verifying a tls:// collector (default: system roots)",
+		func(c *Config) *string { return &c.AuditSyslogCA })),
+	restartOnly(stringSetting("audit.syslog_cert", "audit-syslog-cert", "Client certificate file for a tls:// collector",
+		func(c *Config) *string { return &c.AuditSyslogCert })),
+	restartOnly(stringSetting("audit.syslog_key", "audit-syslog-key", "Client key file for a tls:// collector",
+		func(c *Config) *string { return &c.AuditSyslogKey })),
+	restartOnly(stringSetting("audit.syslog_spool", "audit-syslog-spool", "File buffering audit records while the collector is unreachable",
+		func(c *Config) *string { return &c.AuditSyslogSpool })),
+
+	restartOnly(stringSetting("recording.dir", "record-dir", "Record every command's output as asciicast files in this directory (empty = disabled)",
+		func(c *Config) *string { return &c.RecordDir })),
+	restartOnly(mibSetting("recording.max_size", "record-max-size", "Total size of recordings in MiB before the oldest are pruned (0 = unlimited)",
+		func(c *Config) *int64 { return &c.RecordMaxSize })),
+	restartOnly(durationSetting("recording.max_age", "record-max-age", "Age after which recordings are pruned (0 = keep forever)",
+		func(c *Config) *time.Duration { return &c.RecordMaxAge })),
+
+	restartOnly(stringSetting("metrics.addr", "metrics-addr", "Serve OpenMetrics at /metrics on this Unix socket path or loopback host:port (empty = disabled)",
+		func(c *Config) *string { return &c.MetricsAddr })),
+	restartOnly(stringSetting("tracing.otlp_endpoint", "otlp-endpoint", "Export request traces to this OTLP/HTTP collector URL, e.g. http://127.0.0.1:4318/v1/traces (empty = disabled)",
+		func(c *Config) *string { return &c.OTLPEndpoint })),
+	stringSetting("update.channel", "update-channel", "Releases to update to: stable, beta, a version such as v1.4.2 or a constraint such as ~1.4",
+		func(c *Config) *string { return &c.UpdateChannel }),
+
+	listSetting("policy.sandbox_read", "sandbox-read", "Comma-separated paths commands may read (enables filesystem sandbox)",
+		func(c *Config) *[]string { return &c.Policy.ReadPaths }),
+	listSetting("policy.sandbox_write", "sandbox-write", "Comma-separated paths commands may write (enables filesystem sandbox)",
+		func(c *Config) *[]string { return &c.Policy.WritePaths }),
+	{
+		key: "policy.capabilities", flag: "capabilities", usage: "Comma-separated capabilities commands keep, or \"none\" (default: unrestricted)",
+		set: func(c *Config, v value) (err error) {
+			c.Policy.Capabilities, err = v.capabilities()
+			return err
+		},
+		get: func(c *Config) any {
+			if c.Policy.Capabilities == nil {
+				return ""
+			}
+			return c.Policy.Capabilities
+		},
+	},
+}
+
+// lookupSetting finds a setting by file key.
+func lookupSetting(key string) *setting {
+	for i := range settings {
+		if settings[i].key == key {
+			return &settings[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,361 @@
+package config
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// tomlEntry is one key/value assignment read from a configuration file.
+type tomlEntry struct {
+	// key is the full dotted key, including the enclosing table.
+	key string
+	// value is a string, int64, bool or []any of those.
+	value any
+	line  int
+}
+
+// parseTOML parses the subset of TOML (https://toml.io/en/v1.0.0) that
+// configuration needs: comments, [table] headers, bare and dotted keys,
+// basic and literal strings, integers, booleans and arrays. Anything else
+// (floats, dates, multi-line strings, inline tables, arrays of tables) is
+// rejected rather than misread.
+func parseTOML(data string) ([]tomlEntry, error) {
+	p := &tomlParser{s: data, line: 1}
+	entries, err := p.parse()
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.line, err)
+	}
+	return entries, nil
+}
+
+type tomlParser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func (p *tomlParser) parse() ([]tomlEntry, error) {
+	var entries []tomlEntry
+	seen := make(map[string]bool)
+	table := ""
+	for {
+		p.skipBlank(true)
+		if p.eof() {
+			return entries, nil
+		}
+
+		if p.peek() == '[' {
+			p.pos++
+			if p.peek() == '[' {
+				return nil, fmt.Errorf("arrays of tables are not supported")
+			}
+			p.skipSpace()
+			key, err := p.parseKey()
+			if err != nil {
+				return nil, err
+			}
+			p.skipSpace()
+			if p.peek() != ']' {
+				return nil, fmt.Errorf("expected ] after table name")
+			}
+			p.pos++
+			if seen["["+key+"]"] {
+				return nil, fmt.Errorf("table [%s] defined twice", key)
+			}
+			seen["["+key+"]"] = true
+			table = key
+			if err := p.endOfLine(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		line := p.line
+		key, err := p.parseKey()
+		if err != nil {
+			return nil, err
+		}
+		if table != "" {
+			key = table + "." + key
+		}
+		p.skipSpace()
+		if p.peek() != '=' {
+			return nil, fmt.Errorf("expected = after key %q", key)
+		}
+		p.pos++
+		p.skipSpace()
+		value, err := p.parseValue()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		if seen[key] {
+			return nil, fmt.Errorf("key %q defined twice", key)
+		}
+		seen[key] = true
+		entries = append(entries, tomlEntry{key: key, value: value, line: line})
+		if err := p.endOfLine(); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (p *tomlParser) eof() bool { return p.pos >= len(p.s) }
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+// skipSpace skips spaces and tabs.
+func (p *tomlParser) skipSpace() {
+	for !p.eof() && (p.peek() == ' ' || p.peek() == '\t') {
+		p.pos++
+	}
+}
+
+// skipBlank skips whitespace and comments, and newlines when allowed.
+func (p *tomlParser) skipBlank(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && newlines:
+			p.pos++
+			p.line++
+		case c == '#':
+			for !p.eof() && p.peek() != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+// endOfLine consumes optional whitespace and a comment up to the newline.
+func (p *tomlParser) endOfLine() error {
+	p.skipBlank(false)
+	if p.eof() {
+		return nil
+	}
+	if p.peek() != '\n' {
+		return fmt.Errorf("unexpected %q after value", p.rest())
+	}
+	return nil
+}
+
+// rest returns the remainder of the current line, for error messages.
+func (p *tomlParser) rest() string {
+	end := strings.IndexByte(p.s[p.pos:], '\n')
+	if end < 0 {
+		return p.s[p.pos:]
+	}
+	return strings.TrimRight(p.s[p.pos:p.pos+end], "\r")
+}
+
+// parseKey parses a bare or dotted key such as "audit.syslog".
+func (p *tomlParser) parseKey() (string, error) {
+	var parts []string
+	for {
+		start := p.pos
+		for !p.eof() && isBareKeyChar(p.peek()) {
+			p.pos++
+		}
+		if p.pos == start {
+			if c := p.peek(); c == '"' || c == '\'' {
+				return "", fmt.Errorf("quoted keys are not supported")
+			}
+			return "", fmt.Errorf("expected a key, found %q", p.rest())
+		}
+		parts = append(parts, p.s[start:p.pos])
+		p.skipSpace()
+		if p.peek() != '.' {
+			return strings.Join(parts, "."), nil
+		}
+		p.pos++
+		p.skipSpace()
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+func (p *tomlParser) parseValue() (any, error) {
+	switch c := p.peek(); {
+	case c == '"':
+		if strings.HasPrefix(p.s[p.pos:], `"""`) {
+			return nil, fmt.Errorf("multi-line strings are not supported")
+		}
+		return p.parseBasicString()
+	case c == '\'':
+		if strings.HasPrefix(p.s[p.pos:], `'''`) {
+			return nil, fmt.Errorf("multi-line strings are not supported")
+		}
+		return p.parseLiteralString()
+	case c == '[':
+		return p.parseArray()
+	case c == '{':
+		return nil, fmt.Errorf("inline tables are not supported")
+	case c == 0 || c == '\n' || c == '#':
+		return nil, fmt.Errorf("missing value")
+	}
+
+	start := p.pos
+	for !p.eof() && strings.IndexByte(" \t\r\n,]#", p.peek()) < 0 {
+		p.pos++
+	}
+	token := p.s[start:p.pos]
+	switch token {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	return parseTOMLInteger(token)
+}
+
+// parseTOMLInteger parses decimal, hexadecimal (0x), octal (0o) and binary
+// (0b) integers with optional underscores between digits.
+func parseTOMLInteger(token string) (int64, error) {
+	digits := token
+	if digits != "" && (digits[0] == '+' || digits[0] == '-') {
+		digits = digits[1:]
+	}
+	if digits == "" || digits[0] == '+' || digits[0] == '-' || strings.HasPrefix(digits, "_") || strings.HasSuffix(digits, "_") || strings.Contains(digits, "__") {
+		return 0, fmt.Errorf("invalid value %q", token)
+	}
+	base := 10
+	if len(digits) > 2 && digits[0] == '0' {
+		switch digits[1] {
+		case 'x':
+			base = 16
+		case 'o':
+			base = 8
+		case 'b':
+			base = 2
+		}
+		if base != 10 {
+			if digits != token {
+				return 0, fmt.Errorf("invalid value %q: sign not allowed with a base prefix", token)
+			}
+			digits = digits[2:]
+		}
+	}
+	if base == 10 && len(digits) > 1 && digits[0] == '0' {
+		return 0, fmt.Errorf("invalid value %q: leading zeros are not allowed (write octal as 0o%s)", token, strings.TrimLeft(digits, "0"))
+	}
+	n, err := strconv.ParseInt(strings.ReplaceAll(digits, "_", ""), base, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value %q (only strings, integers, booleans and arrays are supported)", token)
+	}
+	if strings.HasPrefix(token, "-") {
+		n = -n
+	}
+	return n, nil
+}
+
+func (p *tomlParser) parseBasicString() (string, error) {
+	p.pos++ // opening quote
+	var b strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		c := p.peek()
+		p.pos++
+		switch c {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.eof() {
+				return "", fmt.Errorf("unterminated string")
+			}
+			esc := p.peek()
+			p.pos++
+			switch esc {
+			case 'b':
+				b.WriteByte('\b')
+			case 't':
+				b.WriteByte('\t')
+			case 'n':
+				b.WriteByte('\n')
+			case 'f':
+				b.WriteByte('\f')
+			case 'r':
+				b.WriteByte('\r')
+			case '"':
+				b.WriteByte('"')
+			case '\\':
+				b.WriteByte('\\')
+			case 'u', 'U':
+				n := 4
+				if esc == 'U' {
+					n = 8
+				}
+				if p.pos+n > len(p.s) {
+					return "", fmt.Errorf("invalid unicode escape")
+				}
+				r, err := strconv.ParseUint(p.s[p.pos:p.pos+n], 16, 32)
+				if err != nil || !utf8.ValidRune(rune(r)) {
+					return "", fmt.Errorf("invalid unicode escape \\%c%s", esc, p.s[p.pos:p.pos+n])
+				}
+				b.WriteRune(rune(r))
+				p.pos += n
+			default:
+				return "", fmt.Errorf("invalid escape \\%c", esc)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func (p *tomlParser) parseLiteralString() (string, error) {
+	p.pos++ // opening quote
+	start := p.pos
+	for !p.eof() && p.peek() != '\'' {
+		if p.peek() == '\n' {
+			return "", fmt.Errorf("unterminated string")
+		}
+		p.pos++
+	}
+	if p.eof() {
+		return "", fmt.Errorf("unterminated string")
+	}
+	s := p.s[start:p.pos]
+	p.pos++
+	return s, nil
+}
+
+// parseArray parses an array, which may span lines and end with a comma.
+func (p *tomlParser) parseArray() ([]any, error) {
+	p.pos++ // [
+	values := []any{}
+	for {
+		p.skipBlank(true)
+		if p.peek() == ']' {
+			p.pos++
+			return values, nil
+		}
+		v, err := p.parseValue()
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+		p.skipBlank(true)
+		switch p.peek() {
+		case ',':
+			p.pos++
+		case ']':
+			p.pos++
+			return values, nil
+		default:
+			return nil, fmt.Errorf("expected , or ] in array")
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	input := `# vito-root configuration
+user = "deploy"   # trailing comment
+socket_mode = 0o660
+max_connections = 1_000
+log_level = 'debug'
+redact_patterns = [
+  "token=\\S+",   # comment inside an array
+  'key-[0-9a-f]+',
+]
+
+[audit]
+syslog = "tls://logs.example.com:6514"
+enabled.flag = true
+
+[policy]
+capabilities = []
+sandbox_read = ["/etc", "/var/www"]
+escaped = "tab\there \"quoted\" é"
+negative = -5
+hex = 0xff
+`
+	entries, err := parseTOML(input)
+	if err != nil {
+		t.Fatalf("parseTOML: %v", err)
+	}
+	got := make(map[string]any)
+	lines := make(map[string]int)
+	for _, e := range entries {
+		got[e.key] = e.value
+		lines[e.key] = e.line
+	}
+	want := map[string]any{
+		"user":                "deploy",
+		"socket_mode":         int64(0660),
+		"max_connections":     int64(1000),
+		"log_level":           "debug",
+		"redact_patterns":     []any{`token=\S+`, "key-[0-9a-f]+"},
+		"audit.syslog":        "tls://logs.example.com:6514",
+		"audit.enabled.flag":  true,
+		"policy.capabilities": []any{},
+		"policy.sandbox_read": []any{"/etc", "/var/www"},
+		"policy.escaped":      "tab\there \"quoted\" é",
+		"policy.negative":     int64(-5),
+		"policy.hex":          int64(255),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseTOML =\n%#v\nwant\n%#v", got, want)
+	}
+	if lines["user"] != 2 || lines["audit.syslog"] != 12 {
+		t.Errorf("unexpected line numbers: %v", lines)
+	}
+}
+
+func TestParseTOML_Errors(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+	}{
+		{"user = \"a\"\nuser = \"b\"", "line 2: key \"user\" defined twice"},
+		{"[a]\n[a]", "table [a] defined twice"},
+		{"mode = 0660", "leading zeros"},
+		{"ratio = 1.5", "only strings, integers"},
+		{"when = 2024-01-01", "only strings, integers"},
+		{"flag = yes", "invalid value"},
+		{"n = --5", "invalid value"},
+		{"n = +-5", "invalid value"},
+		{"n = -+0x5", "invalid value"},
+		{`s = """multi"""`, "multi-line strings"},
+		{"t = {a = 1}", "inline tables"},
+		{"[[servers]]", "arrays of tables"},
+		{`"quoted" = 1`, "quoted keys"},
+		{"user", "expected = after key"},
+		{"user =", "missing value"},
+		{`user = "unterminated`, "unterminated string"},
+		{`user = "bad \q escape"`, "invalid escape"},
+		{`user = "a" "b"`, "unexpected"},
+		{"list = [1, 2", "expected , or ]"},
+	}
+	for _, tt := range tests {
+		_, err := parseTOML(tt.input)
+		if err == nil {
+			t.Errorf("parseTOML(%q) succeeded, want error containing %q", tt.input, tt.want)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("parseTOML(%q) error = %q, want it to contain %q", tt.input, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package executor
+
+import (
+	"fmt"
+	"strings"
+)
+
+// capabilityNames maps Linux capability names (without the CAP_ prefix) to
+// their numbers, as defined in linux/capability.h.
+var capabilityNames = map[string]int{
+	"CHOWN":              0,
+	"DAC_OVERRIDE":       1,
+	"DAC_READ_SEARCH":    2,
+	"FOWNER":             3,
+	"FSETID":             4,
+	"KILL":               5,
+	"SETGID":             6,
+	"SETUID":             7,
+	"SETPCAP":            8,
+	"LINUX_IMMUTABLE":    9,
+	"NET_BIND_SERVICE":   10,
+	"NET_BROADCAST":      11,
+	"NET_ADMIN":          12,
+	"NET_RAW":            13,
+	"IPC_LOCK":           14,
+	"IPC_OWNER":          15,
+	"SYS_MODULE":         16,
+	"SYS_RAWIO":          17,
+	"SYS_CHROOT":         18,
+	"SYS_PTRACE":         19,
+	"SYS_PACCT":          20,
+	"SYS_ADMIN":          21,
+	"SYS_BOOT":           22,
+	"SYS_NICE":           23,
+	"SYS_RESOURCE":       24,
+	"SYS_TIME":           25,
+	"SYS_TTY_CONFIG":     26,
+	"MKNOD":              27,
+	"LEASE":              28,
+	"AUDIT_WRITE":        29,
+	"AUDIT_CONTROL":      30,
+	"SETFCAP":            31,
+	"MAC_OVERRIDE":       32,
+	"MAC_ADMIN":          33,
+	"SYSLOG":             34,
+	"WAKE_ALARM":         35,
+	"BLOCK_SUSPEND":      36,
+	"AUDIT_READ":         37,
+	"PERFMON":            38,
+	"BPF":                39,
+	"CHECKPOINT_RESTORE": 40,
+}
+
+// ParseCapability converts a capability name such as "CAP_CHOWN" or
+// "net_bind_service" to its number.
+func ParseCapability(name string) (int, error) {
+	key := strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(name)), "CAP_")
+	n, ok := capabilityNames[key]
+	if !ok {
+		return 0, fmt.Errorf("unknown capability %q", name)
+	}
+	return n, nil
+}
+
+// ParseCapabilities converts capability names to numbers. A nil input
+// yields nil (unrestricted); an empty, non-nil input yields an empty,
+// non-nil result (drop everything).
+func ParseCapabilities(names []string) ([]int, error) {
+	if names == nil {
+		return nil, nil
+	}
+	caps := make([]int, 0, len(names))
+	for _, name := range names {
+		n, err := ParseCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		caps = append(caps, n)
+	}
+	return caps, nil
+}
+
+// CapabilitiesWithin reports whether every capability in caps is also in
+// allowed. Names are compared after normalization.
+func CapabilitiesWithin(caps, allowed []string) error {
+	allowedSet := make(map[int]bool, len(allowed))
+	for _, name := range allowed {
+		n, err := ParseCapability(name)
+		if err != nil {
+			return err
+		}
+		allowedSet[n] = true
+	}
+	for _, name := range caps {
+		n, err := ParseCapability(name)
+		if err != nil {
+			return err
+		}
+		if !allowedSet[n] {
+			return fmt.Errorf("capability %q is not permitted by policy", name)
+		}
+	}
+	return nil
+}
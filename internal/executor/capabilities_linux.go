@@ -0,0 +1,94 @@
+//go:build linux
+
+package executor
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	prCapbsetDrop        = 24
+	prCapAmbient         = 47
+	prCapAmbientRaise    = 2
+	prCapAmbientClearAll = 4
+
+	linuxCapabilityVersion3 = 0x20080522
+)
+
+type capHeader struct {
+	version uint32
+	pid     int32
+}
+
+type capData struct {
+	effective   uint32
+	permitted   uint32
+	inheritable uint32
+}
+
+// lastCapability returns the highest capability number the kernel knows.
+func lastCapability() int {
+	data, err := os.ReadFile("/proc/sys/kernel/cap_last_cap")
+	if err == nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+			return n
+		}
+	}
+	return 40 // CAP_CHECKPOINT_RESTORE
+}
+
+// applyCapabilities reduces the calling thread to exactly keep:
+//  1. every other capability is dropped from the bounding set, so nothing
+//     exec'd later (including setuid-root binaries) can regain it;
+//  2. permitted, effective and inheritable are set to keep;
+//  3. keep is raised in the ambient set so the capabilities also survive
+//     an exec into a non-root context.
+//
+// For a root process the capabilities after exec are the inheritable set
+// plus the bounding set, so steps 1 and 2 alone fully bound the command.
+func applyCapabilities(keep []int) error {
+	last := lastCapability()
+	keepSet := make(map[int]bool, len(keep))
+	var mask [2]uint32
+	for _, c := range keep {
+		if c > last {
+			return fmt.Errorf("capability %d not supported by this kernel", c)
+		}
+		keepSet[c] = true
+		mask[c/32] |= 1 << (uint(c) % 32)
+	}
+
+	for c := 0; c <= last; c++ {
+		if keepSet[c] {
+			continue
+		}
+		if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prCapbsetDrop, uintptr(c), 0); errno != 0 {
+			return fmt.Errorf("dropping capability %d from bounding set: %w", c, errno)
+		}
+	}
+
+	hdr := capHeader{version: linuxCapabilityVersion3}
+	data := [2]capData{
+		{effective: mask[0], permitted: mask[0], inheritable: mask[0]},
+		{effective: mask[1], permitted: mask[1], inheritable: mask[1]},
+	}
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_CAPSET,
+		uintptr(unsafe.Pointer(&hdr)), uintptr(unsafe.Pointer(&data[0])), 0); errno != 0 {
+		return fmt.Errorf("capset: %w", errno)
+	}
+
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientClearAll, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("clearing ambient set: %w", errno)
+	}
+	for _, c := range keep {
+		if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prCapAmbient, prCapAmbientRaise, uintptr(c), 0, 0, 0); errno != 0 {
+			return fmt.Errorf("raising ambient capability %d: %w", c, errno)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestParseCapability(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    int
+		wantErr bool
+	}{
+		{"CAP_CHOWN", 0, false},
+		{"cap_net_bind_service", 10, false},
+		{"SYS_ADMIN", 21, false},
+		{"CAP_NOT_A_THING", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseCapability(tt.name)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParseCapability(%q) expected error", tt.name)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("ParseCapability(%q) = %d, %v; expected %d", tt.name, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseCapabilities_NilVersusEmpty(t *testing.T) {
+	caps, err := ParseCapabilities(nil)
+	if err != nil || caps != nil {
+		t.Errorf("expected nil for nil input, got %v, %v", caps, err)
+	}
+	caps, err = ParseCapabilities([]string{})
+	if err != nil || caps == nil {
+		t.Errorf("expected empty non-nil for empty input, got %v, %v", caps, err)
+	}
+}
+
+func TestRun_Capabilities(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("capability bounding requires root")
+	}
+
+	var mu sync.Mutex
+	var output strings.Builder
+
+	e := &Executor{
+		Capabilities: []string{"CAP_NET_BIND_SERVICE"},
+		OnStdout: func(data string) {
+			mu.Lock()
+			defer mu.Unlock()
+			output.WriteString(data)
+		},
+	}
+
+	code, err := e.Run(context.Background(), "grep -E '^Cap(Eff|Bnd):' /proc/self/status")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code != 0 {
+		t.Fatalf("expected exit code 0, got %d", code)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// CAP_NET_BIND_SERVICE is bit 10 (0x400)
+	for _, line := range strings.Split(strings.TrimSpace(output.String()), "\n") {
+		if !strings.HasSuffix(line, "0000000000000400") {
+			t.Errorf("expected only CAP_NET_BIND_SERVICE, got %q", line)
+		}
+	}
+}
@@ -12,8 +12,9 @@ import (
 )
 
 const (
-	bufferSize       = 4096
+	bufferSize        = 4096
 	cancelGracePeriod = 5 * time.Second
+	shellPath         = "/bin/bash"
 )
 
 // OutputCallback is called for each chunk of output from the command.
@@ -25,12 +26,22 @@ type Executor struct {
 	Env      []string
 	OnStdout OutputCallback
 	OnStderr OutputCallback
+	// Sandbox, when set, restricts the command's filesystem access.
+	Sandbox *Sandbox
+	// Capabilities, when non-nil, is the exact set of Linux capabilities
+	// the command keeps (e.g. "CAP_CHOWN"); all others are dropped from the
+	// bounding, permitted, effective and inheritable sets. An empty,
+	// non-nil slice drops every capability.
+	Capabilities []string
 }
 
 // Run executes a command via /bin/bash -c and returns its exit code.
 // Returns a non-nil error only for infrastructure failures (not command exit codes).
 func (e *Executor) Run(ctx context.Context, command string) (int, error) {
-	cmd := exec.CommandContext(ctx, "/bin/bash", "-c", command)
+	cmd, err := e.command(ctx, command)
+	if err != nil {
+		return -1, err
+	}
 
 	if e.Cwd != "" {
 		cmd.Dir = e.Cwd
@@ -89,6 +100,32 @@ func (e *Executor) Run(ctx context.Context, command string) (int, error) {
 	return -1, fmt.Errorf("command failed: %w", err)
 }
 
+// command builds the exec.Cmd for a command, routing it through the
+// sandbox helper when a sandbox or capability set is configured.
+func (e *Executor) command(ctx context.Context, command string) (*exec.Cmd, error) {
+	if e.Sandbox == nil && e.Capabilities == nil {
+		return exec.CommandContext(ctx, shellPath, "-c", command), nil
+	}
+	spec := helperSpec{Sandbox: e.Sandbox}
+	if e.Sandbox != nil {
+		if err := e.Sandbox.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	caps, err := ParseCapabilities(e.Capabilities)
+	if err != nil {
+		return nil, err
+	}
+	spec.Capabilities = caps
+
+	// Restricted commands are started through the helper (see HelperCommand).
+	args, err := helperArgs(spec, command)
+	if err != nil {
+		return nil, err
+	}
+	return exec.CommandContext(ctx, selfExe, args...), nil
+}
+
 func (e *Executor) readPipe(pipe io.ReadCloser, callback OutputCallback) {
 	if callback == nil {
 		return
@@ -104,4 +141,3 @@ func (e *Executor) readPipe(pipe io.ReadCloser, callback OutputCallback) {
 		}
 	}
 }
-
@@ -0,0 +1,176 @@
+package executor
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+)
+
+// HelperCommand is the argv[1] marker that makes the service binary act as
+// the sandbox helper. The helper applies the sandbox and capability limits
+// to its own thread and then execs the shell, so the restrictions are in
+// place before any user-controlled code runs. The entry point must call RunHelper when it
+// sees this marker.
+const HelperCommand = "__vito-exec"
+
+// helperExitCode is returned when the helper fails before exec. It matches
+// the convention used by container runtimes for "could not start".
+const helperExitCode = 125
+
+// selfExe re-executes the running image even if the binary on disk has
+// been replaced by an update.
+const selfExe = "/proc/self/exe"
+
+// DefaultReadPaths are always readable (and executable) inside a sandbox so
+// that the shell and common tools can load. /etc and /proc hold
+// credentials and other processes' details; policies grant them explicitly
+// where commands need them. Only individual device nodes are granted, since
+// reading a block device under /dev would expose every file on the disk.
+var DefaultReadPaths = []string{
+	"/bin", "/sbin", "/usr", "/lib", "/lib32", "/lib64",
+	"/dev/null", "/dev/zero", "/dev/random", "/dev/urandom", "/dev/tty", "/dev/fd",
+}
+
+// DefaultWritePaths are always writable inside a sandbox.
+var DefaultWritePaths = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/tty"}
+
+// Sandbox restricts the filesystem access of an executed command. Paths
+// are matched as subtrees: granting /home/site grants everything beneath it.
+// Anything not listed (or covered by the defaults) is inaccessible.
+type Sandbox struct {
+	ReadPaths  []string `json:"read,omitempty"`
+	WritePaths []string `json:"write,omitempty"`
+}
+
+// Validate checks that every path is absolute.
+func (s *Sandbox) Validate() error {
+	for _, p := range append(append([]string{}, s.ReadPaths...), s.WritePaths...) {
+		if !filepath.IsAbs(p) {
+			return fmt.Errorf("sandbox path %q must be absolute", p)
+		}
+	}
+	return nil
+}
+
+// Resolve returns a copy of s with symlinks resolved in every path, so
+// that paths are compared with Within, and granted, by what they refer
+// to: a symlink under a writable path cannot extend the sandbox to its
+// target. The sandbox itself refuses paths that contain a symlink by the
+// time it is applied.
+func (s *Sandbox) Resolve() (*Sandbox, error) {
+	resolved := &Sandbox{}
+	for _, list := range []struct {
+		in  []string
+		out *[]string
+	}{{s.ReadPaths, &resolved.ReadPaths}, {s.WritePaths, &resolved.WritePaths}} {
+		for _, p := range list.in {
+			r, err := resolvePath(p)
+			if err != nil {
+				return nil, fmt.Errorf("resolving sandbox path %q: %w", p, err)
+			}
+			*list.out = append(*list.out, r)
+		}
+	}
+	return resolved, nil
+}
+
+// resolvePath resolves symlinks in path. For a path that does not exist
+// yet, the longest existing parent is resolved.
+func resolvePath(path string) (string, error) {
+	path = filepath.Clean(path)
+	r, err := filepath.EvalSymlinks(path)
+	if err == nil {
+		return r, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) || path == "/" {
+		return "", err
+	}
+	parent, err := resolvePath(filepath.Dir(path))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(parent, filepath.Base(path)), nil
+}
+
+// Within reports whether every path in s is covered by outer, i.e. s grants
+// no more access than outer. Read paths may be covered by either a read or
+// a write path of outer; write paths only by a write path.
+func (s *Sandbox) Within(outer *Sandbox) error {
+	for _, p := range s.ReadPaths {
+		if !pathWithin(p, outer.ReadPaths) && !pathWithin(p, outer.WritePaths) {
+			return fmt.Errorf("read path %q is not permitted by policy", p)
+		}
+	}
+	for _, p := range s.WritePaths {
+		if !pathWithin(p, outer.WritePaths) {
+			return fmt.Errorf("write path %q is not permitted by policy", p)
+		}
+	}
+	return nil
+}
+
+// pathWithin reports whether path equals or is beneath one of roots.
+func pathWithin(path string, roots []string) bool {
+	path = filepath.Clean(path)
+	for _, root := range roots {
+		root = filepath.Clean(root)
+		if path == root || root == "/" || strings.HasPrefix(path, root+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// helperSpec is passed to the helper process on its command line.
+type helperSpec struct {
+	Sandbox *Sandbox `json:"sandbox,omitempty"`
+	// Capabilities is deliberately not omitempty: nil (unrestricted) and
+	// empty (drop everything) must survive the round trip.
+	Capabilities []int `json:"capabilities"`
+}
+
+// helperArgs builds the helper's arguments: marker, spec, then the shell
+// command line to exec once the sandbox is applied.
+func helperArgs(spec helperSpec, command string) ([]string, error) {
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return nil, fmt.Errorf("encoding sandbox spec: %w", err)
+	}
+	return []string{HelperCommand, string(data), "--", shellPath, "-c", command}, nil
+}
+
+// RunHelper is the sandbox helper entry point. args are the arguments
+// following HelperCommand. It never returns: it either execs the shell or
+// exits with helperExitCode.
+func RunHelper(args []string) {
+	if len(args) < 3 || args[1] != "--" {
+		helperFail(fmt.Errorf("invalid helper arguments"))
+	}
+
+	var spec helperSpec
+	if err := json.Unmarshal([]byte(args[0]), &spec); err != nil {
+		helperFail(fmt.Errorf("decoding sandbox spec: %w", err))
+	}
+
+	// Landlock and capability changes apply to the calling thread only;
+	// exec must happen on the same thread for them to carry over.
+	runtime.LockOSThread()
+
+	argv := args[2:]
+	if err := applySpec(spec); err != nil {
+		helperFail(err)
+	}
+
+	err := syscall.Exec(argv[0], argv, os.Environ())
+	helperFail(fmt.Errorf("exec %s: %w", argv[0], err))
+}
+
+func helperFail(err error) {
+	fmt.Fprintf(os.Stderr, "vito-root-service: sandbox: %v\n", err)
+	os.Exit(helperExitCode)
+}
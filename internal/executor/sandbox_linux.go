@@ -0,0 +1,217 @@
+//go:build linux
+
+package executor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock syscall numbers (identical on all architectures).
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+	sysOpenat2               = 437
+)
+
+const (
+	landlockCreateRulesetVersion = 1 << 0
+	landlockRulePathBeneath      = 1
+
+	prSetNoNewPrivs = 38
+
+	// oPath is O_PATH, which the syscall package does not define.
+	oPath = 0x200000
+	// resolveNoSymlinks is openat2's RESOLVE_NO_SYMLINKS.
+	resolveNoSymlinks = 0x04
+)
+
+// atFDCWD is AT_FDCWD; a variable so it converts to a uintptr argument.
+var atFDCWD = -100
+
+// Landlock filesystem access rights.
+const (
+	accessFSExecute    = 1 << 0
+	accessFSWriteFile  = 1 << 1
+	accessFSReadFile   = 1 << 2
+	accessFSReadDir    = 1 << 3
+	accessFSRemoveDir  = 1 << 4
+	accessFSRemoveFile = 1 << 5
+	accessFSMakeChar   = 1 << 6
+	accessFSMakeDir    = 1 << 7
+	accessFSMakeReg    = 1 << 8
+	accessFSMakeSock   = 1 << 9
+	accessFSMakeFifo   = 1 << 10
+	accessFSMakeBlock  = 1 << 11
+	accessFSMakeSym    = 1 << 12
+	accessFSRefer      = 1 << 13 // ABI 2
+	accessFSTruncate   = 1 << 14 // ABI 3
+	accessFSIoctlDev   = 1 << 15 // ABI 5
+)
+
+const (
+	accessRead  = accessFSExecute | accessFSReadFile | accessFSReadDir
+	accessWrite = accessRead | accessFSWriteFile | accessFSRemoveDir | accessFSRemoveFile |
+		accessFSMakeChar | accessFSMakeDir | accessFSMakeReg | accessFSMakeSock |
+		accessFSMakeFifo | accessFSMakeBlock | accessFSMakeSym | accessFSRefer |
+		accessFSTruncate | accessFSIoctlDev
+
+	// accessFile are the only rights that may be granted on a non-directory.
+	accessFile = accessFSExecute | accessFSWriteFile | accessFSReadFile | accessFSTruncate | accessFSIoctlDev
+)
+
+func applySpec(spec helperSpec) error {
+	// Landlock first: restrict_self needs CAP_SYS_ADMIN, which the
+	// capability step may drop.
+	if spec.Sandbox != nil {
+		if err := applyLandlock(spec.Sandbox); err != nil {
+			return fmt.Errorf("landlock: %w", err)
+		}
+	}
+	if spec.Capabilities != nil {
+		if err := applyCapabilities(spec.Capabilities); err != nil {
+			return fmt.Errorf("capabilities: %w", err)
+		}
+	}
+	return nil
+}
+
+// landlockHandledAccess returns the access rights supported by the running
+// kernel's Landlock ABI. Fails closed if Landlock is unavailable.
+func landlockHandledAccess() (uint64, error) {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	if errno != 0 {
+		return 0, fmt.Errorf("not supported by this kernel: %w", errno)
+	}
+
+	handled := uint64(accessWrite)
+	if abi < 2 {
+		handled &^= accessFSRefer
+	}
+	if abi < 3 {
+		handled &^= accessFSTruncate
+	}
+	if abi < 5 {
+		handled &^= accessFSIoctlDev
+	}
+	return handled, nil
+}
+
+func applyLandlock(sb *Sandbox) error {
+	handled, err := landlockHandledAccess()
+	if err != nil {
+		return err
+	}
+
+	// struct landlock_ruleset_attr; only handled_access_fs is used, and the
+	// kernel accepts a size covering just that field.
+	attr := handled
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset,
+		uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("creating ruleset: %w", errno)
+	}
+	rulesetFD := int(fd)
+	defer syscall.Close(rulesetFD)
+
+	// The defaults are system paths, some of them symlinks (/bin -> usr/bin);
+	// configured and requested paths were resolved by the server and must
+	// not have gained a symlink since.
+	rules := []struct {
+		paths  []string
+		access uint64
+		follow bool
+	}{
+		{DefaultReadPaths, accessRead, true},
+		{sb.ReadPaths, accessRead, false},
+		{DefaultWritePaths, accessWrite, true},
+		{sb.WritePaths, accessWrite, false},
+	}
+	for _, rule := range rules {
+		for _, path := range rule.paths {
+			if err := addPathRule(rulesetFD, path, rule.access&handled, rule.follow); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, _, errno = syscall.Syscall(sysLandlockRestrictSelf, uintptr(rulesetFD), 0, 0)
+	if errno == syscall.EPERM {
+		// Without CAP_SYS_ADMIN, Landlock requires no_new_privs.
+		if _, _, e := syscall.Syscall(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0); e != 0 {
+			return fmt.Errorf("setting no_new_privs: %w", e)
+		}
+		_, _, errno = syscall.Syscall(sysLandlockRestrictSelf, uintptr(rulesetFD), 0, 0)
+	}
+	if errno != 0 {
+		return fmt.Errorf("restricting self: %w", errno)
+	}
+	return nil
+}
+
+// addPathRule grants access beneath path. Paths that do not exist are
+// skipped: they are inaccessible either way. Unless follow is set, a path
+// with a symlink in any component is refused.
+func addPathRule(rulesetFD int, path string, access uint64, follow bool) error {
+	fd, err := openPath(path, follow)
+	if err != nil {
+		if errors.Is(err, syscall.ENOENT) {
+			return nil
+		}
+		if errors.Is(err, syscall.ELOOP) {
+			return fmt.Errorf("opening %s: path contains a symlink", path)
+		}
+		return fmt.Errorf("opening %s: %w", path, err)
+	}
+	defer syscall.Close(fd)
+
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("stat %s: %w", path, err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= accessFile
+	}
+
+	// struct landlock_path_beneath_attr is packed: u64 allowed_access, s32 parent_fd.
+	var attr [12]byte
+	binary.NativeEndian.PutUint64(attr[0:8], access)
+	binary.NativeEndian.PutUint32(attr[8:12], uint32(int32(fd)))
+
+	_, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(rulesetFD), landlockRulePathBeneath,
+		uintptr(unsafe.Pointer(&attr[0])), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("adding rule for %s: %w", path, errno)
+	}
+	return nil
+}
+
+// openPath opens path with O_PATH, following symlinks only if follow is
+// set. openat2 is available on every kernel with Landlock.
+func openPath(path string, follow bool) (int, error) {
+	if follow {
+		return syscall.Open(path, oPath|syscall.O_CLOEXEC, 0)
+	}
+	p, err := syscall.BytePtrFromString(path)
+	if err != nil {
+		return -1, err
+	}
+	// struct open_how: u64 flags, u64 mode, u64 resolve.
+	how := [3]uint64{oPath | syscall.O_CLOEXEC, 0, resolveNoSymlinks}
+	fd, _, errno := syscall.Syscall6(sysOpenat2, uintptr(atFDCWD), uintptr(unsafe.Pointer(p)),
+		uintptr(unsafe.Pointer(&how)), unsafe.Sizeof(how), 0, 0)
+	if errno != 0 {
+		return -1, errno
+	}
+	return int(fd), nil
+}
+
+// sandboxSupported reports whether the kernel supports Landlock.
+func sandboxSupported() error {
+	_, err := landlockHandledAccess()
+	return err
+}
@@ -0,0 +1,19 @@
+//go:build !linux
+
+package executor
+
+import "fmt"
+
+func applySpec(spec helperSpec) error {
+	if spec.Sandbox != nil {
+		return fmt.Errorf("filesystem sandboxing requires Linux Landlock")
+	}
+	if spec.Capabilities != nil {
+		return fmt.Errorf("capability bounding requires Linux")
+	}
+	return nil
+}
+
+func sandboxSupported() error {
+	return fmt.Errorf("filesystem sandboxing requires Linux Landlock")
+}
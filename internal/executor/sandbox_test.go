@@ -0,0 +1,143 @@
+package executor
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestMain lets the test binary act as the sandbox helper, the same way
+// the service binary does in main.
+func TestMain(m *testing.M) {
+	if len(os.Args) > 1 && os.Args[1] == HelperCommand {
+		RunHelper(os.Args[2:])
+	}
+	os.Exit(m.Run())
+}
+
+func TestSandbox_Within(t *testing.T) {
+	policy := &Sandbox{
+		ReadPaths:  []string{"/etc/nginx"},
+		WritePaths: []string{"/home"},
+	}
+
+	tests := []struct {
+		name    string
+		sandbox *Sandbox
+		wantErr bool
+	}{
+		{"write under policy", &Sandbox{WritePaths: []string{"/home/site"}}, false},
+		{"read under write path", &Sandbox{ReadPaths: []string{"/home/site/.env"}}, false},
+		{"read under read path", &Sandbox{ReadPaths: []string{"/etc/nginx/sites-enabled"}}, false},
+		{"write under read path", &Sandbox{WritePaths: []string{"/etc/nginx"}}, true},
+		{"outside policy", &Sandbox{WritePaths: []string{"/etc/shadow"}}, true},
+		{"prefix is not a parent", &Sandbox{WritePaths: []string{"/homer"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.sandbox.Within(policy)
+			if tt.wantErr && err == nil {
+				t.Error("expected error")
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		})
+	}
+}
+
+func TestSandbox_ValidateRelativePath(t *testing.T) {
+	sb := &Sandbox{WritePaths: []string{"relative/path"}}
+	if err := sb.Validate(); err == nil {
+		t.Error("expected error for relative path")
+	}
+}
+
+func TestRun_Sandbox(t *testing.T) {
+	if err := sandboxSupported(); err != nil {
+		t.Skipf("landlock unavailable: %v", err)
+	}
+
+	allowed := t.TempDir()
+	denied := t.TempDir()
+
+	var mu sync.Mutex
+	var stderr strings.Builder
+
+	e := &Executor{
+		Sandbox: &Sandbox{WritePaths: []string{allowed}},
+		OnStderr: func(data string) {
+			mu.Lock()
+			defer mu.Unlock()
+			stderr.WriteString(data)
+		},
+	}
+
+	code, err := e.Run(context.Background(), "echo ok > "+filepath.Join(allowed, "file"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code != 0 {
+		t.Fatalf("expected write to allowed path to succeed, got exit code %d (stderr %q)", code, stderr.String())
+	}
+
+	code, err = e.Run(context.Background(), "echo bad > "+filepath.Join(denied, "file"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code == 0 {
+		t.Error("expected write outside sandbox to fail")
+	}
+	if _, err := os.Stat(filepath.Join(denied, "file")); !os.IsNotExist(err) {
+		t.Error("file outside sandbox should not have been created")
+	}
+}
+
+func TestSandbox_ResolveSymlink(t *testing.T) {
+	allowed := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Symlink(outside, filepath.Join(allowed, "link")); err != nil {
+		t.Fatal(err)
+	}
+	policy, err := (&Sandbox{WritePaths: []string{allowed}}).Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, path := range []string{filepath.Join(allowed, "link"), filepath.Join(allowed, "link", "new", "dir")} {
+		sb, err := (&Sandbox{WritePaths: []string{path}}).Resolve()
+		if err != nil {
+			t.Fatalf("Resolve(%s): %v", path, err)
+		}
+		if !strings.HasPrefix(sb.WritePaths[0], outside) {
+			t.Errorf("Resolve(%s) = %s, want it beneath %s", path, sb.WritePaths[0], outside)
+		}
+		if err := sb.Within(policy); err == nil {
+			t.Errorf("%s reaches outside the policy through a symlink but was allowed", path)
+		}
+	}
+}
+
+func TestRun_SandboxRejectsSymlink(t *testing.T) {
+	if err := sandboxSupported(); err != nil {
+		t.Skipf("landlock unavailable: %v", err)
+	}
+
+	dir := t.TempDir()
+	if err := os.Symlink(t.TempDir(), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &Executor{Sandbox: &Sandbox{WritePaths: []string{filepath.Join(dir, "link")}}}
+	code, err := e.Run(context.Background(), "true")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if code != helperExitCode {
+		t.Errorf("exit code = %d, want %d for a sandbox path through a symlink", code, helperExitCode)
+	}
+}
@@ -0,0 +1,337 @@
+// Package journald implements a slog.Handler that writes directly to the
+// systemd journal using its native protocol, so that log attributes become
+// queryable journal fields (e.g. journalctl VITO_EXIT_CODE=1).
+package journald
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SocketPath is the journal's native protocol socket.
+const SocketPath = "/run/systemd/journal/socket"
+
+// FieldPrefix is prepended to every attribute-derived field name.
+const FieldPrefix = "VITO_"
+
+// maxFieldName is the longest field name journald accepts.
+const maxFieldName = 64
+
+// Syslog priorities used for the PRIORITY field.
+const (
+	priErr     = 3
+	priWarning = 4
+	priInfo    = 6
+	priDebug   = 7
+)
+
+// Options configure a Handler.
+type Options struct {
+	// Level is the minimum level logged. Defaults to slog.LevelInfo.
+	Level slog.Leveler
+	// Identifier is sent as SYSLOG_IDENTIFIER.
+	Identifier string
+	// AddSource adds CODE_FILE, CODE_LINE and CODE_FUNC fields.
+	AddSource bool
+	// SocketPath overrides the journal socket (used in tests).
+	SocketPath string
+}
+
+// field is an attribute flattened to a journal field. key is the dotted
+// slog key, used for the human-readable MESSAGE suffix.
+type field struct {
+	name  string
+	key   string
+	value string
+}
+
+// Handler is a slog.Handler that sends each record as one journal entry.
+// Attributes are sent as VITO_<KEY> fields with the key upper-cased and
+// groups joined by underscores; they are also appended to MESSAGE as
+// key=value pairs so the default journalctl view stays readable.
+type Handler struct {
+	opts   Options
+	conn   *conn
+	fields []field
+	groups []string
+}
+
+// Available reports whether the journal socket exists.
+func Available() bool {
+	_, err := os.Stat(SocketPath)
+	return err == nil
+}
+
+// NewHandler connects to the journal socket and returns a Handler.
+func NewHandler(opts *Options) (*Handler, error) {
+	h := &Handler{}
+	if opts != nil {
+		h.opts = *opts
+	}
+	if h.opts.Level == nil {
+		h.opts.Level = slog.LevelInfo
+	}
+	path := h.opts.SocketPath
+	if path == "" {
+		path = SocketPath
+	}
+	c, err := dial(path)
+	if err != nil {
+		return nil, fmt.Errorf("connecting to journal: %w", err)
+	}
+	h.conn = c
+	return h, nil
+}
+
+// Close closes the journal socket. Handlers derived with WithAttrs or
+// WithGroup share it.
+func (h *Handler) Close() error {
+	return h.conn.close()
+}
+
+// Enabled implements slog.Handler.
+func (h *Handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.opts.Level.Level()
+}
+
+// WithAttrs implements slog.Handler.
+func (h *Handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	c := h.clone()
+	for _, a := range attrs {
+		c.fields = appendAttr(c.fields, c.groups, a)
+	}
+	return c
+}
+
+// WithGroup implements slog.Handler.
+func (h *Handler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	c := h.clone()
+	c.groups = append(c.groups, name)
+	return c
+}
+
+func (h *Handler) clone() *Handler {
+	return &Handler{
+		opts:   h.opts,
+		conn:   h.conn,
+		fields: append([]field{}, h.fields...),
+		groups: append([]string{}, h.groups...),
+	}
+}
+
+// Handle implements slog.Handler. If the journal cannot be reached the
+// entry is written to stderr instead, which systemd also captures.
+func (h *Handler) Handle(_ context.Context, r slog.Record) error {
+	fields := append([]field{}, h.fields...)
+	r.Attrs(func(a slog.Attr) bool {
+		fields = appendAttr(fields, h.groups, a)
+		return true
+	})
+
+	msg := formatMessage(r.Message, fields)
+
+	var buf bytes.Buffer
+	writeField(&buf, "MESSAGE", msg)
+	writeField(&buf, "PRIORITY", strconv.Itoa(priority(r.Level)))
+	if h.opts.Identifier != "" {
+		writeField(&buf, "SYSLOG_IDENTIFIER", h.opts.Identifier)
+	}
+	if h.opts.AddSource && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		writeField(&buf, "CODE_FILE", frame.File)
+		writeField(&buf, "CODE_LINE", strconv.Itoa(frame.Line))
+		writeField(&buf, "CODE_FUNC", frame.Function)
+	}
+	for _, f := range fields {
+		writeField(&buf, f.name, f.value)
+	}
+
+	if err := h.conn.send(buf.Bytes()); err != nil {
+		fmt.Fprintf(os.Stderr, "%s level=%s msg=%s (journal unavailable: %v)\n",
+			r.Time.Format("2006-01-02T15:04:05.000Z07:00"), r.Level, msg, err)
+		return err
+	}
+	return nil
+}
+
+// priority maps a slog level to a syslog priority.
+func priority(level slog.Level) int {
+	switch {
+	case level >= slog.LevelError:
+		return priErr
+	case level >= slog.LevelWarn:
+		return priWarning
+	case level >= slog.LevelInfo:
+		return priInfo
+	default:
+		return priDebug
+	}
+}
+
+// appendAttr flattens an attribute (recursing into groups) into fields.
+func appendAttr(fields []field, groups []string, a slog.Attr) []field {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return fields
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		attrs := a.Value.Group()
+		if len(attrs) == 0 {
+			return fields
+		}
+		if a.Key != "" {
+			groups = append(groups[:len(groups):len(groups)], a.Key)
+		}
+		for _, ga := range attrs {
+			fields = appendAttr(fields, groups, ga)
+		}
+		return fields
+	}
+
+	path := append(groups[:len(groups):len(groups)], a.Key)
+	return append(fields, field{
+		name:  FieldName(strings.Join(path, "_")),
+		key:   strings.Join(path, "."),
+		value: formatValue(a.Value),
+	})
+}
+
+// FieldName converts an attribute key to a journal field name: upper-case
+// letters, digits and underscores, prefixed with FieldPrefix. For example
+// "job_id" becomes "VITO_JOB_ID".
+func FieldName(key string) string {
+	var b strings.Builder
+	b.WriteString(FieldPrefix)
+	for _, r := range strings.ToUpper(key) {
+		if (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		} else {
+			b.WriteByte('_')
+		}
+	}
+	name := b.String()
+	if len(name) > maxFieldName {
+		name = name[:maxFieldName]
+	}
+	return name
+}
+
+func formatValue(v slog.Value) string {
+	switch v.Kind() {
+	case slog.KindString:
+		return v.String()
+	case slog.KindTime:
+		return v.Time().Format("2006-01-02T15:04:05.999999999Z07:00")
+	case slog.KindAny:
+		switch a := v.Any().(type) {
+		case error:
+			return a.Error()
+		case fmt.Stringer:
+			return a.String()
+		case []byte:
+			return string(a)
+		}
+		if data, err := json.Marshal(v.Any()); err == nil {
+			return string(data)
+		}
+		return fmt.Sprint(v.Any())
+	default:
+		return v.String()
+	}
+}
+
+// formatMessage appends key=value pairs to msg, quoting values the way
+// slog's text handler does.
+func formatMessage(msg string, fields []field) string {
+	if len(fields) == 0 {
+		return msg
+	}
+	var b strings.Builder
+	b.WriteString(msg)
+	for _, f := range fields {
+		b.WriteByte(' ')
+		b.WriteString(f.key)
+		b.WriteByte('=')
+		if needsQuoting(f.value) {
+			b.WriteString(strconv.Quote(f.value))
+		} else {
+			b.WriteString(f.value)
+		}
+	}
+	return b.String()
+}
+
+func needsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// writeField encodes one field in the journal native format. Values
+// containing newlines use the binary form: name, newline, little-endian
+// 64-bit length, value, newline.
+func writeField(buf *bytes.Buffer, name, value string) {
+	buf.WriteString(name)
+	if !strings.Contains(value, "\n") {
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteByte('\n')
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	buf.Write(size[:])
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+// conn is a datagram connection to the journal, shared by derived handlers.
+type conn struct {
+	mu   sync.Mutex
+	sock sender
+}
+
+// sender abstracts the platform-specific socket.
+type sender interface {
+	send(data []byte) error
+	close() error
+}
+
+func dial(path string) (*conn, error) {
+	s, err := dialSocket(path)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{sock: s}, nil
+}
+
+func (c *conn) send(data []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sock.send(data)
+}
+
+func (c *conn) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sock.close()
+}
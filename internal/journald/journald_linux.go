@@ -0,0 +1,67 @@
+//go:build linux
+
+package journald
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+type unixSocket struct {
+	c *net.UnixConn
+}
+
+func dialSocket(path string) (sender, error) {
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return nil, err
+	}
+	return &unixSocket{c: c}, nil
+}
+
+func (s *unixSocket) send(data []byte) error {
+	_, err := s.c.Write(data)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, syscall.EMSGSIZE) && !errors.Is(err, syscall.ENOBUFS) {
+		return err
+	}
+	return s.sendFile(data)
+}
+
+// sendFile passes an entry too large for one datagram as a file
+// descriptor, as sd_journal_send does: the payload is written to an
+// unlinked file on tmpfs and the descriptor is sent with SCM_RIGHTS.
+func (s *unixSocket) sendFile(data []byte) error {
+	f, err := os.CreateTemp("/dev/shm", "vito-journal-*")
+	if err != nil {
+		return fmt.Errorf("creating journal payload file: %w", err)
+	}
+	defer f.Close()
+	if err := os.Remove(f.Name()); err != nil {
+		return fmt.Errorf("unlinking journal payload file: %w", err)
+	}
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("writing journal payload file: %w", err)
+	}
+	rc, err := s.c.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sendErr error
+	if err := rc.Write(func(fd uintptr) bool {
+		sendErr = syscall.Sendmsg(int(fd), nil, syscall.UnixRights(int(f.Fd())), nil, 0)
+		return sendErr != syscall.EAGAIN
+	}); err != nil {
+		return err
+	}
+	return sendErr
+}
+
+func (s *unixSocket) close() error {
+	return s.c.Close()
+}
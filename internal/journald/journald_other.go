@@ -0,0 +1,9 @@
+//go:build !linux
+
+package journald
+
+import "errors"
+
+func dialSocket(string) (sender, error) {
+	return nil, errors.New("the systemd journal is only available on Linux")
+}
@@ -0,0 +1,170 @@
+package journald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// listen creates a fake journal socket and returns a function that reads
+// the next entry, following a passed file descriptor if one is sent.
+func listen(t *testing.T) (string, func() map[string]string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "journal.sock")
+	l, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	read := func() map[string]string {
+		t.Helper()
+		buf := make([]byte, 1<<20)
+		oob := make([]byte, syscall.CmsgSpace(4))
+		l.SetReadDeadline(time.Now().Add(5 * time.Second))
+		n, oobn, _, _, err := l.ReadMsgUnix(buf, oob)
+		if err != nil {
+			t.Fatalf("failed to read entry: %v", err)
+		}
+		data := buf[:n]
+		if oobn > 0 {
+			msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+			if err != nil {
+				t.Fatalf("failed to parse control message: %v", err)
+			}
+			fds, err := syscall.ParseUnixRights(&msgs[0])
+			if err != nil {
+				t.Fatalf("failed to parse rights: %v", err)
+			}
+			f := os.NewFile(uintptr(fds[0]), "payload")
+			defer f.Close()
+			f.Seek(0, io.SeekStart)
+			if data, err = io.ReadAll(f); err != nil {
+				t.Fatalf("failed to read payload: %v", err)
+			}
+		}
+		return parseEntry(t, data)
+	}
+	return path, read
+}
+
+func parseEntry(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	fields := map[string]string{}
+	for len(data) > 0 {
+		nl := bytes.IndexByte(data, '\n')
+		if nl < 0 {
+			t.Fatalf("unterminated field: %q", data)
+		}
+		line := data[:nl]
+		if eq := bytes.IndexByte(line, '='); eq >= 0 {
+			fields[string(line[:eq])] = string(line[eq+1:])
+			data = data[nl+1:]
+			continue
+		}
+		size := binary.LittleEndian.Uint64(data[nl+1 : nl+9])
+		fields[string(line)] = string(data[nl+9 : nl+9+int(size)])
+		data = data[nl+9+int(size)+1:]
+	}
+	return fields
+}
+
+func TestHandler_Fields(t *testing.T) {
+	path, read := listen(t)
+	h, err := NewHandler(&Options{SocketPath: path, Identifier: "vito-root", Level: slog.LevelDebug})
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	defer h.Close()
+
+	logger := slog.New(h).With(slog.String("job_id", "abc123"), slog.Int("peer_pid", 42))
+	logger.Warn("command completed",
+		slog.Int("exit_code", 1),
+		slog.Any("error", errors.New("boom")),
+		slog.Group("sandbox", slog.Any("read", []string{"/srv"})),
+		slog.String("output", "line1\nline2"),
+	)
+
+	e := read()
+	want := map[string]string{
+		"PRIORITY":          "4",
+		"SYSLOG_IDENTIFIER": "vito-root",
+		"VITO_JOB_ID":       "abc123",
+		"VITO_PEER_PID":     "42",
+		"VITO_EXIT_CODE":    "1",
+		"VITO_ERROR":        "boom",
+		"VITO_SANDBOX_READ": `["/srv"]`,
+		"VITO_OUTPUT":       "line1\nline2",
+	}
+	for k, v := range want {
+		if e[k] != v {
+			t.Errorf("%s = %q, want %q", k, e[k], v)
+		}
+	}
+	if !strings.HasPrefix(e["MESSAGE"], "command completed job_id=abc123 peer_pid=42 exit_code=1") {
+		t.Errorf("unexpected MESSAGE %q", e["MESSAGE"])
+	}
+	if !strings.Contains(e["MESSAGE"], `output="line1\nline2"`) {
+		t.Errorf("expected quoted multi-line value in MESSAGE, got %q", e["MESSAGE"])
+	}
+}
+
+func TestHandler_Level(t *testing.T) {
+	path, read := listen(t)
+	h, err := NewHandler(&Options{SocketPath: path})
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	defer h.Close()
+
+	logger := slog.New(h)
+	logger.Debug("hidden")
+	logger.Error("shown")
+
+	e := read()
+	if e["MESSAGE"] != "shown" || e["PRIORITY"] != "3" {
+		t.Errorf("unexpected entry %v", e)
+	}
+}
+
+func TestHandler_LargeEntry(t *testing.T) {
+	if _, err := os.Stat("/dev/shm"); err != nil {
+		t.Skip("/dev/shm not available")
+	}
+	path, read := listen(t)
+	h, err := NewHandler(&Options{SocketPath: path})
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	defer h.Close()
+
+	big := strings.Repeat("x", 4<<20)
+	slog.New(h).Info("large", slog.String("data", big))
+
+	if e := read(); e["VITO_DATA"] != big {
+		t.Errorf("expected %d bytes of data, got %d", len(big), len(e["VITO_DATA"]))
+	}
+}
+
+func TestFieldName(t *testing.T) {
+	tests := map[string]string{
+		"job_id":                 "VITO_JOB_ID",
+		"peer.exe":               "VITO_PEER_EXE",
+		"sandbox-read":           "VITO_SANDBOX_READ",
+		strings.Repeat("a", 100): "VITO_" + strings.Repeat("A", maxFieldName-len(FieldPrefix)),
+	}
+	for key, want := range tests {
+		if got := FieldName(key); got != want {
+			t.Errorf("FieldName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
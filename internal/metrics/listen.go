@@ -0,0 +1,127 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"vito-local/internal/umask"
+)
+
+// Listen opens the listener for the metrics endpoint. An address starting
+// with "/" is a Unix socket path, created with the given mode and group
+// (gid -1 keeps the default) and replacing a stale socket; anything else is
+// a TCP host:port, which must be a loopback address so metrics are never
+// exposed on the network.
+func Listen(addr string, mode os.FileMode, gid int) (net.Listener, error) {
+	if strings.HasPrefix(addr, "/") {
+		return listenUnix(addr, mode, gid)
+	}
+
+	if err := ValidateAddr(addr); err != nil {
+		return nil, err
+	}
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", addr, err)
+	}
+	return l, nil
+}
+
+// listenUnix creates the metrics socket. The mode comes from the umask in
+// effect while the socket is bound, and the group is changed without
+// following symlinks: a chmod or chown by path would apply to the target
+// of a link put in the socket's place.
+func listenUnix(path string, mode os.FileMode, gid int) (net.Listener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("removing stale metrics socket: %w", err)
+	}
+	var l net.Listener
+	err := umask.With(int(^mode&0777), func() (err error) {
+		l, err = net.Listen("unix", path)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", path, err)
+	}
+
+	// Chown needs root. Without it (during development) the socket keeps
+	// the current user's group.
+	if gid >= 0 {
+		if err := os.Lchown(path, -1, gid); err != nil && os.Geteuid() == 0 {
+			l.Close()
+			return nil, fmt.Errorf("chown metrics socket: %w", err)
+		}
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		l.Close()
+		return nil, err
+	}
+	if fi.Mode().Type() != os.ModeSocket {
+		l.Close()
+		return nil, fmt.Errorf("%s was replaced after it was created", path)
+	}
+	if fi.Mode().Perm() != mode.Perm() {
+		l.Close()
+		return nil, fmt.Errorf("metrics socket mode is %04o, expected %04o", fi.Mode().Perm(), mode.Perm())
+	}
+	return l, nil
+}
+
+// ValidateAddr checks a metrics address without listening on it.
+func ValidateAddr(addr string) error {
+	if strings.HasPrefix(addr, "/") {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("invalid metrics address %q: %w", addr, err)
+	}
+	if !isLoopback(host) {
+		return fmt.Errorf("metrics address %q must be a loopback address or a Unix socket path", addr)
+	}
+	return nil
+}
+
+func isLoopback(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Server serves a registry at /metrics.
+type Server struct {
+	http *http.Server
+}
+
+// Serve starts serving the registry on l in the background. Serving errors
+// are logged.
+func Serve(l net.Listener, reg *Registry, logger *slog.Logger) *Server {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", reg.Handler())
+	s := &Server{http: &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      10 * time.Second,
+	}}
+	go func() {
+		if err := s.http.Serve(l); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("metrics server failed", slog.String("error", err.Error()))
+		}
+	}()
+	return s
+}
+
+// Shutdown stops the server, waiting for in-flight scrapes.
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.http.Shutdown(ctx)
+}
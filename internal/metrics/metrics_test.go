@@ -0,0 +1,117 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_WriteTo(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounter("requests", "Requests handled.", "code")
+	g := reg.NewGauge("info", "Build info.", "version")
+	reg.NewGaugeFunc("active", "", func() float64 { return 3 })
+	h := reg.NewHistogram("duration_seconds", "Duration.", []float64{0.1, 1})
+
+	c.Inc("200")
+	c.Add(2, "500")
+	c.Inc("200")
+	g.Set(1, `v"1\`)
+	h.Observe(0.05)
+	h.Observe(0.5)
+	h.Observe(5)
+
+	var buf bytes.Buffer
+	if _, err := reg.WriteTo(&buf); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := `# TYPE requests counter
+# HELP requests Requests handled.
+requests_total{code="200"} 2
+requests_total{code="500"} 2
+# TYPE info gauge
+# HELP info Build info.
+info{version="v\"1\\"} 1
+# TYPE active gauge
+active 3
+# TYPE duration_seconds histogram
+# HELP duration_seconds Duration.
+duration_seconds_bucket{le="0.1"} 1
+duration_seconds_bucket{le="1"} 2
+duration_seconds_bucket{le="+Inf"} 3
+duration_seconds_count 3
+duration_seconds_sum 5.55
+# EOF
+`
+	if got := buf.String(); got != want {
+		t.Errorf("unexpected output:\n%s\nwant:\n%s", got, want)
+	}
+	if c.Value("200") != 2 || h.Count() != 3 {
+		t.Error("unexpected accessor values")
+	}
+}
+
+func TestCounter_WrongLabelCount(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for wrong label count")
+		}
+	}()
+	NewRegistry().NewCounter("x", "", "a").Inc()
+}
+
+func TestListen_RejectsNonLoopback(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:9464", ":9464", "10.0.0.1:9464", "example.com:9464"} {
+		if l, err := Listen(addr, 0660, -1); err == nil {
+			l.Close()
+			t.Errorf("Listen(%q): expected error", addr)
+		}
+		if err := ValidateAddr(addr); err == nil {
+			t.Errorf("ValidateAddr(%q): expected error", addr)
+		}
+	}
+}
+
+func TestServe_UnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "metrics.sock")
+	l, err := Listen(path, 0660, -1)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm() != 0660 {
+		t.Fatalf("expected socket with mode 0660, got %v %v", info, err)
+	}
+
+	reg := NewRegistry()
+	reg.NewCounter("hits", "").Inc()
+	srv := Serve(l, reg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	defer srv.Shutdown(context.Background())
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://metrics/metrics")
+	if err != nil {
+		t.Fatalf("scrape failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if ct := resp.Header.Get("Content-Type"); ct != ContentType {
+		t.Errorf("unexpected content type %q", ct)
+	}
+	if !strings.Contains(string(body), "hits_total 1\n") || !strings.HasSuffix(string(body), "# EOF\n") {
+		t.Errorf("unexpected body:\n%s", body)
+	}
+}
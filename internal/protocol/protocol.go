@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"time"
 )
 
 // MaxRequestSize is the maximum allowed size for a single request line (10 MB).
@@ -13,9 +14,75 @@ const MaxRequestSize = 10 << 20
 // Request represents a command execution request from a client.
 type Request struct {
 	Command string            `json:"command,omitempty"`
-	Action  string            `json:"action,omitempty"` // "update", "check-update", "version"
+	Action  string            `json:"action,omitempty"` // "update", "check-update", "rollback", "version", "history", "health"
 	Env     map[string]string `json:"env,omitempty"`
 	Cwd     string            `json:"cwd,omitempty"`
+	// Secrets maps environment variable names to named secrets stored on
+	// the server. Only the names cross the socket; the values are read by
+	// the daemon and injected into the command's environment.
+	Secrets map[string]string `json:"secrets,omitempty"`
+	// SecretEnv lists keys of Env whose values are sensitive and must be
+	// redacted from output and logs.
+	SecretEnv []string `json:"secret_env,omitempty"`
+	// Sandbox confines the command to the given filesystem paths. It may
+	// only narrow the server's policy, never widen it.
+	Sandbox *Sandbox `json:"sandbox,omitempty"`
+	// Capabilities is the exact set of Linux capabilities the command keeps
+	// (e.g. "CAP_NET_BIND_SERVICE"). Absent means the server policy applies;
+	// an empty list drops every capability. Not omitempty so that an empty
+	// list survives marshaling.
+	Capabilities []string `json:"capabilities"`
+	// History filters the "history" action.
+	History *HistoryQuery `json:"history,omitempty"`
+	// Traceparent is the caller's W3C trace context
+	// (https://www.w3.org/TR/trace-context/#traceparent-header). The
+	// server's spans join that trace, and the command sees TRACEPARENT set
+	// to its execution span. An invalid value starts a new trace.
+	Traceparent string `json:"traceparent,omitempty"`
+}
+
+// HistoryQuery filters past executions. Omitted fields do not filter.
+type HistoryQuery struct {
+	Since    time.Time `json:"since,omitzero"`
+	Until    time.Time `json:"until,omitzero"`
+	ExitCode *int      `json:"exit_code,omitempty"`
+	// Failed selects executions that errored or exited non-zero.
+	Failed  bool    `json:"failed,omitempty"`
+	PeerUID *uint32 `json:"peer_uid,omitempty"`
+	PeerPID *int32  `json:"peer_pid,omitempty"`
+	PeerExe string  `json:"peer_exe,omitempty"`
+	// Command matches executions whose command or action contains it.
+	Command string `json:"command,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+}
+
+// HistoryEntry is one past execution in a "history" response.
+type HistoryEntry struct {
+	JobID   string `json:"job_id"`
+	PeerUID uint32 `json:"peer_uid"`
+	PeerPID int32  `json:"peer_pid"`
+	PeerExe string `json:"peer_exe,omitempty"`
+	// Listener names the listener the request arrived on; empty for the
+	// main socket.
+	Listener    string    `json:"listener,omitempty"`
+	Action      string    `json:"action,omitempty"`
+	Command     string    `json:"command,omitempty"`
+	Cwd         string    `json:"cwd,omitempty"`
+	EnvKeys     []string  `json:"env_keys,omitempty"`
+	Start       time.Time `json:"start"`
+	End         time.Time `json:"end"`
+	ExitCode    *int      `json:"exit_code,omitempty"`
+	Error       string    `json:"error,omitempty"`
+	StdoutBytes int64     `json:"stdout_bytes"`
+	StderrBytes int64     `json:"stderr_bytes"`
+	// Recording is the path of the output recording, if one was made.
+	Recording string `json:"recording,omitempty"`
+}
+
+// Sandbox lists the filesystem subtrees a command may read and write.
+type Sandbox struct {
+	Read  []string `json:"read,omitempty"`
+	Write []string `json:"write,omitempty"`
 }
 
 // ResponseType identifies the kind of response message.
@@ -28,6 +95,8 @@ const (
 	TypeError   ResponseType = "error"
 	TypeUpdate  ResponseType = "update"
 	TypeVersion ResponseType = "version"
+	TypeHistory ResponseType = "history"
+	TypeHealth  ResponseType = "health"
 )
 
 // UpdateStatus identifies the status of an update operation.
@@ -44,13 +113,63 @@ const (
 
 // Response represents a single line of output sent back to the client.
 type Response struct {
-	Type           ResponseType `json:"type"`
-	Data           string       `json:"data,omitempty"`
-	Code           *int         `json:"code,omitempty"`
-	Message        string       `json:"message,omitempty"`
-	UpdateStatus   UpdateStatus `json:"update_status,omitempty"`
-	CurrentVersion string       `json:"current_version,omitempty"`
-	LatestVersion  string       `json:"latest_version,omitempty"`
+	Type           ResponseType   `json:"type"`
+	Data           string         `json:"data,omitempty"`
+	Code           *int           `json:"code,omitempty"`
+	Message        string         `json:"message,omitempty"`
+	UpdateStatus   UpdateStatus   `json:"update_status,omitempty"`
+	CurrentVersion string         `json:"current_version,omitempty"`
+	LatestVersion  string         `json:"latest_version,omitempty"`
+	Entries        []HistoryEntry `json:"entries,omitempty"`
+	Truncated      bool           `json:"truncated,omitempty"`
+	Health         *Health        `json:"health,omitempty"`
+}
+
+// Health status values.
+const (
+	HealthOK       = "ok"
+	HealthDegraded = "degraded"
+)
+
+// Health is the service's self-diagnostic report.
+type Health struct {
+	// Status is HealthOK when every check passed, HealthDegraded otherwise.
+	Status         string    `json:"status"`
+	Version        string    `json:"version"`
+	StartedAt      time.Time `json:"started_at"`
+	UptimeSeconds  float64   `json:"uptime_seconds"`
+	Connections    int       `json:"connections"`
+	MaxConnections int       `json:"max_connections"`
+	// ActiveJobs are commands currently executing; QueuedJobs are accepted
+	// requests not yet executing (being read, validated or prepared).
+	ActiveJobs int           `json:"active_jobs"`
+	QueuedJobs int           `json:"queued_jobs"`
+	Socket     SocketInfo    `json:"socket"`
+	Disks      []DiskInfo    `json:"disks,omitempty"`
+	Checks     []HealthCheck `json:"checks"`
+}
+
+// SocketInfo describes the command socket.
+type SocketInfo struct {
+	Path             string `json:"path"`
+	Mode             string `json:"mode,omitempty"`
+	Owner            string `json:"owner,omitempty"`
+	Group            string `json:"group,omitempty"`
+	SystemdActivated bool   `json:"systemd_activated"`
+}
+
+// DiskInfo reports free space on the filesystem holding Path.
+type DiskInfo struct {
+	Path       string `json:"path"`
+	FreeBytes  uint64 `json:"free_bytes"`
+	TotalBytes uint64 `json:"total_bytes"`
+}
+
+// HealthCheck is the result of one self-diagnostic.
+type HealthCheck struct {
+	Name    string `json:"name"`
+	OK      bool   `json:"ok"`
+	Message string `json:"message,omitempty"`
 }
 
 // StdoutResponse creates a response for a line of stdout output.
@@ -92,6 +211,18 @@ func VersionResponse(currentVersion string) Response {
 	}
 }
 
+// HistoryResponse creates a response listing past executions, newest
+// first. "entries" is omitted when nothing matched; truncated is set when
+// more matched than were returned.
+func HistoryResponse(entries []HistoryEntry, truncated bool) Response {
+	return Response{Type: TypeHistory, Entries: entries, Truncated: truncated}
+}
+
+// HealthResponse creates a response carrying a health report.
+func HealthResponse(h *Health) Response {
+	return Response{Type: TypeHealth, Health: h}
+}
+
 // ParseRequest reads a single newline-delimited JSON request from the reader.
 // The request is limited to MaxRequestSize bytes to prevent memory exhaustion.
 func ParseRequest(reader io.Reader) (*Request, error) {
@@ -119,7 +250,7 @@ func ParseRequest(reader io.Reader) (*Request, error) {
 	// Validate Action if provided
 	if req.Action != "" {
 		switch req.Action {
-		case "update", "check-update", "version":
+		case "update", "check-update", "rollback", "version", "history", "health":
 			// valid actions
 		default:
 			return nil, fmt.Errorf("unknown action: %s", req.Action)
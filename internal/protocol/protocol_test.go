@@ -228,6 +228,9 @@ func TestParseRequest_ActionValid(t *testing.T) {
 		{"version"},
 		{"check-update"},
 		{"update"},
+		{"rollback"},
+		{"history"},
+		{"health"},
 	}
 
 	for _, tc := range tests {
@@ -301,3 +304,24 @@ func TestWriteResponse_Version(t *testing.T) {
 		t.Errorf("expected version v1.2.3, got %q", decoded.CurrentVersion)
 	}
 }
+
+func TestParseRequest_HistoryQuery(t *testing.T) {
+	input := `{"action":"history","history":{"since":"2026-01-02T03:00:00Z","exit_code":0,"command":"nginx","limit":5}}` + "\n"
+	req, err := ParseRequest(strings.NewReader(input))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	q := req.History
+	if q == nil {
+		t.Fatal("expected history query")
+	}
+	if q.Since.IsZero() || !q.Until.IsZero() {
+		t.Errorf("unexpected time range %v - %v", q.Since, q.Until)
+	}
+	if q.ExitCode == nil || *q.ExitCode != 0 {
+		t.Errorf("expected exit code filter 0, got %v", q.ExitCode)
+	}
+	if q.Command != "nginx" || q.Limit != 5 {
+		t.Errorf("unexpected query %+v", q)
+	}
+}
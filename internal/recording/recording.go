@@ -0,0 +1,263 @@
+// Package recording captures command output, with timing, as asciicast v2
+// files (https://docs.asciinema.org/manual/asciicast/v2/) that can be
+// replayed with asciinema play or the asciinema web player.
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDir is the default recordings directory.
+const DefaultDir = "/var/lib/vito-root/recordings"
+
+// Extension is the file extension of recordings.
+const Extension = ".cast"
+
+// pruneInterval limits how often finishing a recording scans the directory.
+const pruneInterval = time.Minute
+
+// Terminal size written to the header. Commands run without a terminal, so
+// this only tells players how to lay out the replay.
+const (
+	termWidth  = 120
+	termHeight = 40
+)
+
+// header is the first line of an asciicast v2 file.
+type header struct {
+	Version   int               `json:"version"`
+	Width     int               `json:"width"`
+	Height    int               `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Command   string            `json:"command,omitempty"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// Recorder creates recordings in a directory and prunes old ones.
+type Recorder struct {
+	dir     string
+	maxSize int64
+	maxAge  time.Duration
+
+	mu        sync.Mutex
+	active    map[string]bool
+	lastPrune time.Time
+}
+
+// New creates the recordings directory (mode 0700) and returns a Recorder.
+// Once the directory holds more than maxSize bytes, the oldest recordings
+// are removed; recordings older than maxAge are removed regardless. Zero
+// disables either limit.
+func New(dir string, maxSize int64, maxAge time.Duration) (*Recorder, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("creating recordings directory: %w", err)
+	}
+	return &Recorder{
+		dir:     dir,
+		maxSize: maxSize,
+		maxAge:  maxAge,
+		active:  make(map[string]bool),
+	}, nil
+}
+
+// Dir returns the recordings directory.
+func (r *Recorder) Dir() string {
+	return r.dir
+}
+
+// Start begins a recording for a job. The command is stored in the header
+// and must already be redacted.
+func (r *Recorder) Start(jobID, command string, start time.Time) (*Session, error) {
+	name := start.UTC().Format("20060102T150405Z") + "-" + jobID + Extension
+	path := filepath.Join(r.dir, name)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("creating recording: %w", err)
+	}
+
+	s := &Session{
+		recorder: r,
+		path:     path,
+		f:        f,
+		w:        bufio.NewWriter(f),
+		start:    start,
+	}
+	s.writeJSON(header{
+		Version:   2,
+		Width:     termWidth,
+		Height:    termHeight,
+		Timestamp: start.Unix(),
+		Command:   command,
+		Title:     "vito-root job " + jobID,
+		Env:       map[string]string{"SHELL": "/bin/bash", "TERM": "xterm-256color"},
+	})
+	if s.err != nil {
+		f.Close()
+		os.Remove(path)
+		return nil, fmt.Errorf("writing recording header: %w", s.err)
+	}
+
+	r.mu.Lock()
+	r.active[path] = true
+	r.mu.Unlock()
+	return s, nil
+}
+
+// Prune removes recordings older than the age limit, then the oldest
+// recordings until the directory fits the size limit. Recordings in
+// progress are never removed.
+func (r *Recorder) Prune(now time.Time) error {
+	entries, err := os.ReadDir(r.dir)
+	if err != nil {
+		return fmt.Errorf("reading recordings directory: %w", err)
+	}
+
+	type file struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []file
+	var total int64
+	for _, e := range entries {
+		if !e.Type().IsRegular() || !strings.HasSuffix(e.Name(), Extension) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{filepath.Join(r.dir, e.Name()), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.Before(files[j].modTime) })
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var errs []string
+	for _, f := range files {
+		if r.active[f.path] {
+			continue
+		}
+		expired := r.maxAge > 0 && now.Sub(f.modTime) > r.maxAge
+		oversize := r.maxSize > 0 && total > r.maxSize
+		if !expired && !oversize {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		total -= f.size
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("pruning recordings: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// Session is one recording in progress. It is safe for concurrent use, so
+// stdout and stderr can be written from separate goroutines.
+type Session struct {
+	recorder *Recorder
+	path     string
+	start    time.Time
+
+	mu  sync.Mutex
+	f   *os.File
+	w   *bufio.Writer
+	err error
+}
+
+// Path returns the recording's file path.
+func (s *Session) Path() string {
+	return s.path
+}
+
+// Output records a chunk of command output. Both stdout and stderr are
+// recorded as terminal output ("o" events), interleaved as they arrived.
+// Bare newlines are written as CRLF so the replay renders like a terminal.
+func (s *Session) Output(data string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.err != nil || data == "" {
+		return
+	}
+	elapsed := time.Since(s.start).Seconds()
+	s.writeJSON([]any{json.Number(strconv.FormatFloat(elapsed, 'f', 6, 64)), "o", toCRLF(data)})
+}
+
+// Close finishes the recording and returns the first write error, if any.
+// The file is kept even on error so whatever was captured is available.
+// Old recordings are pruned at most once per pruneInterval.
+func (s *Session) Close() error {
+	s.mu.Lock()
+	if s.err == nil {
+		s.err = s.w.Flush()
+	}
+	if err := s.f.Close(); err != nil && s.err == nil {
+		s.err = err
+	}
+	err := s.err
+	s.mu.Unlock()
+
+	r := s.recorder
+	now := time.Now()
+	r.mu.Lock()
+	delete(r.active, s.path)
+	pruneDue := now.Sub(r.lastPrune) >= pruneInterval
+	if pruneDue {
+		r.lastPrune = now
+	}
+	r.mu.Unlock()
+
+	if err != nil {
+		err = fmt.Errorf("writing recording %s: %w", s.path, err)
+	}
+	if pruneDue {
+		err = errors.Join(err, r.Prune(now))
+	}
+	return err
+}
+
+func (s *Session) writeJSON(v any) {
+	if s.err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		s.err = err
+		return
+	}
+	data = append(data, '\n')
+	_, s.err = s.w.Write(data)
+}
+
+// toCRLF converts bare "\n" to "\r\n".
+func toCRLF(s string) string {
+	if !strings.Contains(s, "\n") {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s) + strings.Count(s, "\n"))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\n' && (i == 0 || s[i-1] != '\r') {
+			b.WriteByte('\r')
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
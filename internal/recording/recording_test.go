@@ -0,0 +1,159 @@
+package recording
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func readCast(t *testing.T, path string) (header, [][]any) {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open recording: %v", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("recording is empty")
+	}
+	var h header
+	if err := json.Unmarshal(scanner.Bytes(), &h); err != nil {
+		t.Fatalf("invalid header: %v", err)
+	}
+	var events [][]any
+	for scanner.Scan() {
+		var e []any
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid event %q: %v", scanner.Text(), err)
+		}
+		events = append(events, e)
+	}
+	return h, events
+}
+
+func TestSession_WritesAsciicast(t *testing.T) {
+	r, err := New(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+
+	start := time.Now()
+	s, err := r.Start("job1", "echo hi", start)
+	if err != nil {
+		t.Fatalf("failed to start recording: %v", err)
+	}
+	s.Output("hello\nworld\r\n")
+	s.Output("")
+	s.Output("err\n")
+	if err := s.Close(); err != nil {
+		t.Fatalf("failed to close recording: %v", err)
+	}
+
+	if !strings.HasSuffix(s.Path(), "-job1.cast") {
+		t.Errorf("unexpected path %s", s.Path())
+	}
+	info, err := os.Stat(s.Path())
+	if err != nil {
+		t.Fatalf("failed to stat recording: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %o", info.Mode().Perm())
+	}
+
+	h, events := readCast(t, s.Path())
+	if h.Version != 2 || h.Command != "echo hi" || h.Timestamp != start.Unix() {
+		t.Errorf("unexpected header %+v", h)
+	}
+	if len(events) != 2 {
+		t.Fatalf("expected 2 events, got %d", len(events))
+	}
+	if events[0][1] != "o" || events[0][2] != "hello\r\nworld\r\n" {
+		t.Errorf("unexpected first event %v", events[0])
+	}
+	if _, ok := events[1][0].(float64); !ok {
+		t.Errorf("expected numeric timestamp, got %v", events[1][0])
+	}
+}
+
+func TestSession_ConcurrentOutput(t *testing.T) {
+	r, err := New(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	s, err := r.Start("job2", "x", time.Now())
+	if err != nil {
+		t.Fatalf("failed to start recording: %v", err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				s.Output("line\n")
+			}
+		}()
+	}
+	wg.Wait()
+	s.Close()
+
+	if _, events := readCast(t, s.Path()); len(events) != 400 {
+		t.Errorf("expected 400 events, got %d", len(events))
+	}
+}
+
+func TestRecorder_Prune(t *testing.T) {
+	dir := t.TempDir()
+	r, err := New(dir, 250, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	now := time.Now()
+
+	write := func(name string, size int, age time.Duration) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte(strings.Repeat("x", size)), 0600)
+		os.Chtimes(path, now.Add(-age), now.Add(-age))
+		return path
+	}
+	expired := write("a.cast", 10, 2*time.Hour)
+	oldest := write("b.cast", 100, 30*time.Minute)
+	middle := write("c.cast", 100, 20*time.Minute)
+	newest := write("d.cast", 100, 10*time.Minute)
+	other := write("notes.txt", 1000, 3*time.Hour)
+
+	active, err := r.Start("job3", "x", now.Add(-time.Hour))
+	if err != nil {
+		t.Fatalf("failed to start recording: %v", err)
+	}
+	os.Chtimes(active.Path(), now.Add(-2*time.Hour), now.Add(-2*time.Hour))
+
+	if err := r.Prune(now); err != nil {
+		t.Fatalf("prune failed: %v", err)
+	}
+
+	exists := func(path string) bool {
+		_, err := os.Stat(path)
+		return err == nil
+	}
+	if exists(expired) {
+		t.Error("expected expired recording to be removed")
+	}
+	if exists(oldest) {
+		t.Error("expected oldest recording to be removed to fit size limit")
+	}
+	for _, path := range []string{middle, newest, other, active.Path()} {
+		if !exists(path) {
+			t.Errorf("expected %s to be kept", filepath.Base(path))
+		}
+	}
+	active.Close()
+}
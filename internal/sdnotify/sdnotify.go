@@ -0,0 +1,85 @@
+// Package sdnotify implements the systemd service notification protocol
+// (sd_notify(3)): readiness, status text, reload/stop announcements and
+// watchdog keep-alives, sent as datagrams to $NOTIFY_SOCKET.
+//
+// Every function is a no-op returning nil when the service was not started
+// by systemd with Type=notify, so callers need not check.
+package sdnotify
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notify sends the given KEY=VALUE assignments in one datagram.
+func Notify(assignments ...string) error {
+	path := os.Getenv("NOTIFY_SOCKET")
+	if path == "" {
+		return nil
+	}
+	// Go maps a leading "@" to the abstract namespace, as systemd does.
+	c, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		return fmt.Errorf("connecting to notify socket: %w", err)
+	}
+	defer c.Close()
+	if _, err := c.Write([]byte(strings.Join(assignments, "\n") + "\n")); err != nil {
+		return fmt.Errorf("sending notification: %w", err)
+	}
+	return nil
+}
+
+// Enabled reports whether a service manager is listening for notifications.
+func Enabled() bool {
+	return os.Getenv("NOTIFY_SOCKET") != ""
+}
+
+// Ready reports that startup finished, along with an initial status.
+func Ready(status string) error {
+	return Notify("READY=1", "STATUS="+status)
+}
+
+// Status updates the free-form status shown by systemctl status.
+func Status(status string) error {
+	return Notify("STATUS=" + status)
+}
+
+// Reloading reports that the service is reloading its configuration. Send
+// Ready once the reload completes.
+func Reloading() error {
+	assignments := []string{"RELOADING=1"}
+	// Type=notify-reload requires the time the reload started.
+	if usec, ok := monotonicUsec(); ok {
+		assignments = append(assignments, "MONOTONIC_USEC="+strconv.FormatUint(usec, 10))
+	}
+	return Notify(assignments...)
+}
+
+// Stopping reports that the service is shutting down.
+func Stopping() error {
+	return Notify("STOPPING=1")
+}
+
+// Watchdog sends a keep-alive. Once WatchdogInterval passes without one,
+// systemd considers the service hung and restarts it.
+func Watchdog() error {
+	return Notify("WATCHDOG=1")
+}
+
+// WatchdogInterval returns the watchdog timeout (WatchdogSec=) if it
+// applies to this process, or 0 when the watchdog is disabled. Keep-alives
+// should be sent at about half this interval.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseUint(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec == 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
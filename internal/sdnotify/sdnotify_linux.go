@@ -0,0 +1,20 @@
+//go:build linux
+
+package sdnotify
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// monotonicUsec reads CLOCK_MONOTONIC, the clock systemd expects in
+// MONOTONIC_USEC.
+func monotonicUsec() (uint64, bool) {
+	const clockMonotonic = 1
+	var ts syscall.Timespec
+	_, _, errno := syscall.Syscall(syscall.SYS_CLOCK_GETTIME, clockMonotonic, uintptr(unsafe.Pointer(&ts)), 0)
+	if errno != 0 {
+		return 0, false
+	}
+	return uint64(ts.Sec)*1e6 + uint64(ts.Nsec)/1e3, true
+}
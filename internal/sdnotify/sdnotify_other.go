@@ -0,0 +1,7 @@
+//go:build !linux
+
+package sdnotify
+
+func monotonicUsec() (uint64, bool) {
+	return 0, false
+}
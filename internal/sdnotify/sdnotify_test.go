@@ -0,0 +1,111 @@
+package sdnotify
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listen creates a fake notify socket and points NOTIFY_SOCKET at it.
+func listen(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	c, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	t.Cleanup(func() { c.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return c
+}
+
+func receive(t *testing.T, c *net.UnixConn) string {
+	t.Helper()
+	buf := make([]byte, 4096)
+	c.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err := c.Read(buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	c := listen(t)
+
+	if !Enabled() {
+		t.Fatal("expected Enabled with NOTIFY_SOCKET set")
+	}
+	if err := Ready("Ready: 0 jobs"); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, c); got != "READY=1\nSTATUS=Ready: 0 jobs\n" {
+		t.Errorf("Ready sent %q", got)
+	}
+
+	if err := Watchdog(); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, c); got != "WATCHDOG=1\n" {
+		t.Errorf("Watchdog sent %q", got)
+	}
+
+	if err := Stopping(); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, c); got != "STOPPING=1\n" {
+		t.Errorf("Stopping sent %q", got)
+	}
+
+	if err := Reloading(); err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, c); !strings.HasPrefix(got, "RELOADING=1\n") {
+		t.Errorf("Reloading sent %q", got)
+	}
+}
+
+func TestNotifyWithoutSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if Enabled() {
+		t.Error("expected Enabled to be false")
+	}
+	if err := Ready("x"); err != nil {
+		t.Errorf("expected no-op, got %v", err)
+	}
+}
+
+func TestNotifyMissingSocket(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", filepath.Join(t.TempDir(), "missing.sock"))
+	if err := Watchdog(); err == nil {
+		t.Error("expected error for a missing socket")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	t.Setenv("WATCHDOG_USEC", "30000000")
+	t.Setenv("WATCHDOG_PID", "")
+	if got := WatchdogInterval(); got != 30*time.Second {
+		t.Errorf("WatchdogInterval = %v, want 30s", got)
+	}
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()))
+	if got := WatchdogInterval(); got != 30*time.Second {
+		t.Errorf("WatchdogInterval = %v for own PID, want 30s", got)
+	}
+
+	t.Setenv("WATCHDOG_PID", strconv.Itoa(os.Getpid()+1))
+	if got := WatchdogInterval(); got != 0 {
+		t.Errorf("WatchdogInterval = %v for another PID, want 0", got)
+	}
+
+	t.Setenv("WATCHDOG_USEC", "")
+	t.Setenv("WATCHDOG_PID", "")
+	if got := WatchdogInterval(); got != 0 {
+		t.Errorf("WatchdogInterval = %v when unset, want 0", got)
+	}
+}
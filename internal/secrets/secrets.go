@@ -0,0 +1,98 @@
+package secrets
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"syscall"
+)
+
+// DefaultDir is the default directory holding named secret files.
+const DefaultDir = "/etc/vito-root/secrets"
+
+// maxSecretSize is the maximum size of a single secret file (64KB).
+const maxSecretSize = 64 * 1024
+
+// validName restricts secret names to a single path component.
+var validName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9._-]*$`)
+
+// Store reads named secrets from root-only files in a directory.
+// Each secret lives in its own file named after the secret, e.g.
+// /etc/vito-root/secrets/mysql-root.
+type Store struct {
+	Dir string
+}
+
+// NewStore creates a Store reading from dir.
+func NewStore(dir string) *Store {
+	if dir == "" {
+		dir = DefaultDir
+	}
+	return &Store{Dir: dir}
+}
+
+// Load returns the value of the named secret. The file must be a regular
+// file owned by the daemon's user (root in production) and not accessible
+// by group or others. A single trailing newline is stripped from the value.
+func (s *Store) Load(name string) (string, error) {
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("invalid secret name %q", name)
+	}
+
+	path := filepath.Join(s.Dir, name)
+
+	// O_NOFOLLOW so a symlink planted in the directory is never followed,
+	// and O_NONBLOCK so a FIFO cannot block the open. The checks then run
+	// on the opened file itself, leaving no window to swap it.
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", fmt.Errorf("secret %q not found", name)
+		}
+		if errors.Is(err, syscall.ELOOP) {
+			return "", fmt.Errorf("secret %q is not a regular file", name)
+		}
+		return "", fmt.Errorf("opening secret %q: %w", name, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", fmt.Errorf("stat secret %q: %w", name, err)
+	}
+	if err := checkPermissions(name, info); err != nil {
+		return "", err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(f, maxSecretSize+1))
+	if err != nil {
+		return "", fmt.Errorf("reading secret %q: %w", name, err)
+	}
+	if len(data) > maxSecretSize {
+		return "", fmt.Errorf("secret %q exceeds %d bytes", name, maxSecretSize)
+	}
+
+	value := strings.TrimSuffix(string(data), "\n")
+	value = strings.TrimSuffix(value, "\r")
+	return value, nil
+}
+
+// checkPermissions rejects secret files that are not regular, not owned by
+// the daemon's effective user, or readable by anyone other than the owner.
+func checkPermissions(name string, info os.FileInfo) error {
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("secret %q is not a regular file", name)
+	}
+	if info.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("secret %q has insecure permissions %04o (must not be group/world accessible)",
+			name, info.Mode().Perm())
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok && int(stat.Uid) != os.Geteuid() {
+		return fmt.Errorf("secret %q must be owned by UID %d (owner UID %d)", name, os.Geteuid(), stat.Uid)
+	}
+	return nil
+}
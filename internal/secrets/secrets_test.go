@@ -0,0 +1,89 @@
+package secrets
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestStore_Load(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "mysql-root"), []byte("s3cret\n"), 0600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+
+	value, err := NewStore(dir).Load("mysql-root")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if value != "s3cret" {
+		t.Errorf("expected 's3cret', got %q", value)
+	}
+}
+
+func TestStore_Load_NotFound(t *testing.T) {
+	_, err := NewStore(t.TempDir()).Load("missing")
+	if err == nil {
+		t.Fatal("expected error for missing secret")
+	}
+	if !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected 'not found' error, got: %v", err)
+	}
+}
+
+func TestStore_Load_InsecurePermissions(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "token")
+	if err := os.WriteFile(path, []byte("value"), 0600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatalf("failed to chmod: %v", err)
+	}
+
+	_, err := NewStore(dir).Load("token")
+	if err == nil {
+		t.Fatal("expected error for world-readable secret")
+	}
+	if !strings.Contains(err.Error(), "insecure permissions") {
+		t.Errorf("expected permissions error, got: %v", err)
+	}
+}
+
+func TestStore_Load_Symlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "real")
+	if err := os.WriteFile(target, []byte("value"), 0600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+	if err := os.Symlink(target, filepath.Join(dir, "link")); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	if _, err := NewStore(dir).Load("link"); err == nil {
+		t.Fatal("expected error for symlinked secret")
+	}
+}
+
+func TestStore_Load_FIFO(t *testing.T) {
+	dir := t.TempDir()
+	if err := syscall.Mkfifo(filepath.Join(dir, "pipe"), 0600); err != nil {
+		t.Fatalf("failed to create FIFO: %v", err)
+	}
+
+	_, err := NewStore(dir).Load("pipe")
+	if err == nil || !strings.Contains(err.Error(), "not a regular file") {
+		t.Errorf("expected non-regular file error, got: %v", err)
+	}
+}
+
+func TestStore_Load_InvalidName(t *testing.T) {
+	store := NewStore(t.TempDir())
+	for _, name := range []string{"", "../etc/shadow", ".hidden", "a/b"} {
+		if _, err := store.Load(name); err == nil {
+			t.Errorf("expected error for secret name %q", name)
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"slices"
+	"time"
+
+	"vito-local/internal/audit"
+	"vito-local/internal/config"
+	"vito-local/internal/protocol"
+)
+
+// WithAudit sets the sink that receives one audit record per request.
+func WithAudit(sink audit.Sink) Option {
+	return func(s *Server) {
+		s.auditSink = sink
+	}
+}
+
+// WithHistory sets the store that answers "history" actions. It indexes
+// the audit log file, so it sees new records without being a sink.
+func WithHistory(h *audit.History) Option {
+	return func(s *Server) {
+		s.history = h
+	}
+}
+
+// newJobID returns a random identifier for a request, used to correlate
+// log lines and audit records.
+func newJobID() string {
+	var b [8]byte
+	_, _ = rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// newAuditRecord starts an audit record for a connection.
+func newAuditRecord(jobID string, creds *PeerCredentials) *audit.Record {
+	return &audit.Record{
+		JobID:   jobID,
+		PeerUID: creds.UID,
+		PeerPID: creds.PID,
+		PeerExe: creds.Exe,
+		Start:   time.Now().UTC(),
+	}
+}
+
+// rejectQueueSize bounds the audit records of rejected connections
+// waiting to be written. Each write is synced to disk, so a client
+// flooding connections would otherwise stall the accept loop for every
+// other peer; records beyond the queue are dropped and counted instead.
+const rejectQueueSize = 256
+
+// auditRejected records a connection refused before its request was
+// read. creds is nil if the peer could not be identified. The record is
+// queued for writeRejected rather than written on the accept path.
+func (s *Server) auditRejected(creds *PeerCredentials, def *config.Listener, reason string, logger *slog.Logger) {
+	if s.auditSink == nil {
+		return
+	}
+	rec := &audit.Record{JobID: newJobID(), Start: time.Now().UTC()}
+	if creds != nil {
+		rec = newAuditRecord(rec.JobID, creds)
+	}
+	if def != nil {
+		rec.Listener = def.Name
+	}
+	rec.Error = reason
+	rec.End = rec.Start
+	select {
+	case s.rejects <- rec:
+	default:
+		s.metrics.auditDropped.Inc()
+		logger.Warn("audit queue full, dropping record of rejected connection",
+			slog.String("job_id", rec.JobID),
+			slog.String("reason", reason),
+		)
+	}
+}
+
+// writeRejected writes queued records of rejected connections until stop
+// is closed, then writes those still queued and closes done.
+func (s *Server) writeRejected(stop <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	write := func(rec *audit.Record) {
+		if err := s.auditSink.Write(rec); err != nil {
+			s.logger.Error("failed to write audit record", slog.String("error", err.Error()))
+		}
+	}
+	for {
+		select {
+		case rec := <-s.rejects:
+			write(rec)
+		case <-stop:
+			for {
+				select {
+				case rec := <-s.rejects:
+					write(rec)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// requestEnvKeys returns the sorted names of all variables a request sets,
+// including secret injections. Values are never recorded.
+func requestEnvKeys(req *protocol.Request) []string {
+	keys := make([]string, 0, len(req.Env)+len(req.Secrets))
+	for k := range req.Env {
+		keys = append(keys, k)
+	}
+	for k := range req.Secrets {
+		if _, ok := req.Env[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// writeAudit completes the record and hands it to the audit sink. Failures
+// are logged but do not affect the client, which has already been answered.
+func (s *Server) writeAudit(rec *audit.Record, logger *slog.Logger) {
+	if s.auditSink == nil {
+		return
+	}
+	rec.End = time.Now().UTC()
+	if err := s.auditSink.Write(rec); err != nil {
+		logger.Error("failed to write audit record", slog.String("error", err.Error()))
+	}
+}
+
+// historyQuery converts a protocol query, capping the limit.
+func historyQuery(q *protocol.HistoryQuery) audit.Query {
+	query := audit.Query{Limit: audit.DefaultQueryLimit}
+	if q == nil {
+		return query
+	}
+	query = audit.Query{
+		Since:    q.Since,
+		Until:    q.Until,
+		ExitCode: q.ExitCode,
+		Failed:   q.Failed,
+		PeerUID:  q.PeerUID,
+		PeerPID:  q.PeerPID,
+		PeerExe:  q.PeerExe,
+		Command:  q.Command,
+		Limit:    q.Limit,
+	}
+	if query.Limit <= 0 {
+		query.Limit = audit.DefaultQueryLimit
+	}
+	query.Limit = min(query.Limit, audit.MaxQueryLimit)
+	return query
+}
+
+// historyEntries converts audit records for a history response, leaving
+// out the hash chain, which only matters to the log itself.
+func historyEntries(records []audit.Record) []protocol.HistoryEntry {
+	entries := make([]protocol.HistoryEntry, len(records))
+	for i, rec := range records {
+		entries[i] = protocol.HistoryEntry{
+			JobID:       rec.JobID,
+			PeerUID:     rec.PeerUID,
+			PeerPID:     rec.PeerPID,
+			PeerExe:     rec.PeerExe,
+			Listener:    rec.Listener,
+			Action:      rec.Action,
+			Command:     rec.Command,
+			Cwd:         rec.Cwd,
+			EnvKeys:     rec.EnvKeys,
+			Start:       rec.Start,
+			End:         rec.End,
+			ExitCode:    rec.ExitCode,
+			Error:       rec.Error,
+			StdoutBytes: rec.StdoutBytes,
+			StderrBytes: rec.StderrBytes,
+			Recording:   rec.Recording,
+		}
+	}
+	return entries
+}
@@ -3,6 +3,7 @@ package server
 import (
 	"fmt"
 	"net"
+	"slices"
 )
 
 // PeerCredentials holds the identity of the connecting process.
@@ -10,17 +11,21 @@ type PeerCredentials struct {
 	UID uint32
 	GID uint32
 	PID int32
+	// Exe is the peer's executable path, when it can be resolved. Unlike
+	// UID/GID/PID it is read from /proc after the fact and is informational.
+	Exe string
 }
 
-// AuthorizeConnection checks that the connecting peer's UID matches the allowed UID.
-func AuthorizeConnection(conn *net.UnixConn, allowedUID uint32) (*PeerCredentials, error) {
+// AuthorizeConnection checks that the connecting peer's UID is one of the
+// allowed UIDs.
+func AuthorizeConnection(conn *net.UnixConn, allowedUIDs ...uint32) (*PeerCredentials, error) {
 	creds, err := getPeerCredentials(conn)
 	if err != nil {
 		return nil, fmt.Errorf("getting peer credentials: %w", err)
 	}
 
-	if creds.UID != allowedUID {
-		return creds, fmt.Errorf("unauthorized: peer UID %d does not match allowed UID %d", creds.UID, allowedUID)
+	if !slices.Contains(allowedUIDs, creds.UID) {
+		return creds, fmt.Errorf("unauthorized: peer UID %d does not match allowed UIDs %v", creds.UID, allowedUIDs)
 	}
 
 	return creds, nil
@@ -5,6 +5,7 @@ package server
 import (
 	"fmt"
 	"net"
+	"os"
 	"syscall"
 )
 
@@ -31,5 +32,16 @@ func getPeerCredentials(conn *net.UnixConn) (*PeerCredentials, error) {
 		UID: cred.Uid,
 		GID: cred.Gid,
 		PID: cred.Pid,
+		Exe: peerExe(cred.Pid),
 	}, nil
 }
+
+// peerExe resolves the executable of a peer process. Returns "" if the
+// process has already exited or cannot be inspected.
+func peerExe(pid int32) string {
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err != nil {
+		return ""
+	}
+	return exe
+}
@@ -0,0 +1,143 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"vito-local/internal/config"
+	"vito-local/internal/protocol"
+	"vito-local/internal/secrets"
+)
+
+// baseEnv is the minimal environment every command starts from. The daemon's
+// own environment (including anything systemd passes) is never inherited.
+var baseEnv = []string{
+	"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin",
+	"HOME=/root",
+	"USER=root",
+	"LOGNAME=root",
+	"SHELL=/bin/bash",
+	"LANG=C.UTF-8",
+}
+
+// blockedEnvVars are environment variable names that clients may not set.
+// These are dangerous in a root-execution context (library injection,
+// shell startup hijacking, path manipulation).
+var blockedEnvVars = map[string]bool{
+	"PATH":            true,
+	"LD_PRELOAD":      true,
+	"LD_LIBRARY_PATH": true,
+	"LD_AUDIT":        true,
+	"LD_DEBUG":        true,
+	"LD_PROFILE":      true,
+	"BASH_ENV":        true,
+	"ENV":             true,
+	"SHELLOPTS":       true,
+	"BASHOPTS":        true,
+	"IFS":             true,
+	"CDPATH":          true,
+	"GLOBIGNORE":      true,
+	// Set by the server from Request.Traceparent.
+	"TRACEPARENT": true,
+}
+
+// blockedEnvPrefixes are environment variable name prefixes that clients may not set.
+var blockedEnvPrefixes = []string{
+	"LD_",
+	"BASH_FUNC_",
+}
+
+func isBlockedEnvVar(key string) bool {
+	upper := strings.ToUpper(key)
+	if blockedEnvVars[upper] {
+		return true
+	}
+	for _, prefix := range blockedEnvPrefixes {
+		if strings.HasPrefix(upper, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isAllowedEnvVar reports whether key matches an allowlist entry. Entries
+// match exactly, or as a prefix when they end in "*" (e.g. "COMPOSER_*").
+// An empty allowlist disables allowlist mode and permits every key.
+func isAllowedEnvVar(key string, allowlist []string) bool {
+	if len(allowlist) == 0 {
+		return true
+	}
+	for _, entry := range allowlist {
+		if prefix, ok := strings.CutSuffix(entry, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+			continue
+		}
+		if key == entry {
+			return true
+		}
+	}
+	return false
+}
+
+func isValidEnvKey(key string) bool {
+	return key != "" && !strings.Contains(key, "=") && !strings.ContainsRune(key, 0)
+}
+
+// buildEnv assembles the environment for a command: the clean base
+// environment, then client-supplied variables that pass the blocklist (and
+// the allowlist when configured), then secrets read from the secrets
+// directory. Invalid or disallowed client variables are dropped with a
+// warning; a secret that cannot be resolved fails the whole request so a
+// command never runs with a credential silently missing.
+//
+// The returned secret values (injected secrets and env vars listed in
+// Request.SecretEnv) must be redacted from anything sent or logged. They
+// are returned even on error so the failure can be logged safely.
+func buildEnv(req *protocol.Request, cfg *config.Config, logger *slog.Logger) (env, secretValues []string, err error) {
+	env = make([]string, len(baseEnv), len(baseEnv)+len(req.Env)+len(req.Secrets))
+	copy(env, baseEnv)
+
+	for _, k := range req.SecretEnv {
+		if v, ok := req.Env[k]; ok {
+			secretValues = append(secretValues, v)
+		}
+	}
+
+	for k, v := range req.Env {
+		if !isValidEnvKey(k) {
+			logger.Warn("rejected env var with invalid key", slog.String("key", k))
+			continue
+		}
+		if isBlockedEnvVar(k) {
+			logger.Warn("rejected blocked env var", slog.String("key", k))
+			continue
+		}
+		if !isAllowedEnvVar(k, cfg.EnvAllowlist) {
+			logger.Warn("rejected env var not in allowlist", slog.String("key", k))
+			continue
+		}
+		env = append(env, k+"="+v)
+	}
+
+	if len(req.Secrets) == 0 {
+		return env, secretValues, nil
+	}
+
+	store := secrets.NewStore(cfg.SecretsDir)
+	for k, name := range req.Secrets {
+		if !isValidEnvKey(k) || isBlockedEnvVar(k) {
+			return nil, secretValues, fmt.Errorf("secret %q cannot be injected as %q", name, k)
+		}
+		value, err := store.Load(name)
+		if err != nil {
+			return nil, secretValues, err
+		}
+		env = append(env, k+"="+value)
+		secretValues = append(secretValues, value)
+	}
+
+	return env, secretValues, nil
+}
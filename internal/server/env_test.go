@@ -0,0 +1,127 @@
+package server
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"vito-local/internal/config"
+	"vito-local/internal/protocol"
+)
+
+func TestIsAllowedEnvVar(t *testing.T) {
+	allowlist := []string{"DEBIAN_FRONTEND", "COMPOSER_*"}
+
+	tests := []struct {
+		key     string
+		allowed bool
+	}{
+		{"DEBIAN_FRONTEND", true},
+		{"COMPOSER_HOME", true},
+		{"COMPOSER_", true},
+		{"DEBIAN_FRONTEND_X", false},
+		{"HOME", false},
+	}
+
+	for _, tt := range tests {
+		if got := isAllowedEnvVar(tt.key, allowlist); got != tt.allowed {
+			t.Errorf("isAllowedEnvVar(%q) = %v, expected %v", tt.key, got, tt.allowed)
+		}
+	}
+
+	if !isAllowedEnvVar("ANYTHING", nil) {
+		t.Error("expected empty allowlist to permit every key")
+	}
+}
+
+func TestBuildEnv_CleanBase(t *testing.T) {
+	t.Setenv("VITO_TEST_LEAK", "leaked")
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	req := &protocol.Request{
+		Command: "true",
+		Env: map[string]string{
+			"DEBIAN_FRONTEND": "noninteractive",
+			"LD_PRELOAD":      "/tmp/evil.so",
+			"BAD=KEY":         "x",
+		},
+	}
+
+	env, _, err := buildEnv(req, &config.Config{}, logger)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !slices.Contains(env, "DEBIAN_FRONTEND=noninteractive") {
+		t.Error("expected DEBIAN_FRONTEND to be passed through")
+	}
+	for _, kv := range env {
+		if strings.HasPrefix(kv, "VITO_TEST_LEAK=") {
+			t.Error("daemon environment leaked into command environment")
+		}
+		if strings.HasPrefix(kv, "LD_PRELOAD=") || strings.HasPrefix(kv, "BAD=") {
+			t.Errorf("unexpected env entry %q", kv)
+		}
+	}
+	if !slices.Contains(env, baseEnv[0]) {
+		t.Errorf("expected base PATH entry %q", baseEnv[0])
+	}
+}
+
+func TestBuildEnv_Allowlist(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+
+	req := &protocol.Request{
+		Command: "true",
+		Env: map[string]string{
+			"DEBIAN_FRONTEND": "noninteractive",
+			"FOO":             "bar",
+		},
+	}
+	cfg := &config.Config{EnvAllowlist: []string{"DEBIAN_FRONTEND"}}
+
+	env, _, err := buildEnv(req, cfg, logger)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Contains(env, "DEBIAN_FRONTEND=noninteractive") {
+		t.Error("expected allowlisted var to be passed through")
+	}
+	if slices.Contains(env, "FOO=bar") {
+		t.Error("expected var outside allowlist to be dropped")
+	}
+}
+
+func TestBuildEnv_Secrets(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "mysql-root"), []byte("hunter2\n"), 0600); err != nil {
+		t.Fatalf("failed to write secret: %v", err)
+	}
+	cfg := &config.Config{SecretsDir: dir}
+
+	req := &protocol.Request{
+		Command: "true",
+		Secrets: map[string]string{"MYSQL_PWD": "mysql-root"},
+	}
+	env, _, err := buildEnv(req, cfg, logger)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !slices.Contains(env, "MYSQL_PWD=hunter2") {
+		t.Errorf("expected secret to be injected, got %v", env)
+	}
+
+	req.Secrets = map[string]string{"MYSQL_PWD": "missing"}
+	if _, _, err := buildEnv(req, cfg, logger); err == nil {
+		t.Error("expected error for missing secret")
+	}
+
+	req.Secrets = map[string]string{"LD_PRELOAD": "mysql-root"}
+	if _, _, err := buildEnv(req, cfg, logger); err == nil {
+		t.Error("expected error when injecting a secret into a blocked variable")
+	}
+}
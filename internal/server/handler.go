@@ -4,69 +4,90 @@ import (
 	"context"
 	"log/slog"
 	"net"
-	"os"
-	"strings"
 	"sync"
 	"time"
 
+	"vito-local/internal/channel"
 	"vito-local/internal/executor"
 	"vito-local/internal/protocol"
+	"vito-local/internal/tracing"
 	"vito-local/internal/updater"
 )
 
-// blockedEnvVars are environment variable names that clients may not set.
-// These are dangerous in a root-execution context (library injection,
-// shell startup hijacking, path manipulation).
-var blockedEnvVars = map[string]bool{
-	"PATH":            true,
-	"LD_PRELOAD":      true,
-	"LD_LIBRARY_PATH": true,
-	"LD_AUDIT":        true,
-	"LD_DEBUG":        true,
-	"LD_PROFILE":      true,
-	"BASH_ENV":        true,
-	"ENV":             true,
-	"SHELLOPTS":       true,
-	"BASHOPTS":        true,
-	"IFS":             true,
-	"CDPATH":          true,
-	"GLOBIGNORE":      true,
-}
-
-// blockedEnvPrefixes are environment variable name prefixes that clients may not set.
-var blockedEnvPrefixes = []string{
-	"LD_",
-	"BASH_FUNC_",
-}
-
-func isBlockedEnvVar(key string) bool {
-	upper := strings.ToUpper(key)
-	if blockedEnvVars[upper] {
-		return true
-	}
-	for _, prefix := range blockedEnvPrefixes {
-		if strings.HasPrefix(upper, prefix) {
-			return true
-		}
-	}
-	return false
-}
-
-func handleConnection(ctx context.Context, conn *net.UnixConn, creds *PeerCredentials, srv *Server, logger *slog.Logger, maxExecTimeout time.Duration) {
+// handleConnection serves one request under the settings in st, which were
+// current when the connection was accepted.
+func handleConnection(ctx context.Context, conn *net.UnixConn, creds *PeerCredentials, srv *Server, logger *slog.Logger, st *runtimeState) {
 	defer conn.Close()
 
+	jobID := newJobID()
 	connLog := logger.With(
+		slog.String("job_id", jobID),
 		slog.Int("peer_uid", int(creds.UID)),
 		slog.Int("peer_pid", int(creds.PID)),
 	)
 
+	// One audit record per request, written when the connection is done.
+	rec := newAuditRecord(jobID, creds)
+	if st.listener != nil {
+		rec.Listener = st.listener.Name
+	}
+	defer srv.writeAudit(rec, connLog)
+
+	// The request counts as queued until it starts executing or finishes.
+	srv.queuedJobs.Add(1)
+	queued := true
+	var queueSpan *tracing.Span
+	dequeue := func() {
+		if queued {
+			queued = false
+			srv.queuedJobs.Add(-1)
+			queueSpan.Finish()
+		}
+	}
+
+	parseStart := time.Now()
 	req, err := protocol.ParseRequest(conn)
+	var traceparent string
+	if req != nil {
+		traceparent = req.Traceparent
+	}
+	trace := srv.startRequestTrace(ctx, traceparent, parseStart, time.Now(), err, connLog)
+	defer trace.finish(rec)
+	queueSpan = trace.child("queue")
+	// Deferred after trace.finish so it runs first: a request rejected
+	// before it runs must end its queue span before the request span.
+	defer dequeue()
 	if err != nil {
 		connLog.Error("failed to parse request", slog.String("error", err.Error()))
-		writeErr := protocol.WriteResponse(conn, protocol.ErrorResponse(err.Error()))
-		if writeErr != nil {
-			connLog.Error("failed to write error response", slog.String("error", writeErr.Error()))
-		}
+		st.abuse.record(creds, "invalid_request", time.Now())
+		srv.metrics.rejected.Inc("invalid_request")
+		rec.Error = err.Error()
+		writeError(conn, connLog, err.Error())
+		return
+	}
+	rec.Action = req.Action
+	rec.Cwd = req.Cwd
+	rec.EnvKeys = requestEnvKeys(req)
+
+	if !st.allowRequest(req, creds) {
+		connLog.Warn("rate limit exceeded",
+			slog.String("peer_exe", creds.Exe),
+			slog.Bool("action", req.Action != ""),
+		)
+		st.abuse.record(creds, "rate_limited", time.Now())
+		srv.metrics.rejected.Inc("rate_limited")
+		rec.Error = "rate limit exceeded"
+		writeError(conn, connLog, rec.Error)
+		return
+	}
+
+	if err := st.permit(req); err != nil {
+		connLog.Warn("request forbidden", slog.String("error", err.Error()))
+		st.abuse.record(creds, "forbidden", time.Now())
+		srv.metrics.rejected.Inc("forbidden")
+		rec.Command = st.redactor.Redact(req.Command)
+		rec.Error = err.Error()
+		writeError(conn, connLog, rec.Error)
 		return
 	}
 
@@ -74,38 +95,68 @@ func handleConnection(ctx context.Context, conn *net.UnixConn, creds *PeerCreden
 	if req.Action != "" {
 		connLog = connLog.With(slog.String("action", req.Action))
 		connLog.Info("handling action")
+		srv.metrics.actions.Inc(req.Action)
+		dequeue()
+		span := trace.child("action " + req.Action)
 		handleAction(ctx, conn, req, srv, connLog)
+		span.Finish()
 		return
 	}
 
+	// Build environment: clean base env + filtered request env + secrets
+	env, secretValues, err := buildEnv(req, st.cfg, connLog)
+	redactor := st.redactor.WithValues(secretValues...)
+	rec.Command = redactor.Redact(req.Command)
+
 	connLog = connLog.With(
-		slog.String("command", req.Command),
+		slog.String("command", rec.Command),
 		slog.String("cwd", req.Cwd),
 	)
-	connLog.Info("executing command")
+	if err != nil {
+		// Usually a missing or unreadable secret file, which is the
+		// operator's to fix rather than abuse by the client.
+		connLog.Error("failed to build environment", slog.String("error", err.Error()))
+		rec.Error = err.Error()
+		writeError(conn, connLog, err.Error())
+		return
+	}
 
-	// Merge environment: parent env + request env (with blocklist filtering)
-	env := os.Environ()
-	for k, v := range req.Env {
-		if strings.Contains(k, "=") || strings.ContainsRune(k, 0) {
-			connLog.Warn("rejected env var with invalid key", slog.String("key", k))
-			continue
-		}
-		if isBlockedEnvVar(k) {
-			connLog.Warn("rejected blocked env var", slog.String("key", k))
-			continue
-		}
-		env = append(env, k+"="+v)
+	sandbox, err := resolveSandbox(st.cfg.Policy, req.Sandbox)
+	if err != nil {
+		connLog.Warn("sandbox rejected", slog.String("error", err.Error()))
+		st.abuse.record(creds, "forbidden", time.Now())
+		rec.Error = "sandbox: " + err.Error()
+		writeError(conn, connLog, rec.Error)
+		return
+	}
+	if sandbox != nil {
+		connLog = connLog.With(
+			slog.Any("sandbox_read", sandbox.ReadPaths),
+			slog.Any("sandbox_write", sandbox.WritePaths),
+		)
 	}
 
+	capabilities, err := resolveCapabilities(st.cfg.Policy, req.Capabilities)
+	if err != nil {
+		connLog.Warn("capabilities rejected", slog.String("error", err.Error()))
+		st.abuse.record(creds, "forbidden", time.Now())
+		rec.Error = "capabilities: " + err.Error()
+		writeError(conn, connLog, rec.Error)
+		return
+	}
+	if capabilities != nil {
+		connLog = connLog.With(slog.Any("capabilities", capabilities))
+	}
+	connLog.Info("executing command")
+
 	// Context that we cancel on write errors to kill orphaned processes
 	execCtx, execCancel := context.WithCancel(ctx)
 	defer execCancel()
 
 	// Apply per-command timeout if configured
-	if maxExecTimeout > 0 {
+	if st.cfg.MaxExecTimeout > 0 {
 		var timeoutCancel context.CancelFunc
-		execCtx, timeoutCancel = context.WithTimeout(execCtx, maxExecTimeout)
+		execCtx, timeoutCancel = context.WithTimeout(execCtx, st.cfg.MaxExecTimeout)
 		defer timeoutCancel()
 	}
 
@@ -120,28 +171,89 @@ func handleConnection(ctx context.Context, conn *net.UnixConn, creds *PeerCreden
 		}
 	}
 
+	// Record the redacted output, as the client sees it, when enabled.
+	record := func(string) {}
+	if srv.recorder != nil {
+		session, err := srv.recorder.Start(rec.JobID, rec.Command, rec.Start)
+		if err != nil {
+			connLog.Error("failed to start recording", slog.String("error", err.Error()))
+		} else {
+			rec.Recording = session.Path()
+			record = session.Output
+			defer func() {
+				if err := session.Close(); err != nil {
+					connLog.Error("failed to finish recording", slog.String("error", err.Error()))
+				}
+			}()
+		}
+	}
+
+	// Redact output before it reaches the client. Each pipe gets its own
+	// stream so a secret split across two reads is still caught.
+	stdout := redactor.NewStream(func(data string) {
+		record(data)
+		writeResponse(protocol.StdoutResponse(data))
+	})
+	stderr := redactor.NewStream(func(data string) {
+		record(data)
+		writeResponse(protocol.StderrResponse(data))
+	})
+
 	cmdExec := &executor.Executor{
 		Cwd: req.Cwd,
 		Env: env,
 		OnStdout: func(data string) {
-			writeResponse(protocol.StdoutResponse(data))
+			rec.StdoutBytes += int64(len(data))
+			srv.metrics.outputBytes.Add(float64(len(data)), "stdout")
+			stdout.Write(data)
 		},
 		OnStderr: func(data string) {
-			writeResponse(protocol.StderrResponse(data))
+			rec.StderrBytes += int64(len(data))
+			srv.metrics.outputBytes.Add(float64(len(data)), "stderr")
+			stderr.Write(data)
 		},
+		Sandbox:      sandbox,
+		Capabilities: capabilities,
 	}
 
+	dequeue()
+	execSpan := trace.child("execute")
+	if tp := trace.traceparent(execSpan); tp != "" {
+		cmdExec.Env = append(cmdExec.Env, "TRACEPARENT="+tp)
+	}
+	srv.activeJobs.Add(1)
 	exitCode, err := cmdExec.Run(execCtx, req.Command)
+	srv.activeJobs.Add(-1)
+	if err != nil {
+		execSpan.SetError(redactor.Redact(err.Error()))
+	} else {
+		execSpan.SetAttr("vito.exit_code", exitCode)
+	}
+	execSpan.Finish()
+	srv.metrics.observeCommand(exitCode, err, time.Since(rec.Start))
+	stdout.Flush()
+	stderr.Flush()
 	if err != nil {
-		connLog.Error("command execution failed", slog.String("error", err.Error()))
-		writeResponse(protocol.ErrorResponse(err.Error()))
+		msg := redactor.Redact(err.Error())
+		connLog.Error("command execution failed", slog.String("error", msg))
+		rec.Error = msg
+		writeResponse(protocol.ErrorResponse(msg))
 		return
 	}
 
+	rec.ExitCode = &exitCode
+
 	writeResponse(protocol.ExitResponse(exitCode))
 	connLog.Info("command completed", slog.Int("exit_code", exitCode))
 }
 
+// writeError sends a terminal error response, logging if the write fails.
+func writeError(conn *net.UnixConn, logger *slog.Logger, msg string) {
+	if err := protocol.WriteResponse(conn, protocol.ErrorResponse(msg)); err != nil {
+		logger.Error("failed to write error response", slog.String("error", err.Error()))
+	}
+}
+
 // handleAction dispatches action requests to the appropriate handler.
 func handleAction(ctx context.Context, conn *net.UnixConn, req *protocol.Request, srv *Server, logger *slog.Logger) {
 	writeResponse := func(resp protocol.Response) {
@@ -157,6 +269,12 @@ func handleAction(ctx context.Context, conn *net.UnixConn, req *protocol.Request
 		handleCheckUpdate(srv, writeResponse, logger)
 	case "update":
 		handleUpdate(ctx, srv, writeResponse, logger)
+	case "rollback":
+		handleRollback(ctx, srv, writeResponse, logger)
+	case "history":
+		handleHistory(req, srv, writeResponse, logger)
+	case "health":
+		handleHealth(srv, writeResponse, logger)
 	default:
 		writeResponse(protocol.ErrorResponse("unknown action: " + req.Action))
 	}
@@ -168,6 +286,45 @@ func handleVersion(srv *Server, writeResponse func(protocol.Response), logger *s
 	writeResponse(protocol.VersionResponse(srv.Version()))
 }
 
+// handleHealth returns the self-diagnostic report.
+func handleHealth(srv *Server, writeResponse func(protocol.Response), logger *slog.Logger) {
+	h := srv.health()
+	if h.Status != protocol.HealthOK {
+		for _, c := range h.Checks {
+			if !c.OK {
+				logger.Warn("health check failed", slog.String("check", c.Name), slog.String("message", c.Message))
+			}
+		}
+	}
+	writeResponse(protocol.HealthResponse(h))
+}
+
+// handleHistory returns past executions matching the request's filters.
+func handleHistory(req *protocol.Request, srv *Server, writeResponse func(protocol.Response), logger *slog.Logger) {
+	if srv.history == nil {
+		writeResponse(protocol.ErrorResponse("history not available: audit log is disabled"))
+		return
+	}
+
+	records, more, err := srv.history.Query(historyQuery(req.History))
+	if err != nil {
+		logger.Error("history query failed", slog.String("error", err.Error()))
+		writeResponse(protocol.ErrorResponse("history query failed"))
+		return
+	}
+	logger.Info("returning history", slog.Int("entries", len(records)), slog.Bool("truncated", more))
+	writeResponse(protocol.HistoryResponse(historyEntries(records), more))
+}
+
+// newUpdater returns an updater for the running binary that follows the
+// configured update channel.
+func newUpdater(srv *Server) *updater.Updater {
+	u := updater.New(srv.Version(), srv.BinaryPath())
+	// The channel was validated when the configuration was loaded.
+	u.Channel, _ = channel.Parse(srv.state().cfg.UpdateChannel)
+	return u
+}
+
 // handleCheckUpdate checks if an update is available without performing it.
 func handleCheckUpdate(srv *Server, writeResponse func(protocol.Response), logger *slog.Logger) {
 	if srv.BinaryPath() == "" {
@@ -179,11 +336,12 @@ func handleCheckUpdate(srv *Server, writeResponse func(protocol.Response), logge
 		return
 	}
 
-	u := updater.New(srv.Version(), srv.BinaryPath())
+	u := newUpdater(srv)
 	result, err := u.CheckUpdate()
 	if err != nil {
 		logger.Error("check update failed", slog.String("error", err.Error()))
 	}
+	srv.metrics.updateChecks.Inc(result.Status)
 
 	writeResponse(protocol.UpdateResponse(
 		protocol.UpdateStatus(result.Status),
@@ -207,7 +365,7 @@ func handleUpdate(ctx context.Context, srv *Server, writeResponse func(protocol.
 		return
 	}
 
-	u := updater.New(srv.Version(), srv.BinaryPath())
+	u := newUpdater(srv)
 
 	// Progress callback to send status updates
 	onProgress := func(status, message string) {
@@ -221,11 +379,14 @@ func handleUpdate(ctx context.Context, srv *Server, writeResponse func(protocol.
 
 	result, err := u.PerformUpdate(ctx, onProgress)
 	if err != nil {
+		srv.metrics.updateChecks.Inc("failed")
 		logger.Error("update failed", slog.String("error", err.Error()))
 		// Error response already sent via onProgress
 		return
 	}
 
+	srv.metrics.updateChecks.Inc(result.Status)
+
 	// If we're already current, just return
 	if result.Status == "current" {
 		logger.Info("already running latest version")
@@ -236,6 +397,7 @@ func handleUpdate(ctx context.Context, srv *Server, writeResponse func(protocol.
 	logger.Info("update applied, scheduling restart",
 		slog.String("from_version", result.CurrentVersion),
 		slog.String("to_version", result.LatestVersion),
+		slog.String("channel", u.Channel.String()),
 	)
 
 	writeResponse(protocol.UpdateResponse(
@@ -251,3 +413,46 @@ func handleUpdate(ctx context.Context, srv *Server, writeResponse func(protocol.
 	// Signal restart
 	srv.RequestRestart()
 }
+
+// handleRollback reinstates the binary kept by the last update and
+// schedules a restart, like handleUpdate.
+func handleRollback(ctx context.Context, srv *Server, writeResponse func(protocol.Response), logger *slog.Logger) {
+	if srv.BinaryPath() == "" {
+		writeResponse(protocol.UpdateResponse(
+			protocol.UpdateStatusFailed,
+			srv.Version(), "",
+			"rollback not supported: binary path not configured",
+		))
+		return
+	}
+
+	u := updater.New(srv.Version(), srv.BinaryPath())
+	result, err := u.Rollback(ctx)
+	writeResponse(protocol.UpdateResponse(
+		protocol.UpdateStatus(result.Status),
+		result.CurrentVersion,
+		result.LatestVersion,
+		result.Message,
+	))
+	if err != nil {
+		logger.Error("rollback failed", slog.String("error", err.Error()))
+		return
+	}
+
+	logger.Info("rolled back, scheduling restart",
+		slog.String("from_version", result.CurrentVersion),
+		slog.String("to_version", result.LatestVersion),
+	)
+
+	writeResponse(protocol.UpdateResponse(
+		protocol.UpdateStatusRestarting,
+		result.CurrentVersion,
+		result.LatestVersion,
+		"service will restart momentarily",
+	))
+
+	// Give time for the response to be sent
+	time.Sleep(restartDelay)
+
+	srv.RequestRestart()
+}
@@ -2,16 +2,24 @@ package server
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
+	"io"
 	"log/slog"
 	"net"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
+	"sync"
 	"testing"
 
+	"vito-local/internal/audit"
 	"vito-local/internal/config"
 	"vito-local/internal/protocol"
+	"vito-local/internal/recording"
+	"vito-local/internal/updater"
 )
 
 func setupTestSocket(t *testing.T) (server *net.UnixConn, client *net.UnixConn, cleanup func()) {
@@ -74,7 +82,7 @@ func TestHandleConnection_Echo(t *testing.T) {
 	// Handle connection on server side
 	done := make(chan struct{})
 	go func() {
-		handleConnection(context.Background(), serverConn, creds, srv, logger, 0)
+		handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
 		close(done)
 	}()
 
@@ -138,7 +146,7 @@ func TestHandleConnection_InvalidJSON(t *testing.T) {
 
 	done := make(chan struct{})
 	go func() {
-		handleConnection(context.Background(), serverConn, creds, srv, logger, 0)
+		handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
 		close(done)
 	}()
 
@@ -172,7 +180,7 @@ func TestHandleConnection_EmptyRequest(t *testing.T) {
 
 	done := make(chan struct{})
 	go func() {
-		handleConnection(context.Background(), serverConn, creds, srv, logger, 0)
+		handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
 		close(done)
 	}()
 
@@ -208,7 +216,7 @@ func TestHandleConnection_Stderr(t *testing.T) {
 
 	done := make(chan struct{})
 	go func() {
-		handleConnection(context.Background(), serverConn, creds, srv, logger, 0)
+		handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
 		close(done)
 	}()
 
@@ -257,7 +265,7 @@ func TestHandleConnection_VersionAction(t *testing.T) {
 
 	done := make(chan struct{})
 	go func() {
-		handleConnection(context.Background(), serverConn, creds, srv, logger, 0)
+		handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
 		close(done)
 	}()
 
@@ -296,7 +304,7 @@ func TestHandleConnection_CheckUpdateAction_NoBinaryPath(t *testing.T) {
 
 	done := make(chan struct{})
 	go func() {
-		handleConnection(context.Background(), serverConn, creds, srv, logger, 0)
+		handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
 		close(done)
 	}()
 
@@ -320,6 +328,62 @@ func TestHandleConnection_CheckUpdateAction_NoBinaryPath(t *testing.T) {
 	<-done
 }
 
+func TestHandleConnection_RollbackAction(t *testing.T) {
+	serverConn, clientConn, cleanup := setupTestSocket(t)
+	defer cleanup()
+
+	binPath := filepath.Join(t.TempDir(), "vito-root-service")
+	for path, v := range map[string]string{binPath: "v1.1.0", updater.PrevPath(binPath): "v1.0.0"} {
+		script := "#!/bin/sh\necho vito-root-service " + v + "\n#" + strings.Repeat(" ", 100*1024) + "\n"
+		if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	creds := &PeerCredentials{UID: uint32(os.Getuid()), PID: int32(os.Getpid())}
+	cfg := &config.Config{MaxConnections: 10}
+	srv := New(cfg, logger, WithVersion("v1.1.0"), WithBinaryPath(binPath))
+
+	data, _ := json.Marshal(protocol.Request{Action: "rollback"})
+	clientConn.Write(append(data, '\n'))
+
+	done := make(chan struct{})
+	go func() {
+		handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
+		close(done)
+	}()
+
+	var statuses []protocol.UpdateStatus
+	scanner := bufio.NewScanner(clientConn)
+	for scanner.Scan() {
+		var resp protocol.Response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if resp.Type != protocol.TypeUpdate {
+			t.Fatalf("expected update response, got %+v", resp)
+		}
+		if resp.CurrentVersion != "v1.1.0" || resp.LatestVersion != "v1.0.0" {
+			t.Errorf("versions = %q -> %q, want v1.1.0 -> v1.0.0", resp.CurrentVersion, resp.LatestVersion)
+		}
+		statuses = append(statuses, resp.UpdateStatus)
+		if resp.UpdateStatus == protocol.UpdateStatusRestarting {
+			break
+		}
+	}
+	if len(statuses) != 2 || statuses[0] != protocol.UpdateStatusApplied {
+		t.Errorf("statuses = %v, want applied then restarting", statuses)
+	}
+
+	<-done
+	select {
+	case <-srv.RestartChan():
+	default:
+		t.Error("rollback should request a restart")
+	}
+}
+
 func TestHandleConnection_UnknownAction(t *testing.T) {
 	serverConn, clientConn, cleanup := setupTestSocket(t)
 	defer cleanup()
@@ -335,7 +399,7 @@ func TestHandleConnection_UnknownAction(t *testing.T) {
 
 	done := make(chan struct{})
 	go func() {
-		handleConnection(context.Background(), serverConn, creds, srv, logger, 0)
+		handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
 		close(done)
 	}()
 
@@ -355,3 +419,331 @@ func TestHandleConnection_UnknownAction(t *testing.T) {
 
 	<-done
 }
+
+func TestHandleConnection_RedactsSecretEnv(t *testing.T) {
+	serverConn, clientConn, cleanup := setupTestSocket(t)
+	defer cleanup()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	creds := &PeerCredentials{UID: uint32(os.Getuid()), PID: int32(os.Getpid())}
+	srv := testServer(t, logger)
+
+	req := protocol.Request{
+		Command:   `echo "pw=$APP_SECRET"; echo "$APP_SECRET" >&2`,
+		Env:       map[string]string{"APP_SECRET": "correct-horse"},
+		SecretEnv: []string{"APP_SECRET"},
+	}
+	data, _ := json.Marshal(req)
+	data = append(data, '\n')
+	clientConn.Write(data)
+
+	done := make(chan struct{})
+	go func() {
+		handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
+		close(done)
+	}()
+
+	scanner := bufio.NewScanner(clientConn)
+	var output string
+	for scanner.Scan() {
+		var resp protocol.Response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		output += resp.Data
+		if resp.Type == protocol.TypeExit || resp.Type == protocol.TypeError {
+			break
+		}
+	}
+
+	<-done
+
+	if strings.Contains(output, "correct-horse") {
+		t.Errorf("secret value leaked in output: %q", output)
+	}
+	if !strings.Contains(output, "pw=[REDACTED]") {
+		t.Errorf("expected redacted placeholder in output, got %q", output)
+	}
+}
+
+func TestHandleConnection_MissingSecretIsNotAbuse(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	creds := &PeerCredentials{UID: uint32(os.Getuid()), PID: int32(os.Getpid())}
+	cfg := &config.Config{MaxConnections: 10, AbuseThreshold: 1, SecretsDir: t.TempDir()}
+	srv := New(cfg, logger)
+
+	serverConn, clientConn, cleanup := setupTestSocket(t)
+	defer cleanup()
+	clientConn.Write([]byte(`{"command":"true","secrets":{"DB_PASS":"missing"}}` + "\n"))
+
+	done := make(chan struct{})
+	go func() {
+		handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
+		close(done)
+	}()
+	io.Copy(io.Discard, clientConn)
+	<-done
+
+	if !strings.Contains(buf.String(), "failed to build environment") {
+		t.Fatalf("expected the secret to fail, got log %q", buf.String())
+	}
+	if strings.Contains(buf.String(), "suspicious") {
+		t.Errorf("a missing secret should not count as abuse, got log %q", buf.String())
+	}
+}
+
+func TestHandleConnection_RateLimited(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	creds := &PeerCredentials{UID: uint32(os.Getuid()), PID: int32(os.Getpid())}
+	cfg := &config.Config{MaxConnections: 10, ActionRateLimit: 1, ActionBurst: 1}
+	srv := New(cfg, logger, WithVersion("test-version"))
+
+	send := func() protocol.Response {
+		serverConn, clientConn, cleanup := setupTestSocket(t)
+		defer cleanup()
+
+		clientConn.Write([]byte(`{"action":"version"}` + "\n"))
+
+		done := make(chan struct{})
+		go func() {
+			handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
+			close(done)
+		}()
+
+		var resp protocol.Response
+		scanner := bufio.NewScanner(clientConn)
+		if scanner.Scan() {
+			if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+				t.Fatalf("failed to unmarshal response: %v", err)
+			}
+		}
+		<-done
+		return resp
+	}
+
+	if resp := send(); resp.Type != protocol.TypeVersion {
+		t.Fatalf("expected first action to succeed, got %q", resp.Type)
+	}
+	resp := send()
+	if resp.Type != protocol.TypeError || !strings.Contains(resp.Message, "rate limit") {
+		t.Errorf("expected rate limit error, got %+v", resp)
+	}
+}
+
+type memorySink struct {
+	mu      sync.Mutex
+	records []*audit.Record
+}
+
+func (m *memorySink) Write(rec *audit.Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records = append(m.records, rec)
+	return nil
+}
+
+func (m *memorySink) all() []*audit.Record {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.records)
+}
+
+func TestHandleConnection_WritesAuditRecord(t *testing.T) {
+	serverConn, clientConn, cleanup := setupTestSocket(t)
+	defer cleanup()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	creds := &PeerCredentials{UID: uint32(os.Getuid()), PID: int32(os.Getpid()), Exe: "/usr/bin/php"}
+	sink := &memorySink{}
+	cfg := &config.Config{MaxConnections: 10}
+	srv := New(cfg, logger, WithVersion("test-version"), WithAudit(sink))
+
+	req := `{"command":"echo hi; exit 3","env":{"DB_PASS":"hunter22"},"secret_env":["DB_PASS"]}` + "\n"
+	clientConn.Write([]byte(req))
+
+	done := make(chan struct{})
+	go func() {
+		handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
+		close(done)
+	}()
+	io.Copy(io.Discard, clientConn)
+	<-done
+
+	if len(sink.records) != 1 {
+		t.Fatalf("expected 1 audit record, got %d", len(sink.records))
+	}
+	rec := sink.records[0]
+	if rec.JobID == "" {
+		t.Error("expected job ID")
+	}
+	if rec.PeerExe != "/usr/bin/php" || rec.PeerUID != creds.UID {
+		t.Errorf("unexpected peer fields: %+v", rec)
+	}
+	if rec.Command != "echo hi; exit 3" {
+		t.Errorf("unexpected command %q", rec.Command)
+	}
+	if rec.ExitCode == nil || *rec.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got %v", rec.ExitCode)
+	}
+	if rec.StdoutBytes != 3 {
+		t.Errorf("expected 3 stdout bytes, got %d", rec.StdoutBytes)
+	}
+	if len(rec.EnvKeys) != 1 || rec.EnvKeys[0] != "DB_PASS" {
+		t.Errorf("expected env keys [DB_PASS], got %v", rec.EnvKeys)
+	}
+	if rec.End.Before(rec.Start) {
+		t.Error("expected end after start")
+	}
+}
+
+func TestHandleConnection_HistoryAction(t *testing.T) {
+	serverConn, clientConn, cleanup := setupTestSocket(t)
+	defer cleanup()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	creds := &PeerCredentials{UID: uint32(os.Getuid()), PID: int32(os.Getpid())}
+	// Written directly: audit.Open would mark the file append-only, which
+	// stops the temporary directory from being removed.
+	one, two := 0, 1
+	var log []byte
+	for _, rec := range []audit.Record{
+		{Seq: 1, Command: "nginx -t", ExitCode: &one, Hash: "h1"},
+		{Seq: 2, Command: "nginx -s reload", ExitCode: &two, Hash: "h2"},
+		{Seq: 3, Command: "php -v", ExitCode: &one, Hash: "h3"},
+	} {
+		line, _ := json.Marshal(rec)
+		log = append(append(log, line...), '\n')
+	}
+	logPath := filepath.Join(t.TempDir(), "audit.log")
+	if err := os.WriteFile(logPath, log, 0600); err != nil {
+		t.Fatal(err)
+	}
+	history := audit.NewHistory(logPath)
+
+	cfg := &config.Config{MaxConnections: 10}
+	srv := New(cfg, logger, WithVersion("test-version"), WithHistory(history))
+
+	clientConn.Write([]byte(`{"action":"history","history":{"command":"nginx","limit":1}}` + "\n"))
+
+	done := make(chan struct{})
+	go func() {
+		handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
+		close(done)
+	}()
+
+	var resp protocol.Response
+	var line []byte
+	scanner := bufio.NewScanner(clientConn)
+	if scanner.Scan() {
+		line = scanner.Bytes()
+		if err := json.Unmarshal(line, &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+	}
+	<-done
+
+	if resp.Type != protocol.TypeHistory {
+		t.Fatalf("expected history response, got %+v", resp)
+	}
+	if len(resp.Entries) != 1 || resp.Entries[0].Command != "nginx -s reload" || !resp.Truncated {
+		t.Errorf("expected entry 2 with more matches, got %+v", resp)
+	}
+	if strings.Contains(string(line), `"hash"`) || strings.Contains(string(line), `"seq"`) {
+		t.Errorf("expected the hash chain to stay out of the response, got %s", line)
+	}
+}
+
+func TestHandleConnection_RecordsOutput(t *testing.T) {
+	serverConn, clientConn, cleanup := setupTestSocket(t)
+	defer cleanup()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	creds := &PeerCredentials{UID: uint32(os.Getuid()), PID: int32(os.Getpid())}
+	recorder, err := recording.New(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatalf("failed to create recorder: %v", err)
+	}
+	sink := &memorySink{}
+	cfg := &config.Config{MaxConnections: 10}
+	srv := New(cfg, logger, WithVersion("test-version"), WithAudit(sink), WithRecorder(recorder))
+
+	req := `{"command":"echo out; echo \"pw=$DB_PASS\" >&2","env":{"DB_PASS":"hunter22"},"secret_env":["DB_PASS"]}` + "\n"
+	clientConn.Write([]byte(req))
+
+	done := make(chan struct{})
+	go func() {
+		handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
+		close(done)
+	}()
+	io.Copy(io.Discard, clientConn)
+	<-done
+
+	if len(sink.records) != 1 || sink.records[0].Recording == "" {
+		t.Fatalf("expected audit record linking a recording, got %+v", sink.records)
+	}
+	data, err := os.ReadFile(sink.records[0].Recording)
+	if err != nil {
+		t.Fatalf("failed to read recording: %v", err)
+	}
+	cast := string(data)
+	if !strings.Contains(cast, `"out\r\n"`) {
+		t.Errorf("expected stdout in recording, got:\n%s", cast)
+	}
+	if strings.Contains(cast, "hunter22") || !strings.Contains(cast, "[REDACTED]") {
+		t.Errorf("expected secret to be redacted in recording, got:\n%s", cast)
+	}
+}
+
+func TestHandleConnection_Metrics(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	creds := &PeerCredentials{UID: uint32(os.Getuid()), PID: int32(os.Getpid())}
+	srv := testServer(t, logger)
+
+	run := func(req string) {
+		serverConn, clientConn, cleanup := setupTestSocket(t)
+		defer cleanup()
+		clientConn.Write([]byte(req + "\n"))
+		done := make(chan struct{})
+		go func() {
+			handleConnection(context.Background(), serverConn, creds, srv, logger, srv.state())
+			close(done)
+		}()
+		io.Copy(io.Discard, clientConn)
+		<-done
+	}
+
+	run(`{"command":"printf abc"}`)
+	run(`{"command":"exit 2"}`)
+	run(`{"action":"version"}`)
+	run(`not json`)
+
+	m := srv.metrics
+	if m.commands.Value("0") != 1 || m.commands.Value("2") != 1 {
+		t.Errorf("unexpected command counts: 0=%v 2=%v", m.commands.Value("0"), m.commands.Value("2"))
+	}
+	if m.commandDuration.Count() != 2 {
+		t.Errorf("expected 2 duration observations, got %d", m.commandDuration.Count())
+	}
+	if m.outputBytes.Value("stdout") != 3 {
+		t.Errorf("expected 3 stdout bytes, got %v", m.outputBytes.Value("stdout"))
+	}
+	if m.actions.Value("version") != 1 {
+		t.Errorf("expected 1 version action, got %v", m.actions.Value("version"))
+	}
+	if m.rejected.Value("invalid_request") != 1 {
+		t.Errorf("expected 1 invalid request, got %v", m.rejected.Value("invalid_request"))
+	}
+
+	var buf strings.Builder
+	srv.Metrics().WriteTo(&buf)
+	for _, want := range []string{
+		`vito_root_build_info{version="test-version"} 1`,
+		"vito_root_connections_max 10",
+		`vito_root_commands_total{exit_code="2"} 1`,
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in exposition:\n%s", want, buf.String())
+		}
+	}
+}
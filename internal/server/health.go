@@ -0,0 +1,289 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"vito-local/internal/config"
+	"vito-local/internal/protocol"
+)
+
+// minFreeBytes is the free space below which a disk check fails.
+const minFreeBytes = 100 << 20
+
+// health builds the self-diagnostic report.
+func (s *Server) health() *protocol.Health {
+	now := time.Now()
+	h := &protocol.Health{
+		Version:        s.version,
+		StartedAt:      s.started.UTC(),
+		UptimeSeconds:  now.Sub(s.started).Seconds(),
+		Connections:    int(s.conns.Load()),
+		MaxConnections: s.state().maxConnections(),
+		ActiveJobs:     int(s.activeJobs.Load()),
+		QueuedJobs:     int(s.queuedJobs.Load()),
+		Socket: protocol.SocketInfo{
+			Path:             listenerPath(s.listener, s.cfg.SocketPath),
+			SystemdActivated: s.systemdSocket,
+		},
+	}
+
+	h.Checks = append(h.Checks, s.checkSocket(&h.Socket))
+	for _, el := range s.extra {
+		h.Checks = append(h.Checks, checkListenerSocket(el.def))
+	}
+	h.Checks = append(h.Checks, checkShell())
+	h.Checks = append(h.Checks, s.checkAllowedUser())
+
+	for _, dir := range s.stateDirs() {
+		free, total, err := diskUsage(dir)
+		if err != nil {
+			h.Checks = append(h.Checks, protocol.HealthCheck{Name: "disk:" + dir, Message: err.Error()})
+			continue
+		}
+		h.Disks = append(h.Disks, protocol.DiskInfo{Path: dir, FreeBytes: free, TotalBytes: total})
+		check := protocol.HealthCheck{Name: "disk:" + dir, OK: free >= minFreeBytes}
+		if !check.OK {
+			check.Message = fmt.Sprintf("only %d MiB free", free>>20)
+		}
+		h.Checks = append(h.Checks, check)
+	}
+
+	h.Status = protocol.HealthOK
+	for _, c := range h.Checks {
+		if !c.OK {
+			h.Status = protocol.HealthDegraded
+			break
+		}
+	}
+	return h
+}
+
+// Alive checks that the server can still take connections: the accept
+// loop is running and the socket files being served exist. Unlike the
+// health report it ignores conditions a restart would not fix, such as
+// low disk space.
+func (s *Server) Alive() error {
+	if int(s.accepting.Load()) < 1+len(s.extra) {
+		return errors.New("accept loop is not running")
+	}
+	if err := statSocket(listenerPath(s.listener, s.cfg.SocketPath)); err != nil {
+		return fmt.Errorf("socket: %w", err)
+	}
+	for _, el := range s.extra {
+		if err := statSocket(listenerPath(el.listener, el.def.SocketPath)); err != nil {
+			return fmt.Errorf("listener %q socket: %w", el.def.Name, err)
+		}
+	}
+	return nil
+}
+
+// listenerPath returns the address l is bound to. Under socket activation
+// it can differ from the configured path, which is only checked with a
+// warning, so probes must use the address actually being served.
+func listenerPath(l *net.UnixListener, configured string) string {
+	if l != nil {
+		if addr, ok := l.Addr().(*net.UnixAddr); ok && addr.Name != "" {
+			return addr.Name
+		}
+	}
+	return configured
+}
+
+// statSocket checks that a socket file exists. Abstract sockets, named
+// with a leading "@", have no file.
+func statSocket(path string) error {
+	if strings.HasPrefix(path, "@") {
+		return nil
+	}
+	_, err := os.Stat(path)
+	return err
+}
+
+// pingTimeout bounds a Ping round trip when the caller sets no deadline.
+const pingTimeout = 5 * time.Second
+
+// Ping sends a health request to the main socket and waits for the
+// report. Unlike Alive, it fails when connections are accepted but
+// requests are no longer read and dispatched. Probes are answered without
+// an audit record or a rate limit charge.
+func (s *Server) Ping(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
+
+	// The server recognizes the probe by this client address together
+	// with the peer PID, which only this process has.
+	local := &net.UnixAddr{Name: "@vito-root-ping-" + newJobID(), Net: "unix"}
+	s.pings.Store(local.Name, struct{}{})
+	defer s.pings.Delete(local.Name)
+
+	path := listenerPath(s.listener, s.cfg.SocketPath)
+	conn, err := net.DialUnix("unix", local, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return fmt.Errorf("connecting to %s: %w", path, err)
+	}
+	defer conn.Close()
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	req, _ := json.Marshal(protocol.Request{Action: "health"})
+	if _, err := conn.Write(append(req, '\n')); err != nil {
+		return fmt.Errorf("sending health request: %w", err)
+	}
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		return fmt.Errorf("reading health response: %w", err)
+	}
+	var resp protocol.Response
+	if err := json.Unmarshal(line, &resp); err != nil {
+		return fmt.Errorf("parsing health response: %w", err)
+	}
+	if resp.Type != protocol.TypeHealth {
+		return fmt.Errorf("unexpected %s response to health request: %s", resp.Type, resp.Message)
+	}
+	return nil
+}
+
+// isPing reports whether conn is a probe from Ping in this process.
+func (s *Server) isPing(conn *net.UnixConn) bool {
+	addr, ok := conn.RemoteAddr().(*net.UnixAddr)
+	if !ok || addr == nil {
+		return false
+	}
+	if _, ok := s.pings.Load(addr.Name); !ok {
+		return false
+	}
+	creds, err := getPeerCredentials(conn)
+	return err == nil && creds.PID == int32(os.Getpid())
+}
+
+// answerPing serves a probe from Ping through the action dispatcher.
+func (s *Server) answerPing(ctx context.Context, conn *net.UnixConn, logger *slog.Logger) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(pingTimeout))
+	req, err := protocol.ParseRequest(conn)
+	if err != nil || req.Action != "health" {
+		writeError(conn, logger, "liveness probes may only request health")
+		return
+	}
+	handleAction(ctx, conn, req, s, logger)
+}
+
+// StatusLine summarizes current load for the service manager.
+func (s *Server) StatusLine() string {
+	return fmt.Sprintf("%d active jobs, %d queued, %d/%d connections",
+		s.activeJobs.Load(), s.queuedJobs.Load(), s.conns.Load(), s.state().maxConnections())
+}
+
+// stateDirs returns the temp directory and every directory the service
+// writes state to.
+func (s *Server) stateDirs() []string {
+	dirs := []string{os.TempDir()}
+	for _, path := range []string{s.cfg.AuditLog, s.cfg.AuditSyslogSpool} {
+		if path != "" {
+			dirs = append(dirs, filepath.Dir(path))
+		}
+	}
+	if s.cfg.RecordDir != "" {
+		dirs = append(dirs, s.cfg.RecordDir)
+	}
+	slices.Sort(dirs)
+	return slices.Compact(dirs)
+}
+
+// checkSocket fills in the socket's mode and ownership and checks that
+// they still match the configuration, whether the service or systemd
+// created the socket.
+func (s *Server) checkSocket(info *protocol.SocketInfo) protocol.HealthCheck {
+	check := protocol.HealthCheck{Name: "socket"}
+	fi, err := os.Stat(s.cfg.SocketPath)
+	if err != nil {
+		check.Message = err.Error()
+		return check
+	}
+	mode := fi.Mode().Perm()
+	info.Mode = fmt.Sprintf("%04o", mode)
+	if uid, gid, ok := fileOwner(fi); ok {
+		info.Owner = lookupUserName(uid)
+		info.Group = lookupGroupName(gid)
+	}
+	if err := verifySocket(mainSocket(s.cfg)); err != nil {
+		check.Message = err.Error()
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+// checkListenerSocket checks that a listener's socket still has the
+// configured mode and ownership.
+func checkListenerSocket(def *config.Listener) protocol.HealthCheck {
+	check := protocol.HealthCheck{Name: "socket:" + def.Name}
+	if err := verifySocket(listenerSocket(def)); err != nil {
+		check.Message = err.Error()
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+// checkShell verifies that the shell commands run under is executable.
+func checkShell() protocol.HealthCheck {
+	check := protocol.HealthCheck{Name: "shell"}
+	fi, err := os.Stat("/bin/bash")
+	switch {
+	case err != nil:
+		check.Message = err.Error()
+	case !fi.Mode().IsRegular() || fi.Mode().Perm()&0111 == 0:
+		check.Message = "/bin/bash is not an executable file"
+	default:
+		check.OK = true
+	}
+	return check
+}
+
+// checkAllowedUser verifies that the allowed user still resolves to the
+// UID the service authorizes.
+func (s *Server) checkAllowedUser() protocol.HealthCheck {
+	check := protocol.HealthCheck{Name: "allowed_user"}
+	cfg := s.state().cfg
+	u, err := user.Lookup(cfg.AllowedUser)
+	if err != nil {
+		check.Message = err.Error()
+		return check
+	}
+	if u.Uid != strconv.FormatUint(uint64(cfg.AllowedUID), 10) {
+		check.Message = fmt.Sprintf("user %q now has UID %s, service authorizes UID %d", cfg.AllowedUser, u.Uid, cfg.AllowedUID)
+		return check
+	}
+	check.OK = true
+	return check
+}
+
+func lookupUserName(uid uint32) string {
+	id := strconv.FormatUint(uint64(uid), 10)
+	if u, err := user.LookupId(id); err == nil {
+		return u.Username
+	}
+	return id
+}
+
+func lookupGroupName(gid uint32) string {
+	id := strconv.FormatUint(uint64(gid), 10)
+	if g, err := user.LookupGroupId(id); err == nil {
+		return g.Name
+	}
+	return id
+}
@@ -0,0 +1,16 @@
+//go:build !unix
+
+package server
+
+import (
+	"errors"
+	"io/fs"
+)
+
+func diskUsage(string) (free, total uint64, err error) {
+	return 0, 0, errors.New("disk usage is not available on this platform")
+}
+
+func fileOwner(fs.FileInfo) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
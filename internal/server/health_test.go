@@ -0,0 +1,208 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"vito-local/internal/config"
+	"vito-local/internal/protocol"
+)
+
+func healthServer(t *testing.T, mode os.FileMode) *Server {
+	t.Helper()
+	u, err := user.Current()
+	if err != nil {
+		t.Fatalf("failed to get current user: %v", err)
+	}
+	uid, _ := strconv.ParseUint(u.Uid, 10, 32)
+
+	path := tempSocketPath(t)
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatalf("failed to chmod socket: %v", err)
+	}
+
+	cfg := &config.Config{
+		SocketPath:     path,
+		SocketMode:     0660,
+		AllowedUser:    u.Username,
+		AllowedUID:     uint32(uid),
+		MaxConnections: 10,
+		AuditLog:       t.TempDir() + "/audit.log",
+	}
+	return New(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), WithVersion("test-version"))
+}
+
+func findCheck(h *protocol.Health, name string) *protocol.HealthCheck {
+	for i := range h.Checks {
+		if h.Checks[i].Name == name {
+			return &h.Checks[i]
+		}
+	}
+	return nil
+}
+
+func TestHealth_OK(t *testing.T) {
+	srv := healthServer(t, 0660)
+	srv.activeJobs.Add(2)
+	srv.queuedJobs.Add(1)
+
+	h := srv.health()
+	if h.Version != "test-version" || h.MaxConnections != 10 {
+		t.Errorf("unexpected report %+v", h)
+	}
+	if h.ActiveJobs != 2 || h.QueuedJobs != 1 {
+		t.Errorf("expected 2 active and 1 queued job, got %d and %d", h.ActiveJobs, h.QueuedJobs)
+	}
+	if h.Socket.Mode != "0660" || h.Socket.Owner == "" {
+		t.Errorf("unexpected socket info %+v", h.Socket)
+	}
+	if len(h.Disks) == 0 {
+		t.Error("expected disk usage")
+	}
+	for _, name := range []string{"socket", "shell", "allowed_user"} {
+		c := findCheck(h, name)
+		if c == nil || !c.OK {
+			t.Errorf("expected %s check to pass, got %+v", name, c)
+		}
+	}
+}
+
+func TestHealth_Degraded(t *testing.T) {
+	srv := healthServer(t, 0666)
+	srv.cfg.AllowedUID++
+
+	h := srv.health()
+	if h.Status != protocol.HealthDegraded {
+		t.Errorf("expected degraded status, got %q", h.Status)
+	}
+	if c := findCheck(h, "socket"); c == nil || c.OK {
+		t.Errorf("expected socket mode check to fail, got %+v", c)
+	}
+	if c := findCheck(h, "allowed_user"); c == nil || c.OK {
+		t.Errorf("expected allowed user check to fail, got %+v", c)
+	}
+}
+
+func TestHealth_SystemdSocketOwnership(t *testing.T) {
+	srv := healthServer(t, 0660)
+	srv.systemdSocket = true
+	if c := findCheck(srv.health(), "socket"); c == nil || !c.OK {
+		t.Errorf("expected socket check to pass, got %+v", c)
+	}
+
+	fi, err := os.Stat(srv.cfg.SocketPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, gid, ok := fileOwner(fi)
+	if !ok {
+		t.Skip("file ownership not available")
+	}
+	srv.cfg.SocketGroup = "vito-sock"
+	srv.cfg.SocketGroupGID = gid + 1
+	if c := findCheck(srv.health(), "socket"); c == nil || c.OK || !strings.Contains(c.Message, "expected vito-sock") {
+		t.Errorf("expected socket group check to fail under socket activation, got %+v", c)
+	}
+}
+
+func TestAlive(t *testing.T) {
+	srv := healthServer(t, 0660)
+	if err := srv.Alive(); err == nil {
+		t.Error("expected an error before the accept loop runs")
+	}
+
+	srv.accepting.Store(1)
+	if err := srv.Alive(); err != nil {
+		t.Errorf("expected alive, got %v", err)
+	}
+
+	os.Remove(srv.cfg.SocketPath)
+	if err := srv.Alive(); err == nil {
+		t.Error("expected an error once the socket file is gone")
+	}
+}
+
+func TestPing(t *testing.T) {
+	cfg := testConfig(t, tempSocketPath(t))
+	// Probes come from the service's own UID, which clients do not share.
+	cfg.AllowedUID++
+	sink := &memorySink{}
+	srv := New(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)), WithAudit(sink))
+
+	ctx := context.Background()
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	if err := srv.Ping(ctx); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+	if records := sink.all(); len(records) != 0 {
+		t.Errorf("probes should not be audited, got %+v", records)
+	}
+
+	// An ordinary connection from the same process is still authorized
+	// by UID.
+	responses := sendRequest(t, cfg.SocketPath, protocol.Request{Action: "health"})
+	if len(responses) != 1 || responses[0].Type != protocol.TypeError {
+		t.Errorf("got %+v, want the connection rejected", responses)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+	if err := srv.Ping(ctx); err == nil {
+		t.Error("Ping should fail once the server is shut down")
+	}
+}
+
+func TestPing_UsesBoundSocket(t *testing.T) {
+	cfg := testConfig(t, tempSocketPath(t))
+	srv := New(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctx := context.Background()
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	bound := cfg.SocketPath
+	t.Cleanup(func() {
+		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+		os.Remove(bound)
+	})
+
+	// A socket-activated service serves the socket systemd passed even
+	// when the configured path names another.
+	cfg.SocketPath = tempSocketPath(t)
+	if err := srv.Alive(); err != nil {
+		t.Errorf("Alive: %v", err)
+	}
+	if err := srv.Ping(ctx); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+	if got := srv.health().Socket.Path; got != bound {
+		t.Errorf("expected socket path %s, got %s", bound, got)
+	}
+}
+
+func TestStatusLine(t *testing.T) {
+	srv := healthServer(t, 0660)
+	srv.activeJobs.Add(2)
+	srv.queuedJobs.Add(1)
+	if got, want := srv.StatusLine(), "2 active jobs, 1 queued, 0/10 connections"; got != want {
+		t.Errorf("StatusLine = %q, want %q", got, want)
+	}
+}
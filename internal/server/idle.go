@@ -0,0 +1,55 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// IdleChan returns the channel that signals the idle timeout was reached.
+// It only fires under socket activation, where systemd restarts the
+// service on the next connection.
+func (s *Server) IdleChan() <-chan struct{} {
+	return s.idleChan
+}
+
+// touch records connection activity for the idle timer.
+func (s *Server) touch() {
+	s.lastActive.Store(time.Now().UnixNano())
+}
+
+// idleSince returns when the server last became idle, or false while
+// connections are open or jobs are running.
+func (s *Server) idleSince() (time.Time, bool) {
+	if s.conns.Load() > 0 || s.activeJobs.Load() > 0 || s.queuedJobs.Load() > 0 {
+		return time.Time{}, false
+	}
+	return time.Unix(0, s.lastActive.Load()), true
+}
+
+// watchIdle signals IdleChan once the server has been idle for timeout.
+// The check runs a few times per timeout, so the actual idle period is at
+// most a quarter longer.
+func (s *Server) watchIdle(ctx context.Context, timeout time.Duration) {
+	ticker := time.NewTicker(max(timeout/4, 100*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			since, idle := s.idleSince()
+			if !idle || now.Sub(since) < timeout {
+				continue
+			}
+			s.logger.Info("idle timeout reached",
+				slog.Duration("idle_timeout", timeout),
+				slog.Time("idle_since", since))
+			select {
+			case s.idleChan <- struct{}{}:
+			default:
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"vito-local/internal/config"
+)
+
+func TestWatchIdle(t *testing.T) {
+	srv := New(&config.Config{MaxConnections: 10}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srv.touch()
+	srv.activeJobs.Add(1)
+	go srv.watchIdle(ctx, 200*time.Millisecond)
+
+	select {
+	case <-srv.IdleChan():
+		t.Fatal("idle timeout fired while a job was running")
+	case <-time.After(500 * time.Millisecond):
+	}
+
+	srv.activeJobs.Add(-1)
+	srv.touch()
+	start := time.Now()
+	select {
+	case <-srv.IdleChan():
+		if elapsed := time.Since(start); elapsed < 200*time.Millisecond {
+			t.Errorf("idle timeout fired after %v, want at least 200ms", elapsed)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle timeout did not fire")
+	}
+}
+
+func TestIdleSince(t *testing.T) {
+	srv := New(&config.Config{MaxConnections: 10}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	srv.touch()
+	if _, idle := srv.idleSince(); !idle {
+		t.Error("expected idle with no connections or jobs")
+	}
+
+	srv.conns.Add(1)
+	if _, idle := srv.idleSince(); idle {
+		t.Error("expected busy with an open connection")
+	}
+	srv.conns.Add(-1)
+
+	srv.queuedJobs.Add(1)
+	if _, idle := srv.idleSince(); idle {
+		t.Error("expected busy with a queued job")
+	}
+}
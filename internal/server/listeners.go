@@ -0,0 +1,273 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"vito-local/internal/config"
+	"vito-local/internal/protocol"
+	"vito-local/internal/umask"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd, after
+// stdin, stdout and stderr.
+const listenFDsStart = 3
+
+// extraListener is a socket configured as a [listener.<name>] table.
+type extraListener struct {
+	def      *config.Listener
+	listener *net.UnixListener
+	// systemd is true when the socket was passed by socket activation.
+	systemd bool
+	// conns counts the listener's open connections.
+	conns atomic.Int64
+}
+
+// passedListener is a socket passed by systemd, with its
+// FileDescriptorName=.
+type passedListener struct {
+	name     string
+	listener *net.UnixListener
+}
+
+// systemdListeners returns the sockets passed by systemd socket
+// activation, in order. It returns nil when the process was not
+// socket-activated.
+func systemdListeners() ([]passedListener, error) {
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, nil
+	}
+	// LISTEN_PID names the process the sockets are meant for; an inherited
+	// environment from a socket-activated parent must be ignored.
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	var names []string
+	if v := os.Getenv("LISTEN_FDNAMES"); v != "" {
+		names = strings.Split(v, ":")
+	}
+
+	passed := make([]passedListener, 0, n)
+	for i := 0; i < n; i++ {
+		fd := listenFDsStart + i
+		p := passedListener{}
+		if i < len(names) {
+			p.name = names[i]
+		}
+		p.listener, err = fileListener(fd)
+		if err != nil {
+			closePassed(passed)
+			return nil, err
+		}
+		passed = append(passed, p)
+	}
+	return passed, nil
+}
+
+// fileListener wraps a Unix socket file descriptor passed by systemd.
+func fileListener(fd int) (*net.UnixListener, error) {
+	f := os.NewFile(uintptr(fd), "systemd-socket")
+	if f == nil {
+		return nil, fmt.Errorf("failed to create file from fd %d", fd)
+	}
+	defer func() { _ = f.Close() }()
+
+	l, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("creating listener from systemd fd %d: %w", fd, err)
+	}
+	ul, ok := l.(*net.UnixListener)
+	if !ok {
+		_ = l.Close()
+		return nil, fmt.Errorf("systemd fd %d is not a Unix socket", fd)
+	}
+	return ul, nil
+}
+
+func closePassed(passed []passedListener) {
+	for _, p := range passed {
+		_ = p.listener.Close()
+	}
+}
+
+// createListeners opens the main socket and every configured listener.
+// Sockets passed by systemd are matched to listeners by
+// FileDescriptorName=; a single socket with any other name is the main
+// socket. Sockets not passed by systemd are created here.
+func (s *Server) createListeners() error {
+	passed, err := systemdListeners()
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]*net.UnixListener)
+	var unmatched []passedListener
+	for _, p := range passed {
+		if s.cfg.Listener(p.name) != nil && byName[p.name] == nil {
+			byName[p.name] = p.listener
+		} else {
+			unmatched = append(unmatched, p)
+		}
+	}
+	if len(unmatched) > 1 {
+		closePassed(passed)
+		var names []string
+		for _, p := range unmatched {
+			names = append(names, strconv.Quote(p.name))
+		}
+		return fmt.Errorf("systemd passed %d sockets that match no listener (names %s); set FileDescriptorName= to a listener name",
+			len(unmatched), strings.Join(names, ", "))
+	}
+
+	if len(unmatched) == 1 {
+		s.listener = unmatched[0].listener
+		s.systemdSocket = true
+		s.logger.Info("using systemd socket activation")
+	} else if s.listener, err = listenUnix(s.cfg.SocketPath, s.cfg.SocketMode); err != nil {
+		closePassed(passed)
+		return err
+	}
+
+	for i := range s.cfg.Listeners {
+		def := &s.cfg.Listeners[i]
+		el := &extraListener{def: def, listener: byName[def.Name], systemd: byName[def.Name] != nil}
+		if !el.systemd {
+			if el.listener, err = listenUnix(def.SocketPath, def.SocketMode); err != nil {
+				s.closeListeners()
+				for _, l := range byName {
+					_ = l.Close()
+				}
+				return fmt.Errorf("listener %q: %w", def.Name, err)
+			}
+		}
+		delete(byName, def.Name)
+		s.extra = append(s.extra, el)
+	}
+	return nil
+}
+
+// listenUnix creates a socket at path with the given mode, replacing a
+// stale socket file. The mode comes from the umask in effect while the
+// socket is bound rather than a later chmod, which would follow a symlink
+// put in the socket's place.
+func listenUnix(path string, mode uint32) (*net.UnixListener, error) {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("removing stale socket: %w", err)
+	}
+	var l *net.UnixListener
+	err := umask.With(int(^mode&0777), func() (err error) {
+		l, err = net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("listening on %s: %w", path, err)
+	}
+	return l, nil
+}
+
+// closeListeners stops accepting on every socket.
+func (s *Server) closeListeners() {
+	if s.listener != nil {
+		_ = s.listener.Close()
+	}
+	for _, el := range s.extra {
+		_ = el.listener.Close()
+	}
+}
+
+// allSystemd reports whether systemd passed every socket, so that it can
+// start the service again on the next connection to any of them.
+func (s *Server) allSystemd() bool {
+	if !s.systemdSocket {
+		return false
+	}
+	for _, el := range s.extra {
+		if !el.systemd {
+			return false
+		}
+	}
+	return true
+}
+
+// prepareSocket applies the configured ownership and mode to a socket the
+// service created, or checks that a socket systemd created agrees.
+func (s *Server) prepareSocket(spec socketSpec, systemd bool, logger *slog.Logger) error {
+	if !systemd {
+		return s.setSocketPermissions(spec)
+	}
+	if err := verifySocket(spec); err != nil {
+		logger.Warn("systemd socket does not match the configuration; align the socket unit with socket_owner, socket_group and socket_mode",
+			slog.String("error", err.Error()))
+	}
+	return nil
+}
+
+// listenerSocket returns the expected ownership and mode of a listener's
+// socket.
+func listenerSocket(def *config.Listener) socketSpec {
+	return socketSpec{
+		path:     def.SocketPath,
+		owner:    def.SocketOwner,
+		ownerUID: def.SocketOwnerUID,
+		group:    def.SocketGroup,
+		groupGID: def.SocketGroupGID,
+		mode:     def.SocketMode,
+	}
+}
+
+// forListener returns the state for connections accepted on a listener:
+// the same abuse counters, with the listener's own rate limit budgets,
+// connection limit and policy. The role,
+// commands and policy come from the listener of the same name in the
+// running configuration, so a reload that changes them, or the top-level
+// policy they inherit, applies to the listener too.
+func (st *runtimeState) forListener(def *config.Listener) *runtimeState {
+	if reloaded := st.cfg.Listener(def.Name); reloaded != nil {
+		def = reloaded
+	}
+	cfg := *st.cfg
+	cfg.Policy = def.Policy
+	next := *st
+	next.cfg = &cfg
+	next.listener = def
+	if l, ok := st.listenerLimiters[def.Name]; ok {
+		next.cmdLimiter, next.actionLimiter = l.cmd, l.action
+	}
+	return &next
+}
+
+// restrictedActions are the actions a restricted listener accepts.
+var restrictedActions = map[string]bool{
+	"version": true,
+}
+
+// permit checks the request against the role of the listener it arrived
+// on. The main socket and admin listeners accept every request.
+func (st *runtimeState) permit(req *protocol.Request) error {
+	def := st.listener
+	if def == nil || def.Role != config.RoleRestricted {
+		return nil
+	}
+	switch {
+	case req.Action != "":
+		if !restrictedActions[req.Action] {
+			return fmt.Errorf("action %q is not allowed on listener %q", req.Action, def.Name)
+		}
+	case len(req.Env) > 0:
+		return fmt.Errorf("environment variables are not allowed on listener %q", def.Name)
+	case len(req.Secrets) > 0:
+		return fmt.Errorf("secrets are not allowed on listener %q", def.Name)
+	case req.Cwd != "":
+		// A relative path in an allowed command would resolve against a
+		// directory the client controls.
+		return fmt.Errorf("a working directory is not allowed on listener %q", def.Name)
+	case !def.AllowsCommand(req.Command):
+		return fmt.Errorf("command is not allowed on listener %q", def.Name)
+	}
+	return nil
+}
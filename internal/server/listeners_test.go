@@ -0,0 +1,308 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"vito-local/internal/config"
+	"vito-local/internal/protocol"
+)
+
+// sendRequest sends req to the socket at path and returns every response.
+func sendRequest(t *testing.T, path string, req protocol.Request) []protocol.Response {
+	t.Helper()
+	conn, err := net.DialUnix("unix", nil, &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer conn.Close()
+
+	data, _ := json.Marshal(req)
+	conn.Write(append(data, '\n'))
+
+	var responses []protocol.Response
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		var resp protocol.Response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
+		responses = append(responses, resp)
+		if resp.Type == protocol.TypeExit || resp.Type == protocol.TypeError {
+			break
+		}
+	}
+	return responses
+}
+
+func TestServer_RestrictedListener(t *testing.T) {
+	cfg := testConfig(t, tempSocketPath(t))
+	sitePath := tempSocketPath(t)
+	cfg.Listeners = []config.Listener{{
+		Name:           "site",
+		SocketPath:     sitePath,
+		Users:          []string{cfg.AllowedUser},
+		UIDs:           []uint32{cfg.AllowedUID},
+		Role:           config.RoleRestricted,
+		Commands:       []string{"echo reloaded *"},
+		SocketOwner:    cfg.SocketOwner,
+		SocketOwnerUID: cfg.SocketOwnerUID,
+		SocketGroup:    cfg.SocketGroup,
+		SocketGroupGID: cfg.SocketGroupGID,
+		SocketMode:     0600,
+	}}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	srv := New(cfg, logger)
+
+	ctx := context.Background()
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	if err := verifySocket(listenerSocket(&cfg.Listeners[0])); err != nil {
+		t.Errorf("listener socket does not match the configuration: %v", err)
+	}
+	if err := srv.Alive(); err != nil {
+		t.Errorf("Alive: %v", err)
+	}
+	if c := findCheck(srv.health(), "socket:site"); c == nil || !c.OK {
+		t.Errorf("expected listener socket check to pass, got %+v", c)
+	}
+
+	last := func(responses []protocol.Response) protocol.Response {
+		if len(responses) == 0 {
+			t.Fatal("no responses")
+		}
+		return responses[len(responses)-1]
+	}
+
+	if got := last(sendRequest(t, sitePath, protocol.Request{Command: "echo reloaded example.com"})); got.Type != protocol.TypeExit {
+		t.Errorf("allowed command: got %+v, want exit", got)
+	}
+	if got := last(sendRequest(t, sitePath, protocol.Request{Action: "version"})); got.Type == protocol.TypeError {
+		t.Errorf("version action: got error %q", got.Message)
+	}
+	forbidden := []protocol.Request{
+		{Command: "id"},
+		{Command: "echo reloaded example.com; id"},
+		{Command: "echo reloaded example.com", Env: map[string]string{"FOO": "bar"}},
+		{Action: "update"},
+		{Action: "health"},
+		{Command: "echo reloaded example.com", Cwd: "/tmp"},
+	}
+	for _, req := range forbidden {
+		if got := last(sendRequest(t, sitePath, req)); got.Type != protocol.TypeError || !strings.Contains(got.Message, `not allowed on listener "site"`) {
+			t.Errorf("request %+v: got %+v, want forbidden", req, got)
+		}
+	}
+	if got := last(sendRequest(t, cfg.SocketPath, protocol.Request{Command: "id"})); got.Type != protocol.TypeExit {
+		t.Errorf("main socket: got %+v, want exit", got)
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	srv.Shutdown(shutdownCtx)
+	if _, err := os.Stat(sitePath); !os.IsNotExist(err) {
+		t.Error("listener socket file should be removed after shutdown")
+	}
+}
+
+func TestServer_ListenerRejectsOtherUsers(t *testing.T) {
+	cfg := testConfig(t, tempSocketPath(t))
+	sitePath := tempSocketPath(t)
+	cfg.Listeners = []config.Listener{{
+		Name:       "site",
+		SocketPath: sitePath,
+		Users:      []string{"someone-else"},
+		UIDs:       []uint32{cfg.AllowedUID + 1},
+		Role:       config.RoleAdmin,
+		SocketMode: 0660,
+	}}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	sink := &memorySink{}
+	srv := New(cfg, logger, WithAudit(sink))
+
+	ctx := context.Background()
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	responses := sendRequest(t, sitePath, protocol.Request{Command: "true"})
+	if len(responses) != 1 || responses[0].Type != protocol.TypeError || !strings.Contains(responses[0].Message, "unauthorized") {
+		t.Errorf("got %+v, want the connection rejected", responses)
+	}
+	records := sink.all()
+	if len(records) != 1 || records[0].Listener != "site" || !strings.Contains(records[0].Error, "unauthorized") {
+		t.Errorf("audit records = %+v, want the rejection recorded", records)
+	}
+}
+
+func TestServer_ListenerConnectionLimit(t *testing.T) {
+	cfg := testConfig(t, tempSocketPath(t))
+	sitePath := tempSocketPath(t)
+	cfg.Listeners = []config.Listener{{
+		Name:           "site",
+		SocketPath:     sitePath,
+		UIDs:           []uint32{cfg.AllowedUID},
+		Role:           config.RoleAdmin,
+		SocketMode:     0600,
+		MaxConnections: 1,
+	}}
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	srv := New(cfg, logger)
+
+	ctx := context.Background()
+	if err := srv.Start(ctx); err != nil {
+		t.Fatalf("failed to start server: %v", err)
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdownCtx)
+	}()
+
+	// Hold the listener's only slot with a connection that sends nothing.
+	held, err := net.Dial("unix", sitePath)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer held.Close()
+	for deadline := time.Now().Add(2 * time.Second); srv.extra[0].conns.Load() != 1; {
+		if time.Now().After(deadline) {
+			t.Fatal("held connection was not accepted")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if got := sendRequest(t, sitePath, protocol.Request{Command: "true"}); len(got) != 1 || !strings.Contains(got[0].Message, "maximum capacity") {
+		t.Errorf("second listener connection: got %+v, want it rejected at capacity", got)
+	}
+	if got := sendRequest(t, cfg.SocketPath, protocol.Request{Command: "true"}); len(got) == 0 || got[len(got)-1].Type != protocol.TypeExit {
+		t.Errorf("main socket: got %+v, want its own connection budget", got)
+	}
+}
+
+func TestSetSocketPermissions_Symlink(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "target")
+	if err := os.WriteFile(target, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "site.sock")
+	if err := os.Symlink(target, path); err != nil {
+		t.Fatal(err)
+	}
+
+	srv := New(testConfig(t, tempSocketPath(t)), slog.New(slog.NewTextHandler(io.Discard, nil)))
+	err := srv.setSocketPermissions(socketSpec{path: path, ownerUID: 12345, groupGID: 12345, mode: 0666})
+	if err == nil || !strings.Contains(err.Error(), "replaced") {
+		t.Errorf("setSocketPermissions = %v, want the replaced socket reported", err)
+	}
+	fi, err := os.Stat(target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0600 {
+		t.Errorf("target mode = %04o, want it untouched", fi.Mode().Perm())
+	}
+	if uid, _, ok := fileOwner(fi); ok && uid == 12345 {
+		t.Error("target owner changed through the symlink")
+	}
+}
+
+func TestListenUnix_Mode(t *testing.T) {
+	path := tempSocketPath(t)
+	l, err := listenUnix(path, 0640)
+	if err != nil {
+		t.Fatalf("listenUnix: %v", err)
+	}
+	defer l.Close()
+	fi, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0640 {
+		t.Errorf("mode = %04o, want 0640 from the moment the socket exists", fi.Mode().Perm())
+	}
+}
+
+func TestRuntimeState_Permit(t *testing.T) {
+	st := &runtimeState{cfg: &config.Config{}}
+	if err := st.permit(&protocol.Request{Action: "update"}); err != nil {
+		t.Errorf("main socket should permit everything, got %v", err)
+	}
+
+	admin := st.forListener(&config.Listener{Name: "ops", Role: config.RoleAdmin})
+	if err := admin.permit(&protocol.Request{Command: "rm -rf /tmp/x", Secrets: map[string]string{"A": "b"}}); err != nil {
+		t.Errorf("admin listener should permit everything, got %v", err)
+	}
+
+	restricted := st.forListener(&config.Listener{
+		Name:     "site",
+		Role:     config.RoleRestricted,
+		Commands: []string{"systemctl reload php*-fpm"},
+		Policy:   config.Policy{Capabilities: []string{"CAP_KILL"}},
+	})
+	if restricted.cfg.Policy.Capabilities[0] != "CAP_KILL" || st.cfg.Policy.Capabilities != nil {
+		t.Error("forListener should apply the listener's policy to a copy of the configuration")
+	}
+	tests := []struct {
+		req  protocol.Request
+		want string
+	}{
+		{protocol.Request{Command: "systemctl reload php8.3-fpm"}, ""},
+		{protocol.Request{Action: "version"}, ""},
+		{protocol.Request{Action: "health"}, `action "health"`},
+		{protocol.Request{Command: "systemctl reload php8.3-fpm", Cwd: "/tmp"}, "working directory"},
+		{protocol.Request{Action: "history"}, `action "history"`},
+		{protocol.Request{Command: "systemctl stop php8.3-fpm"}, "command is not allowed"},
+		{protocol.Request{Command: "systemctl reload php8.3-fpm", Secrets: map[string]string{"A": "b"}}, "secrets"},
+	}
+	for _, tt := range tests {
+		err := restricted.permit(&tt.req)
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("permit(%+v) = %v, want nil", tt.req, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("permit(%+v) = %v, want error containing %q", tt.req, err, tt.want)
+		}
+	}
+}
+
+func TestRuntimeState_ForListenerAfterReload(t *testing.T) {
+	started := &config.Listener{
+		Name:     "site",
+		Role:     config.RoleRestricted,
+		Commands: []string{"systemctl reload php*-fpm"},
+		Policy:   config.Policy{Capabilities: []string{"CAP_KILL", "CAP_CHOWN"}},
+	}
+	reloaded := *started
+	reloaded.Commands = []string{"php artisan migrate"}
+	reloaded.Policy = config.Policy{Capabilities: []string{"CAP_KILL"}}
+	st := &runtimeState{cfg: &config.Config{Listeners: []config.Listener{reloaded}}}
+
+	got := st.forListener(started)
+	if !reflect.DeepEqual(got.cfg.Policy.Capabilities, []string{"CAP_KILL"}) {
+		t.Errorf("capabilities = %v, want the reloaded policy", got.cfg.Policy.Capabilities)
+	}
+	if err := got.permit(&protocol.Request{Command: "systemctl reload php8.3-fpm"}); err == nil {
+		t.Error("a command removed by the reload should be rejected")
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"vito-local/internal/metrics"
+)
+
+// durationBuckets cover quick checks up to hour-long deploy scripts.
+var durationBuckets = []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 900, 3600}
+
+// serverMetrics are the service's instruments, all prefixed vito_root_.
+type serverMetrics struct {
+	registry *metrics.Registry
+
+	rejected        *metrics.Counter
+	commands        *metrics.Counter
+	actions         *metrics.Counter
+	commandDuration *metrics.Histogram
+	outputBytes     *metrics.Counter
+	updateChecks    *metrics.Counter
+	auditDropped    *metrics.Counter
+}
+
+func newServerMetrics(s *Server) *serverMetrics {
+	reg := metrics.NewRegistry()
+	m := &serverMetrics{registry: reg}
+
+	reg.NewGauge("vito_root_build_info", "Build information.", "version").Set(1, s.version)
+	reg.NewGaugeFunc("vito_root_start_time_seconds", "Unix time the service started.", func() float64 {
+		return float64(s.started.Unix())
+	})
+	reg.NewGaugeFunc("vito_root_connections_active", "Connections currently being handled.", func() float64 {
+		return float64(s.conns.Load())
+	})
+	reg.NewGaugeFunc("vito_root_connections_max", "Maximum concurrent connections (MaxConnections).", func() float64 {
+		return float64(s.state().maxConnections())
+	})
+	reg.NewGaugeFunc("vito_root_jobs_active", "Commands currently executing.", func() float64 {
+		return float64(s.activeJobs.Load())
+	})
+	reg.NewGaugeFunc("vito_root_jobs_queued", "Accepted requests not yet executing.", func() float64 {
+		return float64(s.queuedJobs.Load())
+	})
+	m.rejected = reg.NewCounter("vito_root_connections_rejected",
+		"Connections or requests rejected, by reason.", "reason")
+	m.commands = reg.NewCounter("vito_root_commands",
+		`Commands executed, by exit code ("error" if the command could not be run).`, "exit_code")
+	m.actions = reg.NewCounter("vito_root_actions", "Actions handled, by action.", "action")
+	m.commandDuration = reg.NewHistogram("vito_root_command_duration_seconds",
+		"Command execution time.", durationBuckets)
+	m.outputBytes = reg.NewCounter("vito_root_output_bytes",
+		"Bytes of command output streamed to clients, by stream.", "stream")
+	m.updateChecks = reg.NewCounter("vito_root_update_checks",
+		"Update checks and updates, by result.", "result")
+	m.auditDropped = reg.NewCounter("vito_root_audit_records_dropped",
+		"Audit records of rejected connections dropped because the write queue was full.")
+	return m
+}
+
+// Metrics returns the registry holding the service's metrics.
+func (s *Server) Metrics() *metrics.Registry {
+	return s.metrics.registry
+}
+
+// observeCommand records a finished command.
+func (m *serverMetrics) observeCommand(exitCode int, err error, duration time.Duration) {
+	label := strconv.Itoa(exitCode)
+	if err != nil {
+		label = "error"
+	}
+	m.commands.Inc(label)
+	m.commandDuration.Observe(duration.Seconds())
+}
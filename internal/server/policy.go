@@ -0,0 +1,61 @@
+package server
+
+import (
+	"vito-local/internal/config"
+	"vito-local/internal/executor"
+	"vito-local/internal/protocol"
+)
+
+// resolveSandbox combines the server policy with the request's sandbox.
+// The policy sandbox applies when the request has none; a request sandbox
+// must lie entirely within the policy. Both are compared, and returned,
+// with symlinks resolved. Returns nil when neither restricts filesystem
+// access.
+func resolveSandbox(policy config.Policy, req *protocol.Sandbox) (*executor.Sandbox, error) {
+	var policySandbox *executor.Sandbox
+	if policy.Sandboxed() {
+		var err error
+		policySandbox, err = policy.Sandbox().Resolve()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if req == nil {
+		return policySandbox, nil
+	}
+
+	sb := &executor.Sandbox{ReadPaths: req.Read, WritePaths: req.Write}
+	if err := sb.Validate(); err != nil {
+		return nil, err
+	}
+	sb, err := sb.Resolve()
+	if err != nil {
+		return nil, err
+	}
+	if policySandbox != nil {
+		if err := sb.Within(policySandbox); err != nil {
+			return nil, err
+		}
+	}
+	return sb, nil
+}
+
+// resolveCapabilities combines the server policy with the request's
+// capability set. The policy applies when the request has none; a request
+// set must be a subset of the policy. Returns nil when neither restricts
+// capabilities.
+func resolveCapabilities(policy config.Policy, req []string) ([]string, error) {
+	if req == nil {
+		return policy.Capabilities, nil
+	}
+	if _, err := executor.ParseCapabilities(req); err != nil {
+		return nil, err
+	}
+	if policy.Capabilities != nil {
+		if err := executor.CapabilitiesWithin(req, policy.Capabilities); err != nil {
+			return nil, err
+		}
+	}
+	return req, nil
+}
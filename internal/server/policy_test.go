@@ -0,0 +1,77 @@
+package server
+
+import (
+	"testing"
+
+	"vito-local/internal/config"
+	"vito-local/internal/protocol"
+)
+
+func TestResolveSandbox(t *testing.T) {
+	policy := config.Policy{WritePaths: []string{"/home", "/etc/nginx/sites-available"}}
+
+	sb, err := resolveSandbox(config.Policy{}, nil)
+	if err != nil || sb != nil {
+		t.Fatalf("expected no sandbox without policy or request, got %v, %v", sb, err)
+	}
+
+	sb, err = resolveSandbox(policy, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sb == nil || len(sb.WritePaths) != 2 {
+		t.Fatalf("expected policy sandbox, got %+v", sb)
+	}
+
+	sb, err = resolveSandbox(policy, &protocol.Sandbox{Write: []string{"/home/site"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(sb.WritePaths) != 1 || sb.WritePaths[0] != "/home/site" {
+		t.Errorf("expected request sandbox, got %+v", sb)
+	}
+
+	if _, err := resolveSandbox(policy, &protocol.Sandbox{Write: []string{"/etc"}}); err == nil {
+		t.Error("expected error for request sandbox wider than policy")
+	}
+
+	sb, err = resolveSandbox(config.Policy{}, &protocol.Sandbox{Write: []string{"/home/site"}})
+	if err != nil || sb == nil {
+		t.Fatalf("expected request sandbox without policy, got %v, %v", sb, err)
+	}
+
+	if _, err := resolveSandbox(config.Policy{}, &protocol.Sandbox{Write: []string{"site"}}); err == nil {
+		t.Error("expected error for relative path")
+	}
+}
+
+func TestResolveCapabilities(t *testing.T) {
+	policy := config.Policy{Capabilities: []string{"CAP_CHOWN", "CAP_NET_BIND_SERVICE"}}
+
+	caps, err := resolveCapabilities(config.Policy{}, nil)
+	if err != nil || caps != nil {
+		t.Fatalf("expected unrestricted capabilities, got %v, %v", caps, err)
+	}
+
+	caps, err = resolveCapabilities(policy, nil)
+	if err != nil || len(caps) != 2 {
+		t.Fatalf("expected policy capabilities, got %v, %v", caps, err)
+	}
+
+	caps, err = resolveCapabilities(policy, []string{"net_bind_service"})
+	if err != nil || len(caps) != 1 {
+		t.Fatalf("expected request subset, got %v, %v", caps, err)
+	}
+
+	caps, err = resolveCapabilities(policy, []string{})
+	if err != nil || caps == nil || len(caps) != 0 {
+		t.Fatalf("expected empty non-nil set, got %v, %v", caps, err)
+	}
+
+	if _, err := resolveCapabilities(policy, []string{"CAP_SYS_ADMIN"}); err == nil {
+		t.Error("expected error for capability outside policy")
+	}
+	if _, err := resolveCapabilities(config.Policy{}, []string{"CAP_BOGUS"}); err == nil {
+		t.Error("expected error for unknown capability")
+	}
+}
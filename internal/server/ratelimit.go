@@ -0,0 +1,216 @@
+package server
+
+import (
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// maxIdleBuckets bounds the number of tracked principals before idle
+// (fully refilled) buckets are pruned.
+const maxIdleBuckets = 1024
+
+// tokenBucket holds the remaining budget for one principal.
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter is a set of token buckets keyed by principal. A nil
+// rateLimiter allows everything.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[string]*tokenBucket
+}
+
+// newRateLimiter creates a limiter allowing perMinute requests per principal
+// with bursts of up to burst. Returns nil (unlimited) if perMinute <= 0.
+func newRateLimiter(perMinute, burst int) *rateLimiter {
+	if perMinute <= 0 {
+		return nil
+	}
+	if burst <= 0 {
+		burst = 1
+	}
+	return &rateLimiter{
+		rate:    float64(perMinute) / 60,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// allow consumes one token for key, reporting false if none is available.
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	return l.allowKeys([]string{key}, now)
+}
+
+// allowKeys consumes one token from each key's bucket, or none if any
+// bucket is empty, reporting whether the tokens were taken.
+func (l *rateLimiter) allowKeys(keys []string, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	buckets := l.refill(keys, now)
+	for _, b := range buckets {
+		if b.tokens < 1 {
+			return false
+		}
+	}
+	for _, b := range buckets {
+		b.tokens--
+	}
+	return true
+}
+
+// available reports whether every key's bucket holds a token, without
+// consuming any.
+func (l *rateLimiter) available(keys []string, now time.Time) bool {
+	if l == nil {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for _, b := range l.refill(keys, now) {
+		if b.tokens < 1 {
+			return false
+		}
+	}
+	return true
+}
+
+// refill brings the buckets for keys up to date, creating full ones for
+// new keys. l.mu must be held.
+func (l *rateLimiter) refill(keys []string, now time.Time) []*tokenBucket {
+	buckets := make([]*tokenBucket, len(keys))
+	for i, key := range keys {
+		b, ok := l.buckets[key]
+		if !ok {
+			if len(l.buckets) >= maxIdleBuckets {
+				l.prune(now)
+			}
+			b = &tokenBucket{tokens: l.burst, last: now}
+			l.buckets[key] = b
+		}
+		// Callers may pass slightly out-of-order times; never refill
+		// backwards.
+		if now.After(b.last) {
+			b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+			b.last = now
+		}
+		buckets[i] = b
+	}
+	return buckets
+}
+
+// prune drops buckets that would be full by now; they carry no state.
+func (l *rateLimiter) prune(now time.Time) {
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// principalKeys returns the rate limit keys for a peer: its UID and, when
+// known, its executable. A request must fit within both budgets.
+func principalKeys(creds *PeerCredentials) []string {
+	keys := []string{"uid:" + strconv.FormatUint(uint64(creds.UID), 10)}
+	if creds.Exe != "" {
+		keys = append(keys, "exe:"+creds.Exe)
+	}
+	return keys
+}
+
+// allowPrincipal consumes a token from each of the peer's budgets, or from
+// none if either is exhausted.
+func (l *rateLimiter) allowPrincipal(creds *PeerCredentials, now time.Time) bool {
+	return l.allowKeys(principalKeys(creds), now)
+}
+
+// abuseDetector logs a suspicious-activity warning when a principal
+// accumulates too many rejected requests within a window: failed
+// authorization, rate limiting, or requests the policy forbids. Commands
+// that merely exit non-zero do not count.
+type abuseDetector struct {
+	mu        sync.Mutex
+	logger    *slog.Logger
+	window    time.Duration
+	threshold int
+	events    map[string][]time.Time
+	lastAlert map[string]time.Time
+}
+
+// newAbuseDetector creates a detector. Returns nil (disabled) if
+// threshold <= 0.
+func newAbuseDetector(logger *slog.Logger, threshold int, window time.Duration) *abuseDetector {
+	if threshold <= 0 {
+		return nil
+	}
+	return &abuseDetector{
+		logger:    logger,
+		window:    window,
+		threshold: threshold,
+		events:    make(map[string][]time.Time),
+		lastAlert: make(map[string]time.Time),
+	}
+}
+
+// record notes a rejected request for the peer. At most one
+// warning is logged per principal per window.
+func (d *abuseDetector) record(creds *PeerCredentials, reason string, now time.Time) {
+	if d == nil {
+		return
+	}
+	key := principalKeys(creds)[0]
+	if creds.Exe != "" {
+		key += " " + creds.Exe
+	}
+
+	d.mu.Lock()
+	cutoff := now.Add(-d.window)
+	events := d.events[key]
+	i := 0
+	for i < len(events) && events[i].Before(cutoff) {
+		i++
+	}
+	events = append(events[i:], now)
+	d.events[key] = events
+
+	alert := len(events) >= d.threshold && now.Sub(d.lastAlert[key]) >= d.window
+	if alert {
+		d.lastAlert[key] = now
+	}
+	d.pruneLocked(cutoff)
+	d.mu.Unlock()
+
+	if alert {
+		d.logger.Warn("suspicious activity: burst of rejected requests",
+			slog.Int("peer_uid", int(creds.UID)),
+			slog.Int("peer_pid", int(creds.PID)),
+			slog.String("peer_exe", creds.Exe),
+			slog.String("last_reason", reason),
+			slog.Int("events", len(events)),
+			slog.Duration("window", d.window),
+		)
+	}
+}
+
+// pruneLocked forgets principals with no events inside the window.
+func (d *abuseDetector) pruneLocked(cutoff time.Time) {
+	if len(d.events) < maxIdleBuckets {
+		return
+	}
+	for key, events := range d.events {
+		if len(events) == 0 || events[len(events)-1].Before(cutoff) {
+			delete(d.events, key)
+			delete(d.lastAlert, key)
+		}
+	}
+}
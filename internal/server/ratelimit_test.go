@@ -0,0 +1,142 @@
+package server
+
+import (
+	"bytes"
+	"log/slog"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_Burst(t *testing.T) {
+	l := newRateLimiter(60, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		if !l.allow("uid:1000", now) {
+			t.Fatalf("request %d should be allowed within burst", i+1)
+		}
+	}
+	if l.allow("uid:1000", now) {
+		t.Error("request beyond burst should be rejected")
+	}
+	if !l.allow("uid:1001", now) {
+		t.Error("other principals should have their own budget")
+	}
+
+	// 60/min refills one token per second
+	if !l.allow("uid:1000", now.Add(time.Second)) {
+		t.Error("expected a token to be refilled after one second")
+	}
+	if l.allow("uid:1000", now.Add(time.Second)) {
+		t.Error("expected only one token to be refilled")
+	}
+}
+
+func TestRateLimiter_Disabled(t *testing.T) {
+	l := newRateLimiter(0, 0)
+	if l != nil {
+		t.Fatal("expected nil limiter when disabled")
+	}
+	for i := 0; i < 1000; i++ {
+		if !l.allow("uid:1000", time.Now()) {
+			t.Fatal("disabled limiter should allow everything")
+		}
+	}
+}
+
+func TestRateLimiter_Prune(t *testing.T) {
+	l := newRateLimiter(60, 1)
+	now := time.Now()
+	for i := 0; i < maxIdleBuckets; i++ {
+		l.allow("uid:"+strconv.Itoa(i), now)
+	}
+	l.allow("uid:new", now.Add(time.Hour))
+	if len(l.buckets) != 1 {
+		t.Errorf("expected idle buckets to be pruned, %d remain", len(l.buckets))
+	}
+}
+
+func TestRateLimiter_AllowPrincipal(t *testing.T) {
+	l := newRateLimiter(60, 1)
+	now := time.Now()
+
+	a := &PeerCredentials{UID: 1000, Exe: "/usr/bin/php"}
+	b := &PeerCredentials{UID: 1001, Exe: "/usr/bin/php"}
+
+	if !l.allowPrincipal(a, now) {
+		t.Fatal("first request should be allowed")
+	}
+	if l.allowPrincipal(b, now) {
+		t.Error("a different UID sharing the executable budget should be rejected")
+	}
+}
+
+func TestRateLimiter_AllowPrincipalIsAtomic(t *testing.T) {
+	l := newRateLimiter(60, 1)
+	now := time.Now()
+
+	// Exhaust the executable's budget through another UID.
+	if !l.allowPrincipal(&PeerCredentials{UID: 1001, Exe: "/usr/bin/php"}, now) {
+		t.Fatal("first request should be allowed")
+	}
+	a := &PeerCredentials{UID: 1000, Exe: "/usr/bin/php"}
+	if l.allowPrincipal(a, now) {
+		t.Fatal("exhausted executable budget should reject")
+	}
+	if !l.allowPrincipal(&PeerCredentials{UID: 1000, Exe: "/usr/bin/node"}, now) {
+		t.Error("a rejected request must not have spent the UID's token")
+	}
+}
+
+func TestRuntimeState_Admit(t *testing.T) {
+	st := &runtimeState{
+		cmdLimiter:    newRateLimiter(60, 1),
+		actionLimiter: newRateLimiter(60, 1),
+	}
+	creds := &PeerCredentials{UID: 1000, Exe: "/usr/bin/php"}
+	now := time.Now()
+
+	st.cmdLimiter.allowPrincipal(creds, now)
+	if !st.admit(creds) {
+		t.Fatal("a peer with action budget left should be admitted")
+	}
+	st.actionLimiter.allowPrincipal(creds, now)
+	if st.admit(creds) {
+		t.Error("a peer with no budget left should be refused")
+	}
+	if !st.cmdLimiter.available(principalKeys(creds), now.Add(time.Second)) {
+		t.Error("admit should not consume tokens")
+	}
+}
+
+func TestAbuseDetector(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, nil))
+	d := newAbuseDetector(logger, 3, time.Minute)
+	creds := &PeerCredentials{UID: 1000, PID: 42, Exe: "/usr/bin/php"}
+	now := time.Now()
+
+	d.record(creds, "rate_limited", now)
+	d.record(creds, "rate_limited", now)
+	if strings.Contains(buf.String(), "suspicious") {
+		t.Fatal("should not alert below threshold")
+	}
+
+	d.record(creds, "forbidden", now)
+	if strings.Count(buf.String(), "suspicious") != 1 {
+		t.Fatalf("expected one alert at threshold, got log %q", buf.String())
+	}
+
+	d.record(creds, "forbidden", now.Add(time.Second))
+	if strings.Count(buf.String(), "suspicious") != 1 {
+		t.Error("expected at most one alert per window")
+	}
+
+	// Old events fall out of the window
+	d.record(creds, "forbidden", now.Add(2*time.Minute))
+	if strings.Count(buf.String(), "suspicious") != 1 {
+		t.Error("expected no alert once earlier events expired")
+	}
+}
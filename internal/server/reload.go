@@ -0,0 +1,128 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"vito-local/internal/config"
+	"vito-local/internal/protocol"
+	"vito-local/internal/redact"
+)
+
+// defaultMaxConnections applies when the configuration sets no limit.
+const defaultMaxConnections = 100
+
+// runtimeState holds the settings that a reload can change: the allowed
+// user, limits, policies and redaction. Each connection takes the state
+// current when it is accepted and keeps it until it finishes, so a reload
+// never changes the rules for a request already in progress.
+type runtimeState struct {
+	cfg           *config.Config
+	redactor      *redact.Redactor
+	cmdLimiter    *rateLimiter
+	actionLimiter *rateLimiter
+	abuse         *abuseDetector
+	// listenerLimiters holds each listener's own budgets by name, so the
+	// clients of one socket cannot spend those of another.
+	listenerLimiters map[string]limiters
+	// listener is the listener the connection arrived on, or nil for the
+	// main socket.
+	listener *config.Listener
+}
+
+// limiters are the command and action budgets of one socket.
+type limiters struct {
+	cmd, action *rateLimiter
+}
+
+// newRuntimeState builds the state for cfg. Rate limit buckets and abuse
+// counters carry over from prev unless their limits changed, so a reload
+// does not hand every principal a fresh budget.
+func newRuntimeState(cfg *config.Config, redactor *redact.Redactor, logger *slog.Logger, prev *runtimeState) *runtimeState {
+	st := &runtimeState{cfg: cfg, redactor: redactor}
+	keepCmd := prev != nil && prev.cfg.CommandRateLimit == cfg.CommandRateLimit && prev.cfg.CommandBurst == cfg.CommandBurst
+	keepAction := prev != nil && prev.cfg.ActionRateLimit == cfg.ActionRateLimit && prev.cfg.ActionBurst == cfg.ActionBurst
+	carry := func(old limiters) limiters {
+		next := old
+		if !keepCmd || next.cmd == nil {
+			next.cmd = newRateLimiter(cfg.CommandRateLimit, cfg.CommandBurst)
+		}
+		if !keepAction || next.action == nil {
+			next.action = newRateLimiter(cfg.ActionRateLimit, cfg.ActionBurst)
+		}
+		return next
+	}
+	var main limiters
+	if prev != nil {
+		main = limiters{prev.cmdLimiter, prev.actionLimiter}
+	}
+	main = carry(main)
+	st.cmdLimiter, st.actionLimiter = main.cmd, main.action
+	st.listenerLimiters = make(map[string]limiters, len(cfg.Listeners))
+	for _, l := range cfg.Listeners {
+		var old limiters
+		if prev != nil {
+			old = prev.listenerLimiters[l.Name]
+		}
+		st.listenerLimiters[l.Name] = carry(old)
+	}
+	if prev != nil && prev.cfg.AbuseThreshold == cfg.AbuseThreshold {
+		st.abuse = prev.abuse
+	} else {
+		st.abuse = newAbuseDetector(logger, cfg.AbuseThreshold, abuseWindow)
+	}
+	return st
+}
+
+// maxConnections returns the concurrent connection limit of the socket
+// the state is for.
+func (st *runtimeState) maxConnections() int {
+	if st.listener != nil && st.listener.MaxConnections > 0 {
+		return st.listener.MaxConnections
+	}
+	if st.cfg.MaxConnections <= 0 {
+		return defaultMaxConnections
+	}
+	return st.cfg.MaxConnections
+}
+
+// admit reports whether the peer has budget left for a command or an
+// action. acceptLoop checks it before reading anything from the
+// connection; allowRequest then charges the budget for the request type.
+func (st *runtimeState) admit(creds *PeerCredentials) bool {
+	keys, now := principalKeys(creds), time.Now()
+	return st.cmdLimiter.available(keys, now) || st.actionLimiter.available(keys, now)
+}
+
+// allowRequest checks the request against the peer's command or action
+// budget.
+func (st *runtimeState) allowRequest(req *protocol.Request, creds *PeerCredentials) bool {
+	limiter := st.cmdLimiter
+	if req.Action != "" {
+		limiter = st.actionLimiter
+	}
+	return limiter.allowPrincipal(creds, time.Now())
+}
+
+// state returns the settings for newly accepted connections.
+func (s *Server) state() *runtimeState {
+	return s.rt.Load()
+}
+
+// Reload applies cfg to connections accepted from now on; connections
+// already being handled finish under the settings they started with.
+// Only the allowed user, limits, environment, secrets and policies,
+// including the role, commands and policy of each listener, are taken from
+// cfg: the sockets, audit, recording, metrics and tracing settings stay as
+// they were at startup and need a restart to change.
+func (s *Server) Reload(cfg *config.Config, redactor *redact.Redactor) {
+	if redactor == nil {
+		redactor = redact.Default()
+	}
+	s.rt.Store(newRuntimeState(cfg, redactor, s.logger, s.state()))
+	s.logger.Info("configuration reloaded",
+		slog.String("allowed_user", cfg.AllowedUser),
+		slog.Int("allowed_uid", int(cfg.AllowedUID)),
+		slog.Int("max_connections", s.state().maxConnections()),
+	)
+}
@@ -0,0 +1,102 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+
+	"vito-local/internal/config"
+	"vito-local/internal/protocol"
+	"vito-local/internal/redact"
+)
+
+// runWithState handles req under st and returns the command's stdout.
+func runWithState(t *testing.T, srv *Server, st *runtimeState, req protocol.Request) string {
+	t.Helper()
+	serverConn, clientConn, cleanup := setupTestSocket(t)
+	defer cleanup()
+
+	creds := &PeerCredentials{UID: uint32(os.Getuid()), PID: int32(os.Getpid())}
+	data, _ := json.Marshal(req)
+	clientConn.Write(append(data, '\n'))
+
+	done := make(chan struct{})
+	go func() {
+		handleConnection(context.Background(), serverConn, creds, srv, srv.logger, st)
+		close(done)
+	}()
+
+	var stdout string
+	scanner := bufio.NewScanner(clientConn)
+	for scanner.Scan() {
+		var resp protocol.Response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if resp.Type == protocol.TypeStdout {
+			stdout += resp.Data
+		}
+		if resp.Type == protocol.TypeExit || resp.Type == protocol.TypeError {
+			break
+		}
+	}
+	<-done
+	return stdout
+}
+
+func TestReload_InFlightKeepsSettings(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	srv := testServer(t, logger)
+	before := srv.state()
+
+	srv.Reload(&config.Config{MaxConnections: 5, EnvAllowlist: []string{"OTHER"}}, nil)
+	if srv.state() == before {
+		t.Fatal("Reload did not replace the state")
+	}
+
+	req := protocol.Request{Command: `echo "[$FOO]"`, Env: map[string]string{"FOO": "bar"}}
+	if out := runWithState(t, srv, before, req); !strings.Contains(out, "[bar]") {
+		t.Errorf("connection accepted before the reload got %q, want FOO passed through", out)
+	}
+	if out := runWithState(t, srv, srv.state(), req); !strings.Contains(out, "[]") {
+		t.Errorf("connection accepted after the reload got %q, want FOO filtered", out)
+	}
+	if got := srv.health().MaxConnections; got != 5 {
+		t.Errorf("MaxConnections after reload = %d, want 5", got)
+	}
+}
+
+func TestReload_CarriesOverLimiters(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(io.Discard, nil))
+	cfg := &config.Config{MaxConnections: 10, CommandRateLimit: 60, CommandBurst: 1, ActionRateLimit: 60, ActionBurst: 1, AbuseThreshold: 5,
+		Listeners: []config.Listener{{Name: "site"}}}
+	srv := New(cfg, logger)
+	before := srv.state()
+	site := before.forListener(&cfg.Listeners[0])
+	if site.cmdLimiter == before.cmdLimiter || site.actionLimiter == before.actionLimiter {
+		t.Error("a listener should have its own budgets")
+	}
+
+	next := *cfg
+	next.ActionRateLimit = 120
+	srv.Reload(&next, redact.Default())
+	after := srv.state()
+
+	if after.cmdLimiter != before.cmdLimiter {
+		t.Error("unchanged command limit should keep its buckets")
+	}
+	if after.abuse != before.abuse {
+		t.Error("unchanged abuse threshold should keep its counters")
+	}
+	if after.actionLimiter == before.actionLimiter || after.actionLimiter.rate != 2 {
+		t.Error("changed action limit should get a new limiter")
+	}
+	if l := after.listenerLimiters["site"]; l.cmd != site.cmdLimiter || l.action == site.actionLimiter {
+		t.Error("listener budgets should carry over like those of the main socket")
+	}
+}
@@ -8,26 +8,60 @@ import (
 	"log/slog"
 	"net"
 	"os"
-	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 
+	"vito-local/internal/audit"
 	"vito-local/internal/config"
 	"vito-local/internal/protocol"
+	"vito-local/internal/recording"
+	"vito-local/internal/redact"
+	"vito-local/internal/tracing"
 )
 
 // Server listens on a Unix socket and handles command execution requests.
 type Server struct {
+	// cfg is the startup configuration. Settings that Reload can change
+	// are read from rt instead.
 	cfg           *config.Config
+	rt            atomic.Pointer[runtimeState]
 	logger        *slog.Logger
 	listener      *net.UnixListener
+	extra         []*extraListener
 	wg            sync.WaitGroup
 	systemdSocket bool
-	connSem       chan struct{}
+	conns         atomic.Int64
 	version       string
 	binaryPath    string
 	restartChan   chan struct{}
+	auditSink     audit.Sink
+	history       *audit.History
+	recorder      *recording.Recorder
+	metrics       *serverMetrics
+	tracer        *tracing.Tracer
+	started       time.Time
+	activeJobs    atomic.Int64
+	queuedJobs    atomic.Int64
+	accepting     atomic.Int32
+	idleChan      chan struct{}
+	lastActive    atomic.Int64
+	// pings holds the client addresses of Ping probes in flight.
+	pings sync.Map
+	// mainConns counts the open connections on the main socket, which
+	// has its own limit like each listener; conns counts those on every
+	// socket.
+	mainConns atomic.Int64
+	// rejects queues audit records of rejected connections for
+	// writeRejected; rejectsStop and rejectsDone end it at shutdown.
+	rejects     chan *audit.Record
+	rejectsStop chan struct{}
+	rejectsDone chan struct{}
 }
 
+// abuseWindow is the sliding window for suspicious-activity detection.
+const abuseWindow = time.Minute
+
 // Option is a functional option for configuring the server.
 type Option func(*Server)
 
@@ -45,21 +79,36 @@ func WithBinaryPath(binaryPath string) Option {
 	}
 }
 
+// WithRecorder enables recording of every command's output.
+func WithRecorder(r *recording.Recorder) Option {
+	return func(s *Server) {
+		s.recorder = r
+	}
+}
+
+// WithRedactor sets the redactor applied to command output and logged
+// command strings. Defaults to redact.Default().
+func WithRedactor(r *redact.Redactor) Option {
+	return func(s *Server) {
+		s.state().redactor = r
+	}
+}
+
 // New creates a new Server with the given configuration and logger.
 func New(cfg *config.Config, logger *slog.Logger, opts ...Option) *Server {
-	maxConn := cfg.MaxConnections
-	if maxConn <= 0 {
-		maxConn = 100
-	}
 	s := &Server{
 		cfg:         cfg,
 		logger:      logger,
-		connSem:     make(chan struct{}, maxConn),
 		restartChan: make(chan struct{}, 1),
+		idleChan:    make(chan struct{}, 1),
+		started:     time.Now(),
+		rejects:     make(chan *audit.Record, rejectQueueSize),
 	}
+	s.rt.Store(newRuntimeState(cfg, redact.Default(), logger, nil))
 	for _, opt := range opts {
 		opt(s)
 	}
+	s.metrics = newServerMetrics(s)
 	return s
 }
 
@@ -89,17 +138,21 @@ func (s *Server) BinaryPath() string {
 
 // Start begins listening for connections and handling them.
 func (s *Server) Start(ctx context.Context) error {
-	listener, err := s.createListener()
-	if err != nil {
+	if err := s.createListeners(); err != nil {
 		return fmt.Errorf("creating listener: %w", err)
 	}
-	s.listener = listener
 
-	// Set socket permissions (skip for systemd-managed sockets)
-	if !s.systemdSocket {
-		if err := s.setSocketPermissions(); err != nil {
-			s.logger.Warn("failed to set socket permissions (may be expected on non-Linux)",
-				slog.String("error", err.Error()))
+	// Apply the configured ownership and mode in standalone mode. systemd
+	// creates the socket under activation, so only check that it agrees.
+	if err := s.prepareSocket(mainSocket(s.cfg), s.systemdSocket, s.logger); err != nil {
+		s.closeListeners()
+		return fmt.Errorf("setting socket permissions: %w", err)
+	}
+	for _, el := range s.extra {
+		logger := s.logger.With(slog.String("listener", el.def.Name))
+		if err := s.prepareSocket(listenerSocket(el.def), el.systemd, logger); err != nil {
+			s.closeListeners()
+			return fmt.Errorf("listener %q: setting socket permissions: %w", el.def.Name, err)
 		}
 	}
 
@@ -108,10 +161,37 @@ func (s *Server) Start(ctx context.Context) error {
 		slog.String("allowed_user", s.cfg.AllowedUser),
 		slog.Int("allowed_uid", int(s.cfg.AllowedUID)),
 		slog.Bool("systemd_activated", s.systemdSocket),
-		slog.Int("max_connections", cap(s.connSem)),
+		slog.Int("max_connections", s.state().maxConnections()),
 	)
 
-	go s.acceptLoop(ctx)
+	for _, el := range s.extra {
+		s.logger.Info("listener started",
+			slog.String("listener", el.def.Name),
+			slog.String("socket", el.def.SocketPath),
+			slog.String("role", el.def.Role),
+			slog.Any("users", el.def.Users),
+			slog.Bool("systemd_activated", el.systemd),
+		)
+	}
+
+	if s.auditSink != nil {
+		s.rejectsStop = make(chan struct{})
+		s.rejectsDone = make(chan struct{})
+		go s.writeRejected(s.rejectsStop, s.rejectsDone)
+	}
+
+	s.touch()
+	s.accepting.Add(int32(1 + len(s.extra)))
+	go s.acceptLoop(ctx, s.listener, nil, &s.mainConns)
+	for _, el := range s.extra {
+		go s.acceptLoop(ctx, el.listener, el.def, &el.conns)
+	}
+
+	// Idle exit relies on systemd to start the service again, which it
+	// can only do for sockets it passed.
+	if s.allSystemd() && s.cfg.IdleTimeout > 0 {
+		go s.watchIdle(ctx, s.cfg.IdleTimeout)
+	}
 
 	return nil
 }
@@ -120,9 +200,7 @@ func (s *Server) Start(ctx context.Context) error {
 func (s *Server) Shutdown(ctx context.Context) error {
 	s.logger.Info("shutting down server")
 
-	if s.listener != nil {
-		_ = s.listener.Close()
-	}
+	s.closeListeners()
 
 	// Wait for in-flight connections with context timeout
 	done := make(chan struct{})
@@ -138,95 +216,155 @@ func (s *Server) Shutdown(ctx context.Context) error {
 		s.logger.Warn("shutdown timed out, some connections may be interrupted")
 	}
 
+	// Write the records of connections rejected before the listeners closed
+	if s.rejectsStop != nil {
+		close(s.rejectsStop)
+		select {
+		case <-s.rejectsDone:
+		case <-ctx.Done():
+		}
+	}
+
 	// Only remove socket file in standalone mode; systemd owns it during socket activation.
 	if !s.systemdSocket {
 		if err := os.Remove(s.cfg.SocketPath); err != nil && !os.IsNotExist(err) {
 			s.logger.Warn("failed to remove socket file", slog.String("error", err.Error()))
 		}
 	}
+	for _, el := range s.extra {
+		if el.systemd {
+			continue
+		}
+		if err := os.Remove(el.def.SocketPath); err != nil && !os.IsNotExist(err) {
+			s.logger.Warn("failed to remove socket file",
+				slog.String("listener", el.def.Name), slog.String("error", err.Error()))
+		}
+	}
 
 	return nil
 }
 
-func (s *Server) createListener() (*net.UnixListener, error) {
-	// Check for systemd socket activation (LISTEN_FDS)
-	if listenFDs := os.Getenv("LISTEN_FDS"); listenFDs != "" {
-		n, err := strconv.Atoi(listenFDs)
-		if err == nil && n > 0 {
-			// fd 3 is the first passed fd (after stdin/stdout/stderr)
-			f := os.NewFile(3, "systemd-socket")
-			if f == nil {
-				return nil, fmt.Errorf("failed to create file from fd 3")
-			}
-			defer func() { _ = f.Close() }()
-
-			l, err := net.FileListener(f)
-			if err != nil {
-				return nil, fmt.Errorf("creating listener from systemd fd: %w", err)
-			}
-
-			ul, ok := l.(*net.UnixListener)
-			if !ok {
-				_ = l.Close()
-				return nil, fmt.Errorf("systemd fd is not a Unix socket")
-			}
+// socketSpec is a socket file's path and the ownership and mode it
+// should have.
+type socketSpec struct {
+	path     string
+	owner    string
+	ownerUID uint32
+	group    string
+	groupGID uint32
+	mode     uint32
+}
 
-			s.systemdSocket = true
-			s.logger.Info("using systemd socket activation")
-			return ul, nil
-		}
+// mainSocket returns the expected ownership and mode of the main socket.
+func mainSocket(cfg *config.Config) socketSpec {
+	return socketSpec{
+		path:     cfg.SocketPath,
+		owner:    cfg.SocketOwner,
+		ownerUID: cfg.SocketOwnerUID,
+		group:    cfg.SocketGroup,
+		groupGID: cfg.SocketGroupGID,
+		mode:     cfg.SocketMode,
 	}
+}
 
-	// Standalone mode: remove stale socket and create new listener
-	if err := os.Remove(s.cfg.SocketPath); err != nil && !os.IsNotExist(err) {
-		return nil, fmt.Errorf("removing stale socket: %w", err)
+// setSocketPermissions gives a socket created by listenUnix its owner and
+// group. Nothing here follows symlinks: the socket's directory may be
+// writable by clients, and one that replaced the socket with a link would
+// otherwise have root change the ownership of the link's target.
+func (s *Server) setSocketPermissions(spec socketSpec) error {
+	// Chown needs root. Without it (during development) the socket keeps
+	// the current user's ownership.
+	if err := os.Lchown(spec.path, int(spec.ownerUID), int(spec.groupGID)); err != nil {
+		if os.Geteuid() == 0 {
+			return fmt.Errorf("chown to %s:%s: %w", spec.owner, spec.group, err)
+		}
+		s.logger.Warn("failed to chown socket (expected without root)",
+			slog.String("socket", spec.path),
+			slog.String("error", err.Error()),
+			slog.String("owner", spec.owner),
+			slog.String("group", spec.group),
+		)
 	}
 
-	addr := &net.UnixAddr{Name: s.cfg.SocketPath, Net: "unix"}
-	listener, err := net.ListenUnix("unix", addr)
+	fi, err := os.Lstat(spec.path)
 	if err != nil {
-		return nil, fmt.Errorf("listening on %s: %w", s.cfg.SocketPath, err)
+		return err
 	}
-
-	return listener, nil
+	if fi.Mode().Type() != os.ModeSocket {
+		return fmt.Errorf("%s was replaced after it was created", spec.path)
+	}
+	if mode := uint32(fi.Mode().Perm()); mode != spec.mode {
+		return fmt.Errorf("mode is %04o, expected %04o", mode, spec.mode)
+	}
+	return nil
 }
 
-func (s *Server) setSocketPermissions() error {
-	if err := os.Chmod(s.cfg.SocketPath, os.FileMode(s.cfg.SocketMode)); err != nil {
-		return fmt.Errorf("chmod: %w", err)
+// verifySocket checks the socket file's mode, owner and group against
+// spec. Unset owner or group names are not checked.
+func verifySocket(spec socketSpec) error {
+	fi, err := os.Stat(spec.path)
+	if err != nil {
+		return err
 	}
-
-	// Set group ownership so the allowed user's group can connect.
-	// Chown may fail on non-Linux or without root privileges, which is
-	// expected during development.
-	if err := os.Chown(s.cfg.SocketPath, -1, int(s.cfg.SocketGroupGID)); err != nil {
-		s.logger.Warn("failed to chown socket (expected on non-Linux or without root)",
-			slog.String("error", err.Error()),
-			slog.String("group", s.cfg.SocketGroup),
-			slog.Int("gid", int(s.cfg.SocketGroupGID)),
-		)
+	if mode := uint32(fi.Mode().Perm()); spec.mode != 0 && mode != spec.mode {
+		return fmt.Errorf("mode is %04o, expected %04o", mode, spec.mode)
+	}
+	uid, gid, ok := fileOwner(fi)
+	if !ok {
+		return nil
+	}
+	if spec.owner != "" && uid != spec.ownerUID {
+		return fmt.Errorf("owner is %s, expected %s", lookupUserName(uid), spec.owner)
+	}
+	if spec.group != "" && gid != spec.groupGID {
+		return fmt.Errorf("group is %s, expected %s", lookupGroupName(gid), spec.group)
 	}
-
 	return nil
 }
 
-func (s *Server) acceptLoop(ctx context.Context) {
+// acceptLoop accepts connections on l until it is closed. def is the
+// listener's configuration, or nil for the main socket, and active counts
+// the socket's open connections.
+func (s *Server) acceptLoop(ctx context.Context, l *net.UnixListener, def *config.Listener, active *atomic.Int64) {
+	defer s.accepting.Add(-1)
+	logger := s.logger
+	if def != nil {
+		logger = logger.With(slog.String("listener", def.Name))
+	}
 	for {
-		conn, err := s.listener.AcceptUnix()
+		conn, err := l.AcceptUnix()
 		if err != nil {
 			if errors.Is(err, net.ErrClosed) {
 				return
 			}
-			s.logger.Error("accept error", slog.String("error", err.Error()))
+			logger.Error("accept error", slog.String("error", err.Error()))
 			continue
 		}
+		accepted := time.Now()
+		if def == nil && s.isPing(conn) {
+			s.wg.Add(1)
+			go func() {
+				defer s.wg.Done()
+				s.answerPing(ctx, conn, logger)
+			}()
+			continue
+		}
+		st := s.state()
+		allowed := []uint32{st.cfg.AllowedUID}
+		if def != nil {
+			st = st.forListener(def)
+			allowed = def.UIDs
+		}
 
-		creds, err := AuthorizeConnection(conn, s.cfg.AllowedUID)
+		creds, err := AuthorizeConnection(conn, allowed...)
 		if err != nil {
-			s.logger.Warn("connection rejected",
+			logger.Warn("connection rejected",
 				slog.String("error", err.Error()),
 			)
+			s.metrics.rejected.Inc("unauthorized")
+			s.auditRejected(creds, def, err.Error(), logger)
 			if creds != nil {
+				st.abuse.record(creds, "unauthorized", time.Now())
 				resp := errorResponseBytes("unauthorized: connection rejected")
 				_, _ = conn.Write(resp)
 			}
@@ -234,24 +372,47 @@ func (s *Server) acceptLoop(ctx context.Context) {
 			continue
 		}
 
-		// Enforce concurrent connection limit
-		select {
-		case s.connSem <- struct{}{}:
-			s.wg.Add(1)
-			go func() {
-				defer func() { <-s.connSem }()
-				defer s.wg.Done()
-				handleConnection(ctx, conn, creds, s, s.logger, s.cfg.MaxExecTimeout)
-			}()
-		default:
-			s.logger.Warn("max connections reached, rejecting",
+		if !st.admit(creds) {
+			logger.Warn("rate limit exceeded",
 				slog.Int("peer_uid", int(creds.UID)),
 				slog.Int("peer_pid", int(creds.PID)),
+				slog.String("peer_exe", creds.Exe),
 			)
+			st.abuse.record(creds, "rate_limited", time.Now())
+			s.metrics.rejected.Inc("rate_limited")
+			s.auditRejected(creds, def, "rate limit exceeded", logger)
+			_, _ = conn.Write(errorResponseBytes("rate limit exceeded"))
+			_ = conn.Close()
+			continue
+		}
+
+		connCtx := withConnTiming(ctx, connTiming{accepted: accepted, authorized: time.Now()})
+
+		// Enforce the socket's concurrent connection limit
+		if active.Add(1) > int64(st.maxConnections()) {
+			active.Add(-1)
+			logger.Warn("max connections reached, rejecting",
+				slog.Int("peer_uid", int(creds.UID)),
+				slog.Int("peer_pid", int(creds.PID)),
+			)
+			st.abuse.record(creds, "capacity", time.Now())
+			s.metrics.rejected.Inc("capacity")
+			s.auditRejected(creds, def, "server at maximum capacity", logger)
 			resp := errorResponseBytes("server at maximum capacity")
 			_, _ = conn.Write(resp)
 			_ = conn.Close()
+			continue
 		}
+		s.conns.Add(1)
+		s.wg.Add(1)
+		s.touch()
+		go func() {
+			defer active.Add(-1)
+			defer s.conns.Add(-1)
+			defer s.touch()
+			defer s.wg.Done()
+			handleConnection(connCtx, conn, creds, s, logger, st)
+		}()
 	}
 }
 
@@ -39,6 +39,8 @@ func testConfig(t *testing.T, sockPath string) *config.Config {
 		SocketPath:     sockPath,
 		AllowedUser:    u.Username,
 		AllowedUID:     uint32(uid),
+		SocketOwner:    u.Username,
+		SocketOwnerUID: uint32(uid),
 		SocketGroup:    u.Username,
 		SocketGroupGID: uint32(gid),
 		SocketMode:     0660,
@@ -60,10 +62,13 @@ func TestServer_StartAndShutdown(t *testing.T) {
 		t.Fatalf("failed to start server: %v", err)
 	}
 
-	// Verify socket file exists
+	// Verify socket file exists with the configured mode and ownership
 	if _, err := os.Stat(sockPath); err != nil {
 		t.Fatalf("socket file should exist: %v", err)
 	}
+	if err := verifySocket(mainSocket(cfg)); err != nil {
+		t.Errorf("socket does not match the configuration: %v", err)
+	}
 
 	shutdownCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	defer cancel()
@@ -224,3 +229,31 @@ func TestServer_ConnectionDraining(t *testing.T) {
 		t.Error("expected command to complete during graceful shutdown")
 	}
 }
+
+func TestServer_AuditRejectedQueueDropsWhenFull(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	sink := &memorySink{}
+	srv := New(&config.Config{MaxConnections: 10}, logger, WithAudit(sink))
+	creds := &PeerCredentials{UID: 1000, PID: 42}
+
+	// Nothing drains the queue, so the calls past its size must not block
+	for range rejectQueueSize + 10 {
+		srv.auditRejected(creds, nil, "rate limit exceeded", logger)
+	}
+	if got := srv.metrics.auditDropped.Value(); got != 10 {
+		t.Errorf("expected 10 dropped records, got %v", got)
+	}
+
+	stop, done := make(chan struct{}), make(chan struct{})
+	close(stop)
+	srv.writeRejected(stop, done)
+	<-done
+
+	records := sink.all()
+	if len(records) != rejectQueueSize {
+		t.Fatalf("expected %d audit records, got %d", rejectQueueSize, len(records))
+	}
+	if rec := records[0]; rec.Error != "rate limit exceeded" || rec.PeerUID != 1000 {
+		t.Errorf("unexpected record: %+v", rec)
+	}
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"vito-local/internal/audit"
+	"vito-local/internal/tracing"
+)
+
+// WithTracer enables request tracing. Without it, an incoming traceparent
+// is still passed through to commands unchanged.
+func WithTracer(t *tracing.Tracer) Option {
+	return func(s *Server) {
+		s.tracer = t
+	}
+}
+
+// connTiming records when a connection was accepted and authorized, so
+// the authorize span can be created once the request (and with it the
+// caller's trace context) has been read.
+type connTiming struct {
+	accepted   time.Time
+	authorized time.Time
+}
+
+type connTimingKey struct{}
+
+func withConnTiming(ctx context.Context, t connTiming) context.Context {
+	return context.WithValue(ctx, connTimingKey{}, t)
+}
+
+func connTimingFrom(ctx context.Context) connTiming {
+	t, _ := ctx.Value(connTimingKey{}).(connTiming)
+	return t
+}
+
+// requestTrace is the trace of one connection: the caller's context, if
+// any, and the root span when tracing is enabled.
+type requestTrace struct {
+	parent tracing.SpanContext
+	root   *tracing.Span
+}
+
+// startRequestTrace creates the root span as a child of the caller's
+// traceparent (a new trace when it is absent or invalid) and records the
+// authorize and parse spans that preceded it.
+func (s *Server) startRequestTrace(ctx context.Context, traceparent string, parseStart, parseEnd time.Time, parseErr error, logger *slog.Logger) *requestTrace {
+	t := &requestTrace{}
+	if traceparent != "" {
+		parent, err := tracing.ParseTraceparent(traceparent)
+		if err != nil {
+			logger.Debug("ignoring invalid traceparent", slog.String("error", err.Error()))
+		} else {
+			t.parent = parent
+		}
+	}
+
+	timing := connTimingFrom(ctx)
+	start := timing.accepted
+	if start.IsZero() {
+		start = parseStart
+	}
+	t.root = s.tracer.Start(t.parent, "request", tracing.KindServer, start)
+
+	if !timing.accepted.IsZero() {
+		auth := t.root.Child("authorize", timing.accepted)
+		auth.FinishAt(timing.authorized)
+	}
+	parse := t.root.Child("parse", parseStart)
+	if parseErr != nil {
+		parse.SetError(parseErr.Error())
+	}
+	parse.FinishAt(parseEnd)
+	return t
+}
+
+// child starts a span under the root span.
+func (t *requestTrace) child(name string) *tracing.Span {
+	return t.root.Child(name, time.Time{})
+}
+
+// traceparent returns the value to pass to a command as TRACEPARENT: the
+// given span when tracing is enabled, otherwise the caller's context
+// unchanged. It is empty when there is neither.
+func (t *requestTrace) traceparent(span *tracing.Span) string {
+	if sc := span.SpanContext(); sc.IsValid() {
+		return sc.Traceparent()
+	}
+	if t.parent.IsValid() {
+		return t.parent.Traceparent()
+	}
+	return ""
+}
+
+// finish annotates the root span from the audit record and ends it.
+func (t *requestTrace) finish(rec *audit.Record) {
+	root := t.root
+	if root == nil {
+		return
+	}
+	root.SetAttr("vito.job_id", rec.JobID)
+	root.SetAttr("vito.peer_uid", rec.PeerUID)
+	root.SetAttr("vito.peer_pid", rec.PeerPID)
+	if rec.PeerExe != "" {
+		root.SetAttr("vito.peer_exe", rec.PeerExe)
+	}
+	if rec.Action != "" {
+		root.SetAttr("vito.action", rec.Action)
+	}
+	if rec.Command != "" {
+		root.SetAttr("vito.command", rec.Command)
+	}
+	if rec.ExitCode != nil {
+		root.SetAttr("vito.exit_code", *rec.ExitCode)
+	}
+	if rec.Error != "" {
+		root.SetError(rec.Error)
+	}
+	root.Finish()
+}
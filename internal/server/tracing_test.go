@@ -0,0 +1,182 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"vito-local/internal/config"
+	"vito-local/internal/protocol"
+	"vito-local/internal/tracing"
+)
+
+type memoryExporter struct {
+	mu    sync.Mutex
+	spans []*tracing.Span
+}
+
+func (m *memoryExporter) Export(s *tracing.Span) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.spans = append(m.spans, s)
+}
+
+func (m *memoryExporter) byName() map[string]*tracing.Span {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]*tracing.Span)
+	for _, s := range m.spans {
+		out[s.Name] = s
+	}
+	return out
+}
+
+// runTraced sends req and returns the command's stdout.
+func runTraced(t *testing.T, srv *Server, ctx context.Context, req protocol.Request) string {
+	t.Helper()
+	serverConn, clientConn, cleanup := setupTestSocket(t)
+	defer cleanup()
+
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	creds := &PeerCredentials{UID: uint32(os.Getuid()), PID: int32(os.Getpid())}
+
+	data, _ := json.Marshal(req)
+	clientConn.Write(append(data, '\n'))
+
+	done := make(chan struct{})
+	go func() {
+		handleConnection(ctx, serverConn, creds, srv, logger, srv.state())
+		close(done)
+	}()
+
+	var stdout string
+	scanner := bufio.NewScanner(clientConn)
+	for scanner.Scan() {
+		var resp protocol.Response
+		if err := json.Unmarshal(scanner.Bytes(), &resp); err != nil {
+			t.Fatalf("failed to unmarshal response: %v", err)
+		}
+		if resp.Type == protocol.TypeStdout {
+			stdout += resp.Data
+		}
+		if resp.Type == protocol.TypeExit || resp.Type == protocol.TypeError {
+			break
+		}
+	}
+	<-done
+	return strings.TrimSpace(stdout)
+}
+
+func TestHandleConnection_Tracing(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	exporter := &memoryExporter{}
+	srv := New(&config.Config{MaxConnections: 10}, logger, WithTracer(tracing.New(exporter)))
+
+	accepted := time.Now().Add(-time.Millisecond)
+	ctx := withConnTiming(context.Background(), connTiming{accepted: accepted, authorized: accepted.Add(time.Microsecond)})
+	const caller = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	got := runTraced(t, srv, ctx, protocol.Request{
+		Command:     "echo $TRACEPARENT",
+		Env:         map[string]string{"TRACEPARENT": "00-ffffffffffffffffffffffffffffffff-ffffffffffffffff-01"},
+		Traceparent: caller,
+	})
+
+	spans := exporter.byName()
+	for _, name := range []string{"request", "authorize", "parse", "queue", "execute"} {
+		if spans[name] == nil {
+			t.Fatalf("missing %q span; got %v", name, spans)
+		}
+	}
+
+	parent, _ := tracing.ParseTraceparent(caller)
+	root := spans["request"]
+	if root.Context.TraceID != parent.TraceID || root.Parent != parent.SpanID {
+		t.Error("request span is not a child of the caller's span")
+	}
+	if root.Kind != tracing.KindServer || !root.Start.Equal(accepted) {
+		t.Errorf("unexpected request span: kind %d, start %v", root.Kind, root.Start)
+	}
+	if root.Attrs["vito.command"] != "echo $TRACEPARENT" || root.Attrs["vito.exit_code"] != 0 {
+		t.Errorf("unexpected request span attributes: %v", root.Attrs)
+	}
+	for _, name := range []string{"authorize", "parse", "queue", "execute"} {
+		if spans[name].Parent != root.Context.SpanID {
+			t.Errorf("%s span is not a child of the request span", name)
+		}
+	}
+
+	if want := spans["execute"].Context.Traceparent(); got != want {
+		t.Errorf("TRACEPARENT = %q, want execute span %q", got, want)
+	}
+}
+
+func TestHandleConnection_TracingRejected(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelError}))
+	exporter := &memoryExporter{}
+	srv := New(&config.Config{MaxConnections: 10}, logger, WithTracer(tracing.New(exporter)))
+
+	// Neither command nor action: rejected before it leaves the queue.
+	runTraced(t, srv, context.Background(), protocol.Request{Cwd: "/tmp"})
+
+	spans := exporter.byName()
+	root, queue := spans["request"], spans["queue"]
+	if root == nil || queue == nil {
+		t.Fatalf("missing request or queue span; got %v", spans)
+	}
+	if queue.End.After(root.End) {
+		t.Errorf("queue span ended %v after the request span", queue.End.Sub(root.End))
+	}
+	exporter.mu.Lock()
+	last := exporter.spans[len(exporter.spans)-1]
+	exporter.mu.Unlock()
+	if last != root {
+		t.Errorf("request span should end last, got %q", last.Name)
+	}
+}
+
+func TestHandleConnection_TraceparentPassthrough(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	srv := testServer(t, logger)
+
+	const caller = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-00"
+	got := runTraced(t, srv, context.Background(), protocol.Request{
+		Command:     "echo $TRACEPARENT",
+		Traceparent: caller,
+	})
+	if got != caller {
+		t.Errorf("TRACEPARENT = %q, want %q", got, caller)
+	}
+
+	got = runTraced(t, srv, context.Background(), protocol.Request{
+		Command:     "echo \"[$TRACEPARENT]\"",
+		Traceparent: "not-a-traceparent",
+	})
+	if got != "[]" {
+		t.Errorf("expected no TRACEPARENT for an invalid value, got %q", got)
+	}
+}
+
+func TestHandleConnection_TracesActions(t *testing.T) {
+	logger := slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	exporter := &memoryExporter{}
+	srv := New(&config.Config{MaxConnections: 10}, logger, WithVersion("test-version"), WithTracer(tracing.New(exporter)))
+
+	runTraced(t, srv, context.Background(), protocol.Request{Action: "version"})
+
+	spans := exporter.byName()
+	if spans["action version"] == nil {
+		t.Fatalf("missing action span; got %v", spans)
+	}
+	if spans["request"].Attrs["vito.action"] != "version" {
+		t.Errorf("unexpected request span attributes: %v", spans["request"].Attrs)
+	}
+	if spans["authorize"] != nil {
+		t.Error("expected no authorize span without connection timing")
+	}
+}
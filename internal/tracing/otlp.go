@@ -0,0 +1,337 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultEndpoint is the standard OTLP/HTTP traces endpoint of a collector
+// on the local host.
+const DefaultEndpoint = "http://127.0.0.1:4318/v1/traces"
+
+// Batching limits. Spans are buffered and sent every flushInterval or once
+// batchSize spans are waiting; beyond maxQueue, new spans are dropped so a
+// missing collector cannot grow memory.
+const (
+	flushInterval = 5 * time.Second
+	batchSize     = 256
+	maxQueue      = 4096
+	exportTimeout = 10 * time.Second
+)
+
+// OTLPOptions configures an OTLP exporter.
+type OTLPOptions struct {
+	// Endpoint is the collector's OTLP/HTTP traces URL. Defaults to
+	// DefaultEndpoint.
+	Endpoint string
+	// ServiceName and ServiceVersion identify this process in the
+	// exported resource.
+	ServiceName    string
+	ServiceVersion string
+	// Logger receives export failures. Defaults to slog.Default().
+	Logger *slog.Logger
+}
+
+// OTLPExporter batches spans and posts them to a collector as OTLP/HTTP
+// JSON. Export never blocks on the network.
+type OTLPExporter struct {
+	endpoint string
+	resource otlpResource
+	scope    otlpScope
+	client   *http.Client
+	logger   *slog.Logger
+
+	mu      sync.Mutex
+	queue   []*Span
+	dropped int
+	failing bool
+
+	kick chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewOTLPExporter validates the endpoint and starts the background sender.
+func NewOTLPExporter(opts OTLPOptions) (*OTLPExporter, error) {
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultEndpoint
+	}
+	if err := ValidateEndpoint(endpoint); err != nil {
+		return nil, err
+	}
+	logger := opts.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	e := &OTLPExporter{
+		endpoint: endpoint,
+		resource: otlpResource{Attributes: []otlpKeyValue{
+			attr("service.name", opts.ServiceName),
+			attr("service.version", opts.ServiceVersion),
+		}},
+		scope:  otlpScope{Name: opts.ServiceName, Version: opts.ServiceVersion},
+		client: &http.Client{Timeout: exportTimeout},
+		logger: logger,
+		kick:   make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+// ValidateEndpoint checks an OTLP/HTTP collector URL without contacting it.
+func ValidateEndpoint(endpoint string) error {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid OTLP endpoint: %w", err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid OTLP endpoint %q: want http(s)://host[:port]/path", endpoint)
+	}
+	return nil
+}
+
+// Export queues a finished span.
+func (e *OTLPExporter) Export(s *Span) {
+	e.mu.Lock()
+	if len(e.queue) >= maxQueue {
+		e.dropped++
+		e.mu.Unlock()
+		return
+	}
+	e.queue = append(e.queue, s)
+	full := len(e.queue) >= batchSize
+	e.mu.Unlock()
+
+	if full {
+		select {
+		case e.kick <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Shutdown sends any queued spans and stops the sender. It returns when
+// the final export finishes or ctx is done.
+func (e *OTLPExporter) Shutdown(ctx context.Context) error {
+	close(e.stop)
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-e.kick:
+		case <-e.stop:
+			e.flush()
+			return
+		}
+		e.flush()
+	}
+}
+
+// flush sends everything queued, one batch at a time. A failed batch is
+// dropped rather than retried: traces are diagnostic, and retrying would
+// only delay newer spans.
+func (e *OTLPExporter) flush() {
+	for {
+		e.mu.Lock()
+		n := min(len(e.queue), batchSize)
+		batch := e.queue[:n:n]
+		e.queue = e.queue[n:]
+		dropped := e.dropped
+		e.dropped = 0
+		e.mu.Unlock()
+
+		if dropped > 0 {
+			e.logger.Warn("trace queue full, spans dropped", slog.Int("count", dropped))
+		}
+		if n == 0 {
+			return
+		}
+		e.report(e.send(batch))
+	}
+}
+
+// report logs collector state changes rather than every failure.
+func (e *OTLPExporter) report(err error) {
+	e.mu.Lock()
+	wasFailing := e.failing
+	e.failing = err != nil
+	e.mu.Unlock()
+
+	switch {
+	case err != nil && !wasFailing:
+		e.logger.Warn("exporting traces failed",
+			slog.String("endpoint", e.endpoint),
+			slog.String("error", err.Error()))
+	case err == nil && wasFailing:
+		e.logger.Info("exporting traces recovered", slog.String("endpoint", e.endpoint))
+	}
+}
+
+func (e *OTLPExporter) send(batch []*Span) error {
+	body, err := json.Marshal(e.request(batch))
+	if err != nil {
+		return fmt.Errorf("encoding spans: %w", err)
+	}
+	resp, err := e.client.Post(e.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP/HTTP JSON encoding, see
+// https://opentelemetry.io/docs/specs/otlp/#json-protobuf-encoding. IDs are
+// hex strings and 64-bit integers are decimal strings.
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    StatusCode `json:"code,omitempty"`
+	Message string     `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+func (e *OTLPExporter) request(batch []*Span) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		spans = append(spans, encodeSpan(s))
+	}
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   e.resource,
+		ScopeSpans: []otlpScopeSpans{{Scope: e.scope, Spans: spans}},
+	}}}
+}
+
+func encodeSpan(s *Span) otlpSpan {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := otlpSpan{
+		TraceID:           hex.EncodeToString(s.Context.TraceID[:]),
+		SpanID:            hex.EncodeToString(s.Context.SpanID[:]),
+		Name:              s.Name,
+		Kind:              s.Kind,
+		StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+		EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+		Status:            otlpStatus{Code: s.Status, Message: s.StatusMessage},
+	}
+	if s.Parent != [8]byte{} {
+		out.ParentSpanID = hex.EncodeToString(s.Parent[:])
+	}
+	for _, k := range sortedAttrKeys(s.Attrs) {
+		out.Attributes = append(out.Attributes, attr(k, s.Attrs[k]))
+	}
+	return out
+}
+
+func attr(key string, v any) otlpKeyValue {
+	kv := otlpKeyValue{Key: key}
+	switch v := v.(type) {
+	case string:
+		kv.Value.StringValue = &v
+	case bool:
+		kv.Value.BoolValue = &v
+	case int:
+		s := strconv.Itoa(v)
+		kv.Value.IntValue = &s
+	case int32:
+		s := strconv.FormatInt(int64(v), 10)
+		kv.Value.IntValue = &s
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		kv.Value.IntValue = &s
+	case uint32:
+		s := strconv.FormatUint(uint64(v), 10)
+		kv.Value.IntValue = &s
+	case float64:
+		kv.Value.DoubleValue = &v
+	default:
+		s := fmt.Sprint(v)
+		kv.Value.StringValue = &s
+	}
+	return kv
+}
+
+func sortedAttrKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
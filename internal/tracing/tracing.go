@@ -0,0 +1,211 @@
+// Package tracing is a minimal OpenTelemetry-compatible tracer: W3C trace
+// context propagation (https://www.w3.org/TR/trace-context/) and spans
+// exported over OTLP/HTTP. A nil *Tracer and nil *Span are valid no-ops, so
+// callers need not check whether tracing is enabled.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SpanContext identifies a span within a trace.
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+	Sampled bool
+}
+
+// IsValid reports whether both IDs are non-zero.
+func (sc SpanContext) IsValid() bool {
+	return sc.TraceID != [16]byte{} && sc.SpanID != [8]byte{}
+}
+
+// Traceparent formats the context as a W3C traceparent header value.
+func (sc SpanContext) Traceparent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + hex.EncodeToString(sc.TraceID[:]) + "-" + hex.EncodeToString(sc.SpanID[:]) + "-" + flags
+}
+
+// ParseTraceparent parses a W3C traceparent header value. Future versions
+// are accepted as long as the version 00 fields parse, as the spec requires.
+func ParseTraceparent(s string) (SpanContext, error) {
+	var sc SpanContext
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	version, traceID, spanID, flags := parts[0], parts[1], parts[2], parts[3]
+	if len(version) != 2 || version == "ff" || (version == "00" && len(parts) != 4) {
+		return sc, fmt.Errorf("invalid traceparent version in %q", s)
+	}
+	if len(traceID) != 32 || len(spanID) != 16 || len(flags) != 2 || strings.ToLower(s) != s {
+		return sc, fmt.Errorf("invalid traceparent %q", s)
+	}
+	if _, err := hex.Decode(sc.TraceID[:], []byte(traceID)); err != nil {
+		return sc, fmt.Errorf("invalid trace ID in %q", s)
+	}
+	if _, err := hex.Decode(sc.SpanID[:], []byte(spanID)); err != nil {
+		return sc, fmt.Errorf("invalid span ID in %q", s)
+	}
+	var f [1]byte
+	if _, err := hex.Decode(f[:], []byte(flags)); err != nil {
+		return sc, fmt.Errorf("invalid trace flags in %q", s)
+	}
+	sc.Sampled = f[0]&1 == 1
+	if !sc.IsValid() {
+		return sc, fmt.Errorf("invalid traceparent %q: zero trace or span ID", s)
+	}
+	return sc, nil
+}
+
+// SpanKind mirrors the OTLP span kinds used here.
+type SpanKind int
+
+const (
+	KindInternal SpanKind = 1
+	KindServer   SpanKind = 2
+)
+
+// Exporter receives finished spans.
+type Exporter interface {
+	Export(span *Span)
+}
+
+// Tracer creates spans and hands finished ones to an exporter.
+type Tracer struct {
+	exporter Exporter
+}
+
+// New returns a Tracer exporting to e.
+func New(e Exporter) *Tracer {
+	return &Tracer{exporter: e}
+}
+
+// Start begins a span. If parent is valid the span joins its trace and
+// inherits its sampling decision; otherwise a new, sampled trace starts.
+// start may be zero to mean now, or earlier to record work that happened
+// before the span could be created (e.g. before the parent was known).
+func (t *Tracer) Start(parent SpanContext, name string, kind SpanKind, start time.Time) *Span {
+	if t == nil {
+		return nil
+	}
+	if start.IsZero() {
+		start = time.Now()
+	}
+	s := &Span{
+		tracer: t,
+		Name:   name,
+		Kind:   kind,
+		Start:  start,
+		Attrs:  map[string]any{},
+	}
+	if parent.IsValid() {
+		s.Context.TraceID = parent.TraceID
+		s.Context.Sampled = parent.Sampled
+		s.Parent = parent.SpanID
+	} else {
+		_, _ = rand.Read(s.Context.TraceID[:])
+		s.Context.Sampled = true
+	}
+	_, _ = rand.Read(s.Context.SpanID[:])
+	return s
+}
+
+// StatusCode is the span status.
+type StatusCode int
+
+const (
+	StatusUnset StatusCode = 0
+	StatusOK    StatusCode = 1
+	StatusError StatusCode = 2
+)
+
+// Span is a timed operation. Its exported fields must not be modified
+// after End.
+type Span struct {
+	tracer *Tracer
+
+	Context       SpanContext
+	Parent        [8]byte
+	Name          string
+	Kind          SpanKind
+	Start         time.Time
+	End           time.Time
+	Status        StatusCode
+	StatusMessage string
+
+	mu    sync.Mutex
+	Attrs map[string]any
+	ended bool
+}
+
+// SpanContext returns the span's context, or the zero value for a nil span.
+func (s *Span) SpanContext() SpanContext {
+	if s == nil {
+		return SpanContext{}
+	}
+	return s.Context
+}
+
+// Child starts a span under s.
+func (s *Span) Child(name string, start time.Time) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.Start(s.Context, name, KindInternal, start)
+}
+
+// SetAttr sets an attribute. Values should be string, bool, int, int64 or
+// float64.
+func (s *Span) SetAttr(key string, value any) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Attrs[key] = value
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed.
+func (s *Span) SetError(msg string) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	s.Status = StatusError
+	s.StatusMessage = msg
+	s.mu.Unlock()
+}
+
+// Finish ends the span now and exports it if sampled. Further calls are
+// ignored.
+func (s *Span) Finish() {
+	s.FinishAt(time.Now())
+}
+
+// FinishAt ends the span at the given time.
+func (s *Span) FinishAt(end time.Time) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.End = end
+	s.mu.Unlock()
+
+	if s.Context.Sampled && s.tracer.exporter != nil {
+		s.tracer.exporter.Export(s)
+	}
+}
@@ -0,0 +1,179 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseTraceparent(t *testing.T) {
+	const valid = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceparent(valid)
+	if err != nil {
+		t.Fatalf("ParseTraceparent: %v", err)
+	}
+	if !sc.Sampled || !sc.IsValid() {
+		t.Errorf("unexpected context %+v", sc)
+	}
+	if got := sc.Traceparent(); got != valid {
+		t.Errorf("round trip = %q, want %q", got, valid)
+	}
+
+	sc, err = ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if err != nil || sc.Sampled {
+		t.Errorf("expected unsampled context, got %+v, %v", sc, err)
+	}
+
+	// Future versions may append fields.
+	if _, err := ParseTraceparent("cc-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); err != nil {
+		t.Errorf("future version rejected: %v", err)
+	}
+
+	for _, s := range []string{
+		"",
+		"garbage",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e473-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902bz-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-0x",
+	} {
+		if _, err := ParseTraceparent(s); err == nil {
+			t.Errorf("ParseTraceparent(%q) succeeded, want error", s)
+		}
+	}
+}
+
+type memoryExporter struct {
+	spans []*Span
+}
+
+func (m *memoryExporter) Export(s *Span) { m.spans = append(m.spans, s) }
+
+func TestTracer(t *testing.T) {
+	exp := &memoryExporter{}
+	tr := New(exp)
+
+	root := tr.Start(SpanContext{}, "root", KindServer, time.Time{})
+	if !root.Context.IsValid() || !root.Context.Sampled || root.Parent != [8]byte{} {
+		t.Errorf("expected a new sampled trace, got %+v", root.Context)
+	}
+	child := root.Child("child", time.Time{})
+	if child.Context.TraceID != root.Context.TraceID || child.Parent != root.Context.SpanID {
+		t.Error("child does not belong to the root's trace")
+	}
+	child.Finish()
+	child.Finish()
+	root.Finish()
+	if len(exp.spans) != 2 {
+		t.Fatalf("expected 2 exported spans, got %d", len(exp.spans))
+	}
+
+	unsampled, _ := ParseTraceparent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	tr.Start(unsampled, "dropped", KindServer, time.Time{}).Finish()
+	if len(exp.spans) != 2 {
+		t.Error("unsampled span was exported")
+	}
+}
+
+func TestNilTracer(t *testing.T) {
+	var tr *Tracer
+	s := tr.Start(SpanContext{}, "x", KindServer, time.Time{})
+	s.SetAttr("k", "v")
+	s.SetError("boom")
+	s.Child("y", time.Time{}).Finish()
+	s.Finish()
+	if s.SpanContext().IsValid() {
+		t.Error("expected invalid context from a nil span")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var mu sync.Mutex
+	var bodies []otlpRequest
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("unexpected request %s %s", r.URL.Path, r.Header.Get("Content-Type"))
+		}
+		data, _ := io.ReadAll(r.Body)
+		var req otlpRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			t.Errorf("invalid body: %v", err)
+		}
+		mu.Lock()
+		bodies = append(bodies, req)
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	exp, err := NewOTLPExporter(OTLPOptions{
+		Endpoint:       srv.URL + "/v1/traces",
+		ServiceName:    "svc",
+		ServiceVersion: "1.2.3",
+		Logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := New(exp)
+	root := tr.Start(SpanContext{}, "request", KindServer, time.Unix(1, 0))
+	child := root.Child("execute", time.Unix(1, 5))
+	child.SetAttr("vito.exit_code", 2)
+	child.SetError("failed")
+	child.FinishAt(time.Unix(2, 0))
+	root.FinishAt(time.Unix(3, 0))
+
+	if err := exp.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bodies) != 1 {
+		t.Fatalf("expected 1 export, got %d", len(bodies))
+	}
+	rs := bodies[0].ResourceSpans[0]
+	if *rs.Resource.Attributes[0].Value.StringValue != "svc" {
+		t.Errorf("unexpected resource %+v", rs.Resource)
+	}
+	spans := rs.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("expected 2 spans, got %d", len(spans))
+	}
+	got := spans[0]
+	if got.Name != "execute" || got.ParentSpanID != spans[1].SpanID || got.TraceID != spans[1].TraceID {
+		t.Errorf("unexpected span %+v", got)
+	}
+	if got.StartTimeUnixNano != "1000000005" || got.EndTimeUnixNano != "2000000000" {
+		t.Errorf("unexpected times %s..%s", got.StartTimeUnixNano, got.EndTimeUnixNano)
+	}
+	if got.Status.Code != StatusError || got.Status.Message != "failed" {
+		t.Errorf("unexpected status %+v", got.Status)
+	}
+	if len(got.Attributes) != 1 || got.Attributes[0].Key != "vito.exit_code" || *got.Attributes[0].Value.IntValue != "2" {
+		t.Errorf("unexpected attributes %+v", got.Attributes)
+	}
+	if spans[1].ParentSpanID != "" || spans[1].Kind != KindServer {
+		t.Errorf("unexpected root span %+v", spans[1])
+	}
+}
+
+func TestOTLPExporterRejectsBadEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"127.0.0.1:4318", "ftp://host/v1/traces", "http:///v1/traces"} {
+		if _, err := NewOTLPExporter(OTLPOptions{Endpoint: endpoint}); err == nil {
+			t.Errorf("NewOTLPExporter(%q) succeeded, want error", endpoint)
+		}
+		if err := ValidateEndpoint(endpoint); err == nil {
+			t.Errorf("ValidateEndpoint(%q) succeeded, want error", endpoint)
+		}
+	}
+}
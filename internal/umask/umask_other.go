@@ -0,0 +1,9 @@
+//go:build !unix
+
+// Package umask changes the process umask around file creation.
+package umask
+
+// With runs fn; there is no umask on this platform.
+func With(_ int, fn func() error) error {
+	return fn()
+}
@@ -0,0 +1,23 @@
+//go:build unix
+
+// Package umask changes the process umask around file creation.
+package umask
+
+import (
+	"sync"
+	"syscall"
+)
+
+// mu serializes umask changes, which apply to the whole process.
+var mu sync.Mutex
+
+// With runs fn with the process umask set to mask. The umask is shared by
+// every thread, including commands forked meanwhile, so callers create
+// files this way only before commands run.
+func With(mask int, fn func() error) error {
+	mu.Lock()
+	defer mu.Unlock()
+	old := syscall.Umask(mask)
+	defer syscall.Umask(old)
+	return fn()
+}
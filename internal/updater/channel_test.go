@@ -0,0 +1,138 @@
+package updater
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"vito-local/internal/channel"
+)
+
+// releaseServer serves releases the way the GitHub API does: the latest
+// stable release, each release by tag, and the list of releases.
+func releaseServer(t *testing.T, latest string, releases []Release) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/releases/latest":
+			json.NewEncoder(w).Encode(Release{TagName: latest})
+		case r.URL.Path == "/releases":
+			json.NewEncoder(w).Encode(releases)
+		case strings.HasPrefix(r.URL.Path, "/releases/tags/"):
+			tag := strings.TrimPrefix(r.URL.Path, "/releases/tags/")
+			for _, rel := range releases {
+				if rel.TagName == tag {
+					json.NewEncoder(w).Encode(rel)
+					return
+				}
+			}
+			http.NotFound(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestGitHubClient_GetRelease(t *testing.T) {
+	server := releaseServer(t, "v1.5.2", []Release{
+		{TagName: "v1.7.0", Draft: true},
+		{TagName: "v1.6.0-beta.10", Prerelease: true},
+		{TagName: "v1.6.0-beta.2", Prerelease: true},
+		{TagName: "v1.5.2"},
+		{TagName: "v1.4.10"},
+		{TagName: "v1.4.9"},
+		{TagName: "v1.3.0"},
+	})
+	client := NewGitHubClientForRepo(server.URL)
+
+	tests := []struct {
+		channel string
+		want    string
+	}{
+		{"stable", "v1.5.2"},
+		{"beta", "v1.6.0-beta.10"},
+		{"~1.4", "v1.4.10"},
+		{"^1.3", "v1.5.2"},
+		{"v1.4.9", "v1.4.9"},
+	}
+	for _, tt := range tests {
+		ch, err := channel.Parse(tt.channel)
+		if err != nil {
+			t.Fatal(err)
+		}
+		release, err := client.GetRelease(ch)
+		if err != nil {
+			t.Errorf("GetRelease(%s): %v", tt.channel, err)
+			continue
+		}
+		if release.TagName != tt.want {
+			t.Errorf("GetRelease(%s) = %s, want %s", tt.channel, release.TagName, tt.want)
+		}
+	}
+
+	for _, spec := range []string{"~2.0", "v1.4.8"} {
+		ch, _ := channel.Parse(spec)
+		if _, err := client.GetRelease(ch); err == nil {
+			t.Errorf("GetRelease(%s) should fail", spec)
+		}
+	}
+}
+
+func TestNewGitHubClientWithURL(t *testing.T) {
+	server := releaseServer(t, "v1.5.2", []Release{
+		{TagName: "v1.6.0-beta.1", Prerelease: true},
+		{TagName: "v1.5.2"},
+	})
+	beta, _ := channel.Parse("beta")
+
+	// A latest release URL of a repository also finds its other releases.
+	client := NewGitHubClientWithURL(server.URL + "/releases/latest")
+	if release, err := client.GetRelease(channel.Channel{}); err != nil || release.TagName != "v1.5.2" {
+		t.Errorf("GetRelease(stable) = %+v, %v, want v1.5.2", release, err)
+	}
+	if release, err := client.GetRelease(beta); err != nil || release.TagName != "v1.6.0-beta.1" {
+		t.Errorf("GetRelease(beta) = %+v, %v, want v1.6.0-beta.1", release, err)
+	}
+
+	// Any other URL serves the stable channel only.
+	client = NewGitHubClientWithURL(server.URL + "/mirror/latest.json")
+	if _, err := client.GetRelease(beta); err == nil || !strings.Contains(err.Error(), "no repository API URL") {
+		t.Errorf("GetRelease(beta) = %v, want an error naming the missing repository URL", err)
+	}
+}
+
+func TestUpdater_CheckUpdate_Channel(t *testing.T) {
+	server := releaseServer(t, "v1.5.2", []Release{
+		{TagName: "v1.6.0-beta.1", Prerelease: true},
+		{TagName: "v1.5.2"},
+		{TagName: "v1.4.9"},
+	})
+
+	tests := []struct {
+		current, channel string
+		status, latest   string
+	}{
+		{"v1.4.9", "~1.4", "current", "v1.4.9"},
+		{"v1.4.9", "beta", "available", "v1.6.0-beta.1"},
+		{"v1.6.0-beta.1", "stable", "current", "v1.5.2"},
+		// A pin moves to its version in either direction.
+		{"v1.5.2", "v1.4.9", "available", "v1.4.9"},
+		{"v1.4.9", "v1.4.9", "current", "v1.4.9"},
+	}
+	for _, tt := range tests {
+		u := NewWithGitHubClient(tt.current, "/usr/local/bin/vito-root-service", NewGitHubClientForRepo(server.URL))
+		u.Channel, _ = channel.Parse(tt.channel)
+		result, err := u.CheckUpdate()
+		if err != nil {
+			t.Errorf("%s on %s: %v", tt.current, tt.channel, err)
+			continue
+		}
+		if result.Status != tt.status || result.LatestVersion != tt.latest {
+			t.Errorf("%s on %s = %s %s, want %s %s", tt.current, tt.channel, result.Status, result.LatestVersion, tt.status, tt.latest)
+		}
+	}
+}
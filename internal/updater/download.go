@@ -2,8 +2,11 @@ package updater
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io"
 	"net/http"
@@ -15,12 +18,22 @@ import (
 const (
 	// minBinarySize is the minimum expected size for the binary (100KB)
 	minBinarySize = 100 * 1024
+
+	// maxSmallFileSize bounds signature and checksum downloads.
+	maxSmallFileSize = 64 * 1024
 )
 
 // Downloader handles downloading and extracting update binaries.
 type Downloader struct {
 	httpClient *http.Client
 	tempDir    string
+
+	// SHA256, when set, is the expected digest of the archive. It is
+	// checked while the archive streams to disk.
+	SHA256 []byte
+	// Verify, when set, is called with the downloaded archive before
+	// anything is extracted from it; an error aborts the download.
+	Verify func(archivePath string) error
 }
 
 // NewDownloader creates a new Downloader.
@@ -47,6 +60,11 @@ func (d *Downloader) DownloadAndExtract(ctx context.Context, url, binaryName str
 	if err := d.downloadFile(ctx, url, tarballPath); err != nil {
 		return "", fmt.Errorf("downloading tarball: %w", err)
 	}
+	if d.Verify != nil {
+		if err := d.Verify(tarballPath); err != nil {
+			return "", fmt.Errorf("verifying tarball: %w", err)
+		}
+	}
 
 	// Extract the binary
 	binaryPath, err := d.extractBinary(tarballPath, binaryName, tempDir)
@@ -81,15 +99,73 @@ func (d *Downloader) downloadFile(ctx context.Context, url, destPath string) err
 	}
 	defer out.Close()
 
-	// Use a context-aware copy by wrapping the response body
-	_, err = io.Copy(out, readerWithContext(ctx, resp.Body))
+	// Use a context-aware copy by wrapping the response body, hashing
+	// the archive as it is written.
+	h := sha256.New()
+	_, err = io.Copy(io.MultiWriter(out, h), readerWithContext(ctx, resp.Body))
 	if err != nil {
 		return fmt.Errorf("writing file: %w", err)
 	}
 
+	if d.SHA256 != nil {
+		if sum := h.Sum(nil); !bytes.Equal(sum, d.SHA256) {
+			os.Remove(destPath)
+			return fmt.Errorf("checksum mismatch: got sha256 %x, expected %x", sum, d.SHA256)
+		}
+	}
+
 	return nil
 }
 
+// parseChecksum returns the SHA-256 digest for name from a checksum file:
+// either a SHA256SUMS-style manifest ("<hex>  <name>" per line, with an
+// optional "*" before binary names) or a file holding just the digest.
+func parseChecksum(data []byte, name string) ([]byte, error) {
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	for _, line := range lines {
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 1 && len(lines) == 1:
+		case len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name:
+		default:
+			continue
+		}
+		sum, err := hex.DecodeString(fields[0])
+		if err != nil || len(sum) != sha256.Size {
+			return nil, fmt.Errorf("invalid sha256 digest %q for %s", fields[0], name)
+		}
+		return sum, nil
+	}
+	return nil, fmt.Errorf("no checksum listed for %s", name)
+}
+
+// Fetch downloads a small file, such as a signature, into memory.
+func (d *Downloader) Fetch(ctx context.Context, url string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("creating request: %w", err)
+	}
+
+	resp, err := d.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP GET: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download returned status %d", resp.StatusCode)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxSmallFileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("reading response: %w", err)
+	}
+	if len(data) > maxSmallFileSize {
+		return nil, fmt.Errorf("file larger than %d bytes", maxSmallFileSize)
+	}
+	return data, nil
+}
+
 // readerWithContext wraps a reader to respect context cancellation.
 type contextReader struct {
 	ctx context.Context
@@ -207,6 +283,7 @@ func ValidateBinary(path string) error {
 
 // AtomicReplace atomically replaces the target file with the source file.
 // It first copies to a temporary location next to the target, then renames.
+// An existing target is kept as its .prev backup.
 func AtomicReplace(srcPath, targetPath string) error {
 	// Validate source
 	if err := ValidateBinary(srcPath); err != nil {
@@ -259,6 +336,12 @@ func AtomicReplace(srcPath, targetPath string) error {
 		if stat, ok := getFileStat(targetInfo); ok {
 			_ = os.Chown(tempPath, int(stat.uid), int(stat.gid))
 		}
+
+		// Without a backup a failed start could not be rolled back.
+		if err := keepPrevious(targetPath); err != nil {
+			os.Remove(tempPath)
+			return fmt.Errorf("keeping previous binary: %w", err)
+		}
 	}
 
 	// Atomic rename
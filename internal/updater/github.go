@@ -4,20 +4,32 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"net/url"
 	"runtime"
 	"strings"
 	"time"
+
+	"vito-local/internal/channel"
 )
 
 const (
-	defaultGitHubAPIURL = "https://api.github.com/repos/RichardAnderson/vito-local/releases/latest"
-	defaultHTTPTimeout  = 30 * time.Second
+	defaultGitHubRepoURL = "https://api.github.com/repos/RichardAnderson/vito-local"
+	defaultGitHubAPIURL  = defaultGitHubRepoURL + latestReleasePath
+	defaultHTTPTimeout   = 30 * time.Second
+	// latestReleasePath is the latest release endpoint below a repository
+	// API URL.
+	latestReleasePath = "/releases/latest"
+	// releasesPerPage is how many recent releases are searched for a
+	// channel other than stable; GitHub allows at most 100.
+	releasesPerPage = 100
 )
 
 // Release represents a GitHub release.
 type Release struct {
-	TagName string  `json:"tag_name"`
-	Assets  []Asset `json:"assets"`
+	TagName    string  `json:"tag_name"`
+	Prerelease bool    `json:"prerelease"`
+	Draft      bool    `json:"draft"`
+	Assets     []Asset `json:"assets"`
 }
 
 // Asset represents a downloadable asset in a GitHub release.
@@ -28,24 +40,45 @@ type Asset struct {
 
 // GitHubClient fetches release information from GitHub.
 type GitHubClient struct {
-	apiURL     string
+	// apiURL is the latest release endpoint.
+	apiURL string
+	// repoURL is the repository API URL other releases are looked up
+	// under, or empty if it is not known.
+	repoURL    string
 	httpClient *http.Client
 }
 
 // NewGitHubClient creates a new GitHub client with default settings.
 func NewGitHubClient() *GitHubClient {
+	return NewGitHubClientForRepo(defaultGitHubRepoURL)
+}
+
+// NewGitHubClientWithURL creates a new GitHub client with a custom API URL
+// for the latest release, such as
+// https://api.github.com/repos/OWNER/REPO/releases/latest. Channels other
+// than stable need the repository's other releases, which are found only
+// when apiURL ends in /releases/latest; see NewGitHubClientForRepo.
+func NewGitHubClientWithURL(apiURL string) *GitHubClient {
+	repoURL, _ := strings.CutSuffix(apiURL, latestReleasePath)
+	if repoURL == apiURL {
+		repoURL = ""
+	}
 	return &GitHubClient{
-		apiURL: defaultGitHubAPIURL,
+		apiURL:  apiURL,
+		repoURL: repoURL,
 		httpClient: &http.Client{
 			Timeout: defaultHTTPTimeout,
 		},
 	}
 }
 
-// NewGitHubClientWithURL creates a new GitHub client with a custom API URL.
-func NewGitHubClientWithURL(apiURL string) *GitHubClient {
+// NewGitHubClientForRepo creates a new GitHub client for a custom
+// repository API URL, such as https://api.github.com/repos/OWNER/REPO.
+func NewGitHubClientForRepo(repoURL string) *GitHubClient {
+	repoURL = strings.TrimSuffix(repoURL, "/")
 	return &GitHubClient{
-		apiURL: apiURL,
+		apiURL:  repoURL + latestReleasePath,
+		repoURL: repoURL,
 		httpClient: &http.Client{
 			Timeout: defaultHTTPTimeout,
 		},
@@ -54,29 +87,94 @@ func NewGitHubClientWithURL(apiURL string) *GitHubClient {
 
 // GetLatestRelease fetches the latest release from GitHub.
 func (g *GitHubClient) GetLatestRelease() (*Release, error) {
-	req, err := http.NewRequest(http.MethodGet, g.apiURL, nil)
+	var release Release
+	if err := g.get(g.apiURL, &release); err != nil {
+		return nil, err
+	}
+	return &release, nil
+}
+
+// GetReleaseByTag fetches the release tagged tag.
+func (g *GitHubClient) GetReleaseByTag(tag string) (*Release, error) {
+	var release Release
+	if err := g.getRepo("/releases/tags/"+url.PathEscape(tag), &release); err != nil {
+		return nil, fmt.Errorf("release %s: %w", tag, err)
+	}
+	return &release, nil
+}
+
+// ListReleases fetches the most recent releases, newest first.
+func (g *GitHubClient) ListReleases() ([]Release, error) {
+	var releases []Release
+	if err := g.getRepo(fmt.Sprintf("/releases?per_page=%d", releasesPerPage), &releases); err != nil {
+		return nil, err
+	}
+	return releases, nil
+}
+
+// GetRelease fetches the release ch selects: the latest release for
+// stable, the pinned release, or the highest version among recent releases
+// the channel allows.
+func (g *GitHubClient) GetRelease(ch channel.Channel) (*Release, error) {
+	if ch.Latest() {
+		return g.GetLatestRelease()
+	}
+	if tag := ch.Pinned(); tag != "" {
+		return g.GetReleaseByTag(tag)
+	}
+
+	releases, err := g.ListReleases()
 	if err != nil {
-		return nil, fmt.Errorf("creating request: %w", err)
+		return nil, err
+	}
+	var best *Release
+	for i := range releases {
+		r := &releases[i]
+		if r.Draft || !ch.Allows(r.TagName, r.Prerelease) {
+			continue
+		}
+		if best == nil || channel.Compare(r.TagName, best.TagName) > 0 {
+			best = r
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("no release matches update channel %s", ch)
+	}
+	return best, nil
+}
+
+// getRepo fetches path below the repository API URL and decodes the JSON
+// response into v.
+func (g *GitHubClient) getRepo(path string, v any) error {
+	if g.repoURL == "" {
+		return fmt.Errorf("no repository API URL to look up releases other than the latest under %s", g.apiURL)
+	}
+	return g.get(g.repoURL+path, v)
+}
+
+// get fetches rawURL and decodes the JSON response into v.
+func (g *GitHubClient) get(rawURL string, v any) error {
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return fmt.Errorf("creating request: %w", err)
 	}
 	req.Header.Set("Accept", "application/vnd.github.v3+json")
 	req.Header.Set("User-Agent", "vito-root-service")
 
 	resp, err := g.httpClient.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("fetching release: %w", err)
+		return fmt.Errorf("fetching release: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+		return fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
 	}
 
-	var release Release
-	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
-		return nil, fmt.Errorf("decoding release: %w", err)
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("decoding release: %w", err)
 	}
-
-	return &release, nil
+	return nil
 }
 
 // FindAssetForPlatform finds the appropriate asset for the current platform.
@@ -121,3 +219,31 @@ func (g *GitHubClient) FindAssetForPlatform(release *Release) (*Asset, error) {
 
 	return nil, fmt.Errorf("no asset found for %s/%s", os, arch)
 }
+
+// checksumManifests are the release-wide checksum files, in order of
+// preference. A per-asset "<asset>.sha256" file is preferred over both.
+var checksumManifests = []string{"SHA256SUMS", "checksums.txt"}
+
+// FindChecksumAsset finds the file holding the SHA-256 digest of asset:
+// "<asset>.sha256", or a SHA256SUMS-style manifest covering every asset.
+func (g *GitHubClient) FindChecksumAsset(release *Release, asset *Asset) (*Asset, error) {
+	for _, want := range append([]string{asset.Name + ".sha256"}, checksumManifests...) {
+		for _, a := range release.Assets {
+			if a.Name == want {
+				return &a, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("release has no %s.sha256 or %s", asset.Name, strings.Join(checksumManifests, " or "))
+}
+
+// FindSignatureAsset finds the detached signature published for asset.
+func (g *GitHubClient) FindSignatureAsset(release *Release, asset *Asset) (*Asset, error) {
+	want := asset.Name + SignatureSuffix
+	for _, a := range release.Assets {
+		if a.Name == want {
+			return &a, nil
+		}
+	}
+	return nil, fmt.Errorf("release has no signature %s", want)
+}
@@ -0,0 +1,126 @@
+package updater
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Files kept next to the binary while an update is applied. The service
+// unit's ExecStartPre guard reads them with a plain shell, so that a
+// release that cannot start at all is still rolled back:
+//
+//   - <binary>.prev is the previously installed binary.
+//   - <binary>.pending marks an update the new version has not confirmed.
+//   - <binary>.attempted is created by the guard when it first starts the
+//     new version. If it already exists, the previous start failed and the
+//     guard restores <binary>.prev instead. A version stopped cleanly
+//     before confirming removes it, so the next start tries it again.
+const (
+	PrevSuffix      = ".prev"
+	PendingSuffix   = ".pending"
+	AttemptedSuffix = ".attempted"
+)
+
+// PrevPath returns where the previous binary is kept.
+func PrevPath(binaryPath string) string {
+	return binaryPath + PrevSuffix
+}
+
+// PendingUpdate describes an update that has been applied but not yet
+// confirmed by the new version.
+type PendingUpdate struct {
+	FromVersion string
+	ToVersion   string
+}
+
+// MarkPending records that binaryPath was just replaced and must confirm
+// that it starts, clearing any earlier start attempt.
+func MarkPending(binaryPath string, p PendingUpdate) error {
+	_ = os.Remove(binaryPath + AttemptedSuffix)
+	data := fmt.Sprintf("from=%s\nto=%s\n", p.FromVersion, p.ToVersion)
+	if err := os.WriteFile(binaryPath+PendingSuffix, []byte(data), 0600); err != nil {
+		return fmt.Errorf("recording pending update: %w", err)
+	}
+	return nil
+}
+
+// Pending returns the unconfirmed update for binaryPath, or nil when there
+// is none.
+func Pending(binaryPath string) (*PendingUpdate, error) {
+	data, err := os.ReadFile(binaryPath + PendingSuffix)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading pending update: %w", err)
+	}
+	p := &PendingUpdate{}
+	for _, line := range strings.Split(string(data), "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		switch key {
+		case "from":
+			p.FromVersion = value
+		case "to":
+			p.ToVersion = value
+		}
+	}
+	return p, nil
+}
+
+// ConfirmUpdate marks the pending update of binaryPath as good. The
+// previous binary stays in place for a manual rollback.
+func ConfirmUpdate(binaryPath string) error {
+	for _, suffix := range []string{PendingSuffix, AttemptedSuffix} {
+		if err := os.Remove(binaryPath + suffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("confirming update: %w", err)
+		}
+	}
+	return nil
+}
+
+// ResetAttempt removes the start attempt recorded for an unconfirmed
+// update of binaryPath. The service calls it when it stops cleanly before
+// confirming, which is no evidence that the new version is broken, so the
+// rollback guard tries it again on the next start.
+func ResetAttempt(binaryPath string) error {
+	if err := os.Remove(binaryPath + AttemptedSuffix); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("resetting update attempt: %w", err)
+	}
+	return nil
+}
+
+// keepPrevious preserves the binary at targetPath as its .prev backup. A
+// hard link keeps the old file intact when the new one is renamed over it;
+// where links are not possible the file is copied.
+func keepPrevious(targetPath string) error {
+	prevPath := PrevPath(targetPath)
+	if err := os.Remove(prevPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("removing old backup: %w", err)
+	}
+	if err := os.Link(targetPath, prevPath); err == nil {
+		return nil
+	}
+
+	src, err := os.Open(targetPath)
+	if err != nil {
+		return fmt.Errorf("opening current binary: %w", err)
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("stat current binary: %w", err)
+	}
+	dst, err := os.OpenFile(prevPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, info.Mode())
+	if err != nil {
+		return fmt.Errorf("creating backup: %w", err)
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(prevPath)
+		return fmt.Errorf("copying backup: %w", err)
+	}
+	return dst.Close()
+}
@@ -0,0 +1,89 @@
+package updater
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPendingUpdate(t *testing.T) {
+	binPath := filepath.Join(t.TempDir(), "vito-root-service")
+	if p, err := Pending(binPath); p != nil || err != nil {
+		t.Fatalf("Pending with no marker = %+v, %v", p, err)
+	}
+
+	// A marker left by the rollback guard from an earlier attempt is reset.
+	if err := os.WriteFile(binPath+AttemptedSuffix, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := MarkPending(binPath, PendingUpdate{FromVersion: "v1.0.0", ToVersion: "v1.1.0"}); err != nil {
+		t.Fatalf("MarkPending: %v", err)
+	}
+	if _, err := os.Stat(binPath + AttemptedSuffix); !os.IsNotExist(err) {
+		t.Error("MarkPending should clear an earlier start attempt")
+	}
+	p, err := Pending(binPath)
+	if err != nil || p == nil || p.FromVersion != "v1.0.0" || p.ToVersion != "v1.1.0" {
+		t.Fatalf("Pending = %+v, %v", p, err)
+	}
+
+	// A clean stop before confirming resets the attempt but keeps the
+	// update pending.
+	if err := os.WriteFile(binPath+AttemptedSuffix, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ResetAttempt(binPath); err != nil {
+		t.Fatalf("ResetAttempt: %v", err)
+	}
+	if _, err := os.Stat(binPath + AttemptedSuffix); !os.IsNotExist(err) {
+		t.Error("ResetAttempt should clear the start attempt")
+	}
+	if p, err := Pending(binPath); err != nil || p == nil {
+		t.Errorf("ResetAttempt should keep the update pending, got %+v, %v", p, err)
+	}
+
+	if err := os.WriteFile(binPath+AttemptedSuffix, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ConfirmUpdate(binPath); err != nil {
+		t.Fatalf("ConfirmUpdate: %v", err)
+	}
+	for _, suffix := range []string{PendingSuffix, AttemptedSuffix} {
+		if _, err := os.Stat(binPath + suffix); !os.IsNotExist(err) {
+			t.Errorf("%s marker left after confirming", suffix)
+		}
+	}
+	if err := ConfirmUpdate(binPath); err != nil {
+		t.Errorf("ConfirmUpdate without a pending update: %v", err)
+	}
+}
+
+func TestAtomicReplace_KeepsPrevious(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "vito-root-service")
+	if err := os.WriteFile(target, []byte("old"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	src := filepath.Join(dir, "new")
+	if err := os.WriteFile(src, make([]byte, minBinarySize+1), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := AtomicReplace(src, target); err != nil {
+		t.Fatalf("AtomicReplace: %v", err)
+	}
+	if prev, err := os.ReadFile(PrevPath(target)); err != nil || string(prev) != "old" {
+		t.Errorf("previous binary = %q, %v", prev, err)
+	}
+	if info, err := os.Stat(target); err != nil || info.Size() != minBinarySize+1 {
+		t.Errorf("target not replaced: %v", err)
+	}
+
+	// A second update replaces the backup with the binary it supersedes.
+	if err := AtomicReplace(src, target); err != nil {
+		t.Fatalf("AtomicReplace: %v", err)
+	}
+	if info, err := os.Stat(PrevPath(target)); err != nil || info.Size() != minBinarySize+1 {
+		t.Errorf("backup not refreshed: %v", err)
+	}
+}
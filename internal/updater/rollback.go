@@ -0,0 +1,82 @@
+package updater
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// versionTimeout bounds running a binary to ask for its version.
+const versionTimeout = 5 * time.Second
+
+// ErrNoPrevious is returned by Rollback when no previous binary is kept.
+var ErrNoPrevious = errors.New("no previous version to roll back to")
+
+// BinaryVersion runs the binary at path with -version and returns the
+// version it reports.
+func BinaryVersion(ctx context.Context, path string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, versionTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, path, "-version").Output()
+	if err != nil {
+		return "", fmt.Errorf("running %s -version: %w", path, err)
+	}
+	// Output is "vito-root-service <version>".
+	fields := strings.Fields(string(bytes.TrimSpace(out)))
+	if len(fields) == 0 {
+		return "", fmt.Errorf("%s -version printed nothing", path)
+	}
+	return fields[len(fields)-1], nil
+}
+
+// Rollback reinstates the previous binary kept by the last update. The
+// binary it replaces becomes the new backup, so a second rollback returns
+// to it. The caller restarts the service to run the reinstated version.
+func (u *Updater) Rollback(ctx context.Context) (*UpdateResult, error) {
+	failed := func(latest, format string, err error) (*UpdateResult, error) {
+		return &UpdateResult{
+			Status:         "failed",
+			CurrentVersion: u.CurrentVersion,
+			LatestVersion:  latest,
+			Message:        fmt.Sprintf(format, err),
+		}, err
+	}
+
+	prevPath := PrevPath(u.BinaryPath)
+	if _, err := os.Stat(prevPath); err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = ErrNoPrevious
+		}
+		return failed("", "rollback failed: %v", err)
+	}
+
+	// Running the previous binary both identifies it and shows that it
+	// still starts.
+	prevVersion, err := BinaryVersion(ctx, prevPath)
+	if err != nil {
+		return failed("", "previous binary is not usable: %v", err)
+	}
+
+	if err := AtomicReplace(prevPath, u.BinaryPath); err != nil {
+		return failed(prevVersion, "failed to replace binary: %v", err)
+	}
+
+	// An unconfirmed update must not have the rollback guard restore the
+	// version just rolled back from.
+	if err := ConfirmUpdate(u.BinaryPath); err != nil {
+		return failed(prevVersion, "binary replaced, but clearing the pending update failed: %v", err)
+	}
+
+	return &UpdateResult{
+		Status:         "applied",
+		CurrentVersion: u.CurrentVersion,
+		LatestVersion:  prevVersion,
+		Message:        fmt.Sprintf("rolled back from %s to %s", u.CurrentVersion, prevVersion),
+	}, nil
+}
@@ -0,0 +1,77 @@
+package updater
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeBinary writes an executable script that reports version, padded to
+// pass ValidateBinary.
+func fakeBinary(t *testing.T, path, version string) {
+	t.Helper()
+	script := "#!/bin/sh\necho vito-root-service " + version + "\nexit 0\n"
+	script += "#" + strings.Repeat(" ", minBinarySize) + "\n"
+	if err := os.WriteFile(path, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpdater_Rollback(t *testing.T) {
+	binPath := filepath.Join(t.TempDir(), "vito-root-service")
+	fakeBinary(t, binPath, "v1.1.0")
+	fakeBinary(t, PrevPath(binPath), "v1.0.0")
+	if err := MarkPending(binPath, PendingUpdate{FromVersion: "v1.0.0", ToVersion: "v1.1.0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	u := New("v1.1.0", binPath)
+	result, err := u.Rollback(context.Background())
+	if err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	if result.Status != "applied" || result.CurrentVersion != "v1.1.0" || result.LatestVersion != "v1.0.0" {
+		t.Errorf("result = %+v", result)
+	}
+	if v, err := BinaryVersion(context.Background(), binPath); err != nil || v != "v1.0.0" {
+		t.Errorf("installed binary reports %q, %v; want v1.0.0", v, err)
+	}
+	if v, err := BinaryVersion(context.Background(), PrevPath(binPath)); err != nil || v != "v1.1.0" {
+		t.Errorf("backup reports %q, %v; want the rolled back v1.1.0", v, err)
+	}
+	if p, err := Pending(binPath); p != nil || err != nil {
+		t.Errorf("pending update should be cleared, got %+v, %v", p, err)
+	}
+}
+
+func TestUpdater_Rollback_NoPrevious(t *testing.T) {
+	binPath := filepath.Join(t.TempDir(), "vito-root-service")
+	fakeBinary(t, binPath, "v1.1.0")
+
+	result, err := New("v1.1.0", binPath).Rollback(context.Background())
+	if !errors.Is(err, ErrNoPrevious) {
+		t.Errorf("err = %v, want ErrNoPrevious", err)
+	}
+	if result.Status != "failed" {
+		t.Errorf("status = %q, want failed", result.Status)
+	}
+}
+
+func TestUpdater_Rollback_UnusablePrevious(t *testing.T) {
+	binPath := filepath.Join(t.TempDir(), "vito-root-service")
+	fakeBinary(t, binPath, "v1.1.0")
+	if err := os.WriteFile(PrevPath(binPath), []byte("#!/bin/sh\nexit 1\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := New("v1.1.0", binPath).Rollback(context.Background())
+	if err == nil || result.Status != "failed" || !strings.Contains(result.Message, "not usable") {
+		t.Errorf("Rollback = %+v, %v; want the previous binary refused", result, err)
+	}
+	if v, _ := BinaryVersion(context.Background(), binPath); v != "v1.1.0" {
+		t.Errorf("installed binary changed to %q", v)
+	}
+}
@@ -0,0 +1,96 @@
+package updater
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// signingKey is the base64-encoded Ed25519 public key that release archives
+// are signed with. It is set at build time:
+//
+//	go build -ldflags "-X vito-local/internal/updater.signingKey=<key>"
+//
+// Builds without it cannot self-update.
+var signingKey string
+
+// SignatureSuffix is appended to an archive's asset name to find its
+// detached signature: the base64-encoded Ed25519 signature of the archive's
+// release manifest (see Manifest).
+const SignatureSuffix = ".sig"
+
+// ErrNoSigningKey is returned when the binary was built without a signing
+// key, so no download can be verified.
+var ErrNoSigningKey = errors.New("no update signing key compiled into this build")
+
+// DefaultPublicKey returns the public key compiled into the binary.
+func DefaultPublicKey() (ed25519.PublicKey, error) {
+	if signingKey == "" {
+		return nil, ErrNoSigningKey
+	}
+	return ParsePublicKey(signingKey)
+}
+
+// ParsePublicKey decodes a base64-encoded Ed25519 public key.
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("decoding signing key: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("signing key is %d bytes, expected %d", len(raw), ed25519.PublicKeySize)
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// parseSignature decodes a detached signature file.
+func parseSignature(data []byte) ([]byte, error) {
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		return nil, fmt.Errorf("decoding signature: %w", err)
+	}
+	if len(sig) != ed25519.SignatureSize {
+		return nil, fmt.Errorf("signature is %d bytes, expected %d", len(sig), ed25519.SignatureSize)
+	}
+	return sig, nil
+}
+
+// Manifest returns the statement a release signature covers: the version
+// and asset name the archive was published as, and its SHA-256 digest.
+// Signing the version and name rather than the archive alone means an
+// older signed archive cannot be served as a newer release, nor one
+// platform's archive as another's. The release workflow writes the same
+// lines.
+func Manifest(version, asset string, sum []byte) []byte {
+	return fmt.Appendf(nil, "vito-root-service release\nversion %s\nasset %s\nsha256 %x\n", version, asset, sum)
+}
+
+// VerifyFile checks the detached signature sigData over the manifest of
+// the file at path, published as asset in release version.
+func VerifyFile(path, version, asset string, sigData []byte, key ed25519.PublicKey) error {
+	sig, err := parseSignature(sigData)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("opening archive: %w", err)
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return fmt.Errorf("reading archive: %w", err)
+	}
+
+	if !ed25519.Verify(key, Manifest(version, asset, h.Sum(nil)), sig) {
+		return fmt.Errorf("signature does not match %s of release %s", asset, version)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package updater
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePublicKey(t *testing.T) {
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePublicKey(" " + base64.StdEncoding.EncodeToString(pub) + "\n")
+	if err != nil || !key.Equal(pub) {
+		t.Errorf("ParsePublicKey = %v, %v", key, err)
+	}
+	if _, err := ParsePublicKey(base64.StdEncoding.EncodeToString(pub[:16])); err == nil {
+		t.Error("expected an error for a short key")
+	}
+	if _, err := ParsePublicKey("not base64!"); err == nil {
+		t.Error("expected an error for invalid base64")
+	}
+}
+
+func TestDefaultPublicKey_NotConfigured(t *testing.T) {
+	if signingKey != "" {
+		t.Skip("built with a signing key")
+	}
+	if _, err := DefaultPublicKey(); err != ErrNoSigningKey {
+		t.Errorf("DefaultPublicKey error = %v, want ErrNoSigningKey", err)
+	}
+}
+
+func TestVerifyFile(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "archive.tar.gz")
+	data := []byte("release archive contents")
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	const asset = "vito-root-service-linux-amd64.tar.gz"
+	sig := []byte(string(signManifest(priv, "v1.2.0", asset, data)) + "\n")
+
+	if err := VerifyFile(path, "v1.2.0", asset, sig, pub); err != nil {
+		t.Errorf("valid signature: %v", err)
+	}
+
+	otherPub, _, _ := ed25519.GenerateKey(nil)
+	if err := VerifyFile(path, "v1.2.0", asset, sig, otherPub); err == nil || !strings.Contains(err.Error(), "does not match") {
+		t.Errorf("wrong key: got %v", err)
+	}
+	if err := VerifyFile(path, "v1.3.0", asset, sig, pub); err == nil {
+		t.Error("expected an error for an archive signed for another version")
+	}
+	if err := VerifyFile(path, "v1.2.0", "vito-root-service-linux-arm64.tar.gz", sig, pub); err == nil {
+		t.Error("expected an error for an archive signed as another asset")
+	}
+
+	if err := os.WriteFile(path, append(data, 'x'), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyFile(path, "v1.2.0", asset, sig, pub); err == nil {
+		t.Error("expected an error for a modified archive")
+	}
+
+	if err := VerifyFile(path, "v1.2.0", asset, []byte("c2hvcnQ="), pub); err == nil || !strings.Contains(err.Error(), "expected 64") {
+		t.Errorf("short signature: got %v", err)
+	}
+}
+
+func TestManifest(t *testing.T) {
+	sum := sha256.Sum256([]byte("archive"))
+	want := fmt.Sprintf("vito-root-service release\nversion v1.2.0\nasset a.tar.gz\nsha256 %x\n", sum)
+	if got := string(Manifest("v1.2.0", "a.tar.gz", sum[:])); got != want {
+		t.Errorf("Manifest = %q, want %q", got, want)
+	}
+}
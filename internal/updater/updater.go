@@ -2,9 +2,11 @@ package updater
 
 import (
 	"context"
+	"crypto/ed25519"
 	"fmt"
 	"path/filepath"
-	"strings"
+
+	"vito-local/internal/channel"
 )
 
 // ProgressCallback is called with status updates during the update process.
@@ -23,7 +25,12 @@ type Updater struct {
 	CurrentVersion string
 	BinaryPath     string
 	GitHub         *GitHubClient
-	downloader     *Downloader
+	// Channel selects the release to update to. Defaults to stable.
+	Channel channel.Channel
+	// PublicKey verifies release signatures. Defaults to the key compiled
+	// into the binary; without one, updates are refused.
+	PublicKey  ed25519.PublicKey
+	downloader *Downloader
 }
 
 // New creates a new Updater with the given configuration.
@@ -46,19 +53,16 @@ func NewWithGitHubClient(currentVersion, binaryPath string, github *GitHubClient
 
 // CheckUpdate checks if a newer version is available.
 func (u *Updater) CheckUpdate() (*UpdateResult, error) {
-	release, err := u.GitHub.GetLatestRelease()
+	release, err := u.GitHub.GetRelease(u.Channel)
 	if err != nil {
 		return &UpdateResult{
 			Status:         "failed",
 			CurrentVersion: u.CurrentVersion,
-			Message:        fmt.Sprintf("failed to fetch latest release: %v", err),
+			Message:        fmt.Sprintf("failed to fetch %s release: %v", u.Channel, err),
 		}, err
 	}
 
-	latestVersion := normalizeVersion(release.TagName)
-	currentVersion := normalizeVersion(u.CurrentVersion)
-
-	if !isNewerVersion(currentVersion, latestVersion) {
+	if !u.Channel.Wants(u.CurrentVersion, release.TagName) {
 		return &UpdateResult{
 			Status:         "current",
 			CurrentVersion: u.CurrentVersion,
@@ -78,13 +82,15 @@ func (u *Updater) CheckUpdate() (*UpdateResult, error) {
 // PerformUpdate performs the full update process, calling onProgress with status updates.
 // The context can be used to cancel the update (e.g., if the client disconnects).
 func (u *Updater) PerformUpdate(ctx context.Context, onProgress ProgressCallback) (*UpdateResult, error) {
-	// Check for updates first
-	release, err := u.GitHub.GetLatestRelease()
-	if err != nil {
+	var latest string
+	// fail reports a failed stage, such as "downloading signature failed",
+	// with its error.
+	fail := func(stage string, err error) (*UpdateResult, error) {
 		result := &UpdateResult{
 			Status:         "failed",
 			CurrentVersion: u.CurrentVersion,
-			Message:        fmt.Sprintf("failed to fetch latest release: %v", err),
+			LatestVersion:  latest,
+			Message:        fmt.Sprintf("%s: %v", stage, err),
 		}
 		if onProgress != nil {
 			onProgress(result.Status, result.Message)
@@ -92,10 +98,14 @@ func (u *Updater) PerformUpdate(ctx context.Context, onProgress ProgressCallback
 		return result, err
 	}
 
-	latestVersion := normalizeVersion(release.TagName)
-	currentVersion := normalizeVersion(u.CurrentVersion)
+	// Check for updates first
+	release, err := u.GitHub.GetRelease(u.Channel)
+	if err != nil {
+		return fail(fmt.Sprintf("failed to fetch %s release", u.Channel), err)
+	}
+	latest = release.TagName
 
-	if !isNewerVersion(currentVersion, latestVersion) {
+	if !u.Channel.Wants(u.CurrentVersion, release.TagName) {
 		result := &UpdateResult{
 			Status:         "current",
 			CurrentVersion: u.CurrentVersion,
@@ -110,27 +120,30 @@ func (u *Updater) PerformUpdate(ctx context.Context, onProgress ProgressCallback
 
 	// Check for cancellation before starting download
 	if err := ctx.Err(); err != nil {
-		return &UpdateResult{
-			Status:         "failed",
-			CurrentVersion: u.CurrentVersion,
-			LatestVersion:  release.TagName,
-			Message:        "update cancelled",
-		}, err
+		return fail("update cancelled", err)
 	}
 
 	// Find the asset for our platform
 	asset, err := u.GitHub.FindAssetForPlatform(release)
 	if err != nil {
-		result := &UpdateResult{
-			Status:         "failed",
-			CurrentVersion: u.CurrentVersion,
-			LatestVersion:  release.TagName,
-			Message:        fmt.Sprintf("no compatible binary found: %v", err),
-		}
-		if onProgress != nil {
-			onProgress(result.Status, result.Message)
+		return fail("no compatible binary found", err)
+	}
+
+	// Every download must be signed by the release key.
+	key := u.PublicKey
+	if key == nil {
+		if key, err = DefaultPublicKey(); err != nil {
+			return fail("cannot verify updates", err)
 		}
-		return result, err
+	}
+	sigAsset, err := u.GitHub.FindSignatureAsset(release, asset)
+	if err != nil {
+		return fail("refusing unsigned release", err)
+	}
+
+	sumAsset, err := u.GitHub.FindChecksumAsset(release, asset)
+	if err != nil {
+		return fail("cannot verify download", err)
 	}
 
 	// Notify: downloading
@@ -142,33 +155,39 @@ func (u *Updater) PerformUpdate(ctx context.Context, onProgress ProgressCallback
 	u.downloader = NewDownloader()
 	defer u.downloader.Cleanup()
 
+	signature, err := u.downloader.Fetch(ctx, sigAsset.BrowserDownloadURL)
+	if err != nil {
+		return fail("downloading signature failed", err)
+	}
+	sums, err := u.downloader.Fetch(ctx, sumAsset.BrowserDownloadURL)
+	if err == nil {
+		u.downloader.SHA256, err = parseChecksum(sums, asset.Name)
+	}
+	if err != nil {
+		return fail("checksum verification failed: "+sumAsset.Name, err)
+	}
+	u.downloader.Verify = func(archivePath string) error {
+		if err := VerifyFile(archivePath, release.TagName, asset.Name, signature, key); err != nil {
+			return fmt.Errorf("signature verification failed: %w", err)
+		}
+		return nil
+	}
+
 	binaryName := filepath.Base(u.BinaryPath)
 	extractedPath, err := u.downloader.DownloadAndExtract(ctx, asset.BrowserDownloadURL, binaryName)
 	if err != nil {
-		result := &UpdateResult{
-			Status:         "failed",
-			CurrentVersion: u.CurrentVersion,
-			LatestVersion:  release.TagName,
-			Message:        fmt.Sprintf("download/extract failed: %v", err),
-		}
-		if onProgress != nil {
-			onProgress(result.Status, result.Message)
-		}
-		return result, err
+		return fail("download/extract failed", err)
 	}
 
 	// Atomic replace
 	if err := AtomicReplace(extractedPath, u.BinaryPath); err != nil {
-		result := &UpdateResult{
-			Status:         "failed",
-			CurrentVersion: u.CurrentVersion,
-			LatestVersion:  release.TagName,
-			Message:        fmt.Sprintf("failed to replace binary: %v", err),
-		}
-		if onProgress != nil {
-			onProgress(result.Status, result.Message)
-		}
-		return result, err
+		return fail("failed to replace binary", err)
+	}
+
+	// The new version confirms this once it is serving; until then the
+	// service unit rolls back to the previous binary if it fails to start.
+	if err := MarkPending(u.BinaryPath, PendingUpdate{FromVersion: u.CurrentVersion, ToVersion: release.TagName}); err != nil {
+		return fail("binary replaced, but automatic rollback is unavailable", err)
 	}
 
 	// Notify: applied
@@ -184,55 +203,3 @@ func (u *Updater) PerformUpdate(ctx context.Context, onProgress ProgressCallback
 
 	return result, nil
 }
-
-// normalizeVersion removes the "v" prefix and any leading/trailing whitespace.
-func normalizeVersion(version string) string {
-	v := strings.TrimSpace(version)
-	v = strings.TrimPrefix(v, "v")
-	return v
-}
-
-// isNewerVersion returns true if latest is newer than current.
-// Uses simple string comparison which works for semver-like versions.
-func isNewerVersion(current, latest string) bool {
-	// Handle "dev" or empty versions - always consider updates available
-	if current == "" || current == "dev" {
-		return latest != "" && latest != "dev"
-	}
-
-	// Parse semver-like versions
-	currentParts := parseVersion(current)
-	latestParts := parseVersion(latest)
-
-	// Compare each part
-	for i := 0; i < len(currentParts) && i < len(latestParts); i++ {
-		if latestParts[i] > currentParts[i] {
-			return true
-		}
-		if latestParts[i] < currentParts[i] {
-			return false
-		}
-	}
-
-	// If all compared parts are equal, the longer version is newer
-	return len(latestParts) > len(currentParts)
-}
-
-// parseVersion parses a version string into numeric parts.
-func parseVersion(v string) []int {
-	parts := strings.Split(v, ".")
-	result := make([]int, 0, len(parts))
-
-	for _, part := range parts {
-		// Handle pre-release suffixes like "1.0.0-beta"
-		if idx := strings.IndexAny(part, "-+"); idx >= 0 {
-			part = part[:idx]
-		}
-
-		var num int
-		_, _ = fmt.Sscanf(part, "%d", &num) // Ignore error; non-numeric parts become 0
-		result = append(result, num)
-	}
-
-	return result
-}
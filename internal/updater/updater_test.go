@@ -1,89 +1,24 @@
 package updater
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 )
 
-func TestNormalizeVersion(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected string
-	}{
-		{"v1.2.3", "1.2.3"},
-		{"1.2.3", "1.2.3"},
-		{"  v1.2.3  ", "1.2.3"},
-		{"v0.1.0", "0.1.0"},
-		{"", ""},
-	}
-
-	for _, tc := range tests {
-		result := normalizeVersion(tc.input)
-		if result != tc.expected {
-			t.Errorf("normalizeVersion(%q) = %q, expected %q", tc.input, result, tc.expected)
-		}
-	}
-}
-
-func TestIsNewerVersion(t *testing.T) {
-	tests := []struct {
-		current  string
-		latest   string
-		expected bool
-	}{
-		{"1.0.0", "1.0.1", true},
-		{"1.0.0", "1.1.0", true},
-		{"1.0.0", "2.0.0", true},
-		{"1.0.1", "1.0.0", false},
-		{"1.1.0", "1.0.0", false},
-		{"2.0.0", "1.0.0", false},
-		{"1.0.0", "1.0.0", false},
-		{"dev", "1.0.0", true},
-		{"", "1.0.0", true},
-		{"1.0.0", "dev", false},
-		{"0.1.0", "0.1.1", true},
-		{"0.1.10", "0.1.9", false},
-		{"0.1.9", "0.1.10", true},
-	}
-
-	for _, tc := range tests {
-		result := isNewerVersion(tc.current, tc.latest)
-		if result != tc.expected {
-			t.Errorf("isNewerVersion(%q, %q) = %v, expected %v", tc.current, tc.latest, result, tc.expected)
-		}
-	}
-}
-
-func TestParseVersion(t *testing.T) {
-	tests := []struct {
-		input    string
-		expected []int
-	}{
-		{"1.2.3", []int{1, 2, 3}},
-		{"0.1.0", []int{0, 1, 0}},
-		{"1.0.0-beta", []int{1, 0, 0}},
-		{"2.0", []int{2, 0}},
-		{"1", []int{1}},
-	}
-
-	for _, tc := range tests {
-		result := parseVersion(tc.input)
-		if len(result) != len(tc.expected) {
-			t.Errorf("parseVersion(%q) length = %d, expected %d", tc.input, len(result), len(tc.expected))
-			continue
-		}
-		for i, v := range result {
-			if v != tc.expected[i] {
-				t.Errorf("parseVersion(%q)[%d] = %d, expected %d", tc.input, i, v, tc.expected[i])
-			}
-		}
-	}
-}
-
 func TestGitHubClient_GetLatestRelease(t *testing.T) {
 	// Create a mock server
 	release := Release{
@@ -166,7 +101,7 @@ func TestUpdater_CheckUpdate_CurrentVersion(t *testing.T) {
 	}))
 	defer server.Close()
 
-	u := NewWithGitHubClient("v0.1.0", "/usr/local/bin/vito-root-service", NewGitHubClientWithURL(server.URL))
+	u := NewWithGitHubClient("v0.1.0", "/usr/local/bin/vito-root-service", NewGitHubClientWithURL(server.URL+"/release"))
 	result, err := u.CheckUpdate()
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -189,7 +124,7 @@ func TestUpdater_CheckUpdate_Available(t *testing.T) {
 	}))
 	defer server.Close()
 
-	u := NewWithGitHubClient("v0.1.0", "/usr/local/bin/vito-root-service", NewGitHubClientWithURL(server.URL))
+	u := NewWithGitHubClient("v0.1.0", "/usr/local/bin/vito-root-service", NewGitHubClientWithURL(server.URL+"/release"))
 	result, err := u.CheckUpdate()
 	if err != nil {
 		t.Fatalf("unexpected error: %v", err)
@@ -251,3 +186,200 @@ func TestSanitizePath(t *testing.T) {
 		}
 	}
 }
+
+// testReleaseOptions override what testRelease publishes. Nil functions
+// publish a valid signature and SHA256SUMS manifest; functions returning
+// nil leave the file out of the release.
+type testReleaseOptions struct {
+	sign func(archive []byte, assetName string) []byte
+	sums func(archive []byte, assetName string) []byte
+	// serve replaces the archive bytes served for download.
+	serve func(archive []byte) []byte
+}
+
+// signManifest returns the release signature of archive published as
+// asset in version.
+func signManifest(priv ed25519.PrivateKey, version, asset string, archive []byte) []byte {
+	sum := sha256.Sum256(archive)
+	return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, Manifest(version, asset, sum[:]))))
+}
+
+// testRelease serves a release with a signed, checksummed archive
+// containing a fake binary named binaryName, signed with priv.
+func testRelease(t *testing.T, binaryName string, priv ed25519.PrivateKey, opts testReleaseOptions) *httptest.Server {
+	t.Helper()
+
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gzw)
+	content := bytes.Repeat([]byte{0x7f}, minBinarySize+1)
+	if err := tw.WriteHeader(&tar.Header{Name: binaryName, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	tw.Write(content)
+	tw.Close()
+	gzw.Close()
+	archive := buf.Bytes()
+
+	if opts.sign == nil {
+		opts.sign = func(archive []byte, assetName string) []byte {
+			return signManifest(priv, "v0.2.0", assetName, archive)
+		}
+	}
+	if opts.sums == nil {
+		opts.sums = func(archive []byte, assetName string) []byte {
+			return []byte(fmt.Sprintf("%x  other.tar.gz\n%x  %s\n", sha256.Sum256(nil), sha256.Sum256(archive), assetName))
+		}
+	}
+	assetName := fmt.Sprintf("vito-root-service-%s-%s.tar.gz", runtime.GOOS, runtime.GOARCH)
+	signature := opts.sign(archive, assetName)
+	sums := opts.sums(archive, assetName)
+	served := archive
+	if opts.serve != nil {
+		served = opts.serve(archive)
+	}
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/release":
+			release := Release{TagName: "v0.2.0", Assets: []Asset{
+				{Name: assetName, BrowserDownloadURL: server.URL + "/archive"},
+			}}
+			if signature != nil {
+				release.Assets = append(release.Assets, Asset{Name: assetName + SignatureSuffix, BrowserDownloadURL: server.URL + "/signature"})
+			}
+			if sums != nil {
+				release.Assets = append(release.Assets, Asset{Name: "SHA256SUMS", BrowserDownloadURL: server.URL + "/sums"})
+			}
+			json.NewEncoder(w).Encode(release)
+		case "/archive":
+			w.Write(served)
+		case "/signature":
+			w.Write(signature)
+		case "/sums":
+			w.Write(sums)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestUpdater_PerformUpdate_Verification(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, otherPriv, _ := ed25519.GenerateKey(nil)
+
+	tests := []struct {
+		name    string
+		key     ed25519.PublicKey
+		opts    testReleaseOptions
+		want    string
+		applied bool
+	}{
+		{"valid", pub, testReleaseOptions{}, "", true},
+		{"wrong key", otherPub, testReleaseOptions{}, "signature verification failed", false},
+		{"signed by another key", pub, testReleaseOptions{sign: func(a []byte, name string) []byte {
+			return signManifest(otherPriv, "v0.2.0", name, a)
+		}}, "signature verification failed", false},
+		{"older release", pub, testReleaseOptions{sign: func(a []byte, name string) []byte {
+			return signManifest(priv, "v0.1.5", name, a)
+		}}, "signature verification failed", false},
+		{"other platform", pub, testReleaseOptions{sign: func(a []byte, _ string) []byte {
+			return signManifest(priv, "v0.2.0", "vito-root-service-plan9-mips.tar.gz", a)
+		}}, "signature verification failed", false},
+		{"signed archive bytes", pub, testReleaseOptions{sign: func(a []byte, _ string) []byte {
+			return []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(priv, a)))
+		}}, "signature verification failed", false},
+		{"missing signature", pub, testReleaseOptions{sign: func([]byte, string) []byte { return nil }}, "refusing unsigned release", false},
+		{"missing checksums", pub, testReleaseOptions{sums: func([]byte, string) []byte { return nil }}, "cannot verify download", false},
+		{"asset not listed", pub, testReleaseOptions{sums: func(a []byte, _ string) []byte {
+			return []byte(fmt.Sprintf("%x  other.tar.gz\n", sha256.Sum256(a)))
+		}}, "no checksum listed", false},
+		{"truncated download", pub, testReleaseOptions{serve: func(a []byte) []byte { return a[:len(a)/2] }}, "checksum mismatch", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			binPath := filepath.Join(t.TempDir(), "vito-root-service")
+			if err := os.WriteFile(binPath, []byte("old"), 0755); err != nil {
+				t.Fatal(err)
+			}
+			server := testRelease(t, filepath.Base(binPath), priv, tt.opts)
+
+			u := NewWithGitHubClient("v0.1.0", binPath, NewGitHubClientWithURL(server.URL+"/release"))
+			u.PublicKey = tt.key
+			result, err := u.PerformUpdate(context.Background(), nil)
+
+			data, _ := os.ReadFile(binPath)
+			if tt.applied {
+				if err != nil || result.Status != "applied" {
+					t.Fatalf("PerformUpdate = %+v, %v", result, err)
+				}
+				if len(data) != minBinarySize+1 {
+					t.Error("binary was not replaced")
+				}
+				if prev, _ := os.ReadFile(PrevPath(binPath)); string(prev) != "old" {
+					t.Errorf("previous binary = %q, want it kept", prev)
+				}
+				if p, err := Pending(binPath); err != nil || p == nil || p.FromVersion != "v0.1.0" || p.ToVersion != "v0.2.0" {
+					t.Errorf("Pending = %+v, %v", p, err)
+				}
+				return
+			}
+			if err == nil || result.Status != "failed" || !strings.Contains(result.Message, tt.want) {
+				t.Errorf("PerformUpdate = %+v, %v; want failure containing %q", result, err, tt.want)
+			}
+			if string(data) != "old" {
+				t.Error("binary was replaced despite the failure")
+			}
+			if p, _ := Pending(binPath); p != nil {
+				t.Error("failed update left a pending marker")
+			}
+		})
+	}
+}
+
+func TestUpdater_PerformUpdate_NoSigningKey(t *testing.T) {
+	if signingKey != "" {
+		t.Skip("built with a signing key")
+	}
+	_, priv, _ := ed25519.GenerateKey(nil)
+	binPath := filepath.Join(t.TempDir(), "vito-root-service")
+	server := testRelease(t, filepath.Base(binPath), priv, testReleaseOptions{})
+
+	u := NewWithGitHubClient("v0.1.0", binPath, NewGitHubClientWithURL(server.URL+"/release"))
+	result, err := u.PerformUpdate(context.Background(), nil)
+	if err == nil || !strings.Contains(result.Message, "cannot verify updates") {
+		t.Errorf("PerformUpdate = %+v, %v; want a missing key failure", result, err)
+	}
+}
+
+func TestParseChecksum(t *testing.T) {
+	sum := sha256.Sum256([]byte("archive"))
+	digest := fmt.Sprintf("%x", sum)
+	tests := []struct {
+		name string
+		data string
+		want bool
+	}{
+		{"manifest", "0000  other.tar.gz\n" + digest + "  app.tar.gz\n", true},
+		{"binary marker", digest + " *app.tar.gz\n", true},
+		{"digest only", digest + "\n", true},
+		{"not listed", digest + "  other.tar.gz\n", false},
+		{"bad digest", "abc  app.tar.gz\n", false},
+		{"empty", "", false},
+	}
+	for _, tt := range tests {
+		got, err := parseChecksum([]byte(tt.data), "app.tar.gz")
+		if tt.want && (err != nil || !bytes.Equal(got, sum[:])) {
+			t.Errorf("%s: parseChecksum = %x, %v", tt.name, got, err)
+		}
+		if !tt.want && err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		}
+	}
+}
@@ -4,7 +4,9 @@ set -euo pipefail
 REPO="RichardAnderson/vito-local"
 BINARY_NAME="vito-root-service"
 INSTALL_DIR="/usr/local/bin"
+LIB_DIR="/usr/local/lib/vito-root"
 SYSTEMD_DIR="/etc/systemd/system"
+CONFIG_DIR="/etc/vito-root"
 SERVICE_USER="${VITO_USER:-vito}"
 
 # Check root
@@ -82,15 +84,28 @@ fi
 # Install binary
 echo "Installing binary to $INSTALL_DIR/$BINARY_NAME..."
 install -m 0755 "$TMPDIR/$BINARY_NAME" "$INSTALL_DIR/$BINARY_NAME"
+# A manual install supersedes any unconfirmed self-update
+rm -f "$INSTALL_DIR/$BINARY_NAME.pending" "$INSTALL_DIR/$BINARY_NAME.attempted"
+install -D -m 0755 "$TMPDIR/scripts/rollback-guard.sh" "$LIB_DIR/rollback-guard.sh"
 
 # Install systemd units
 echo "Installing systemd units..."
 install -m 0644 "$TMPDIR/systemd/vito-root.socket" "$SYSTEMD_DIR/"
 install -m 0644 "$TMPDIR/systemd/vito-root.service" "$SYSTEMD_DIR/"
 
-# Update service files with correct user if not default
+# Install the default config file, which upgrades leave alone. Configs
+# from before the log format moved out of the unit get it added, so they
+# keep logging to the journal.
+if [[ ! -e "$CONFIG_DIR/config.toml" ]]; then
+    install -D -m 0644 "$TMPDIR/config/config.toml" "$CONFIG_DIR/config.toml"
+    # Record a non-default user
+    if [[ "$SERVICE_USER" != "vito" ]]; then
+        printf 'user = "%s"\n' "$SERVICE_USER" >> "$CONFIG_DIR/config.toml"
+    fi
+elif ! grep -Eq '^[[:space:]]*log_format[[:space:]]*=' "$CONFIG_DIR/config.toml"; then
+    sed -i '1i log_format = "journald"' "$CONFIG_DIR/config.toml"
+fi
 if [[ "$SERVICE_USER" != "vito" ]]; then
-    sed -i "s/-user vito/-user $SERVICE_USER/" "$SYSTEMD_DIR/vito-root.service"
     sed -i "s/SocketGroup=vito/SocketGroup=$SERVICE_USER/" "$SYSTEMD_DIR/vito-root.socket"
 fi
 
@@ -0,0 +1,34 @@
+#!/bin/sh
+# Rolls back a self-update whose new binary failed to start. Runs as
+# ExecStartPre= of vito-root.service, before the binary it guards, so it
+# works even when that binary cannot run at all.
+#
+# The service leaves <binary>.pending after replacing itself and removes it
+# once the new version is serving. The first start with the marker present
+# records an attempt (<binary>.attempted), which the service clears again
+# when it is stopped cleanly before confirming. A start that finds the
+# attempt still recorded means the previous one crashed or failed, so the
+# previous binary (<binary>.prev) is restored and the failed one kept as
+# <binary>.failed.
+set -eu
+
+bin="${1:-/usr/local/bin/vito-root-service}"
+
+[ -e "$bin.pending" ] || exit 0
+
+if [ ! -e "$bin.attempted" ]; then
+    : > "$bin.attempted"
+    exit 0
+fi
+
+if [ ! -x "$bin.prev" ]; then
+    echo "rollback-guard: updated binary failed to start and $bin.prev is missing, not rolling back" >&2
+    rm -f "$bin.pending" "$bin.attempted"
+    exit 0
+fi
+
+echo "rollback-guard: updated binary failed to start, restoring $bin.prev" >&2
+ln -f "$bin" "$bin.failed" 2>/dev/null || true
+cp -p "$bin.prev" "$bin.rollback"
+mv -f "$bin.rollback" "$bin"
+rm -f "$bin.pending" "$bin.attempted"
@@ -3,6 +3,7 @@ set -euo pipefail
 
 BINARY_NAME="vito-root-service"
 INSTALL_DIR="/usr/local/bin"
+LIB_DIR="/usr/local/lib/vito-root"
 SYSTEMD_DIR="/etc/systemd/system"
 
 # Check root
@@ -21,6 +22,8 @@ systemctl disable vito-root.socket vito-root.service 2>/dev/null || true
 # Remove files
 echo "Removing files..."
 rm -f "$INSTALL_DIR/$BINARY_NAME"
+rm -f "$INSTALL_DIR/$BINARY_NAME".{prev,pending,attempted,failed}
+rm -rf "$LIB_DIR"
 rm -f "$SYSTEMD_DIR/vito-root.socket"
 rm -f "$SYSTEMD_DIR/vito-root.service"
 rm -f /run/vito-root.sock
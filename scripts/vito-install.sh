@@ -267,12 +267,22 @@ install_vito_local_service() {
     # Install binary
     log "Installing ${binary_name} to ${install_dir}..."
     install -m 0755 "${tmp_dir}/${binary_name}" "${install_dir}/${binary_name}"
+    # A manual install supersedes any unconfirmed self-update
+    rm -f "${install_dir}/${binary_name}.pending" "${install_dir}/${binary_name}.attempted"
+    install -D -m 0755 "${tmp_dir}/scripts/rollback-guard.sh" "/usr/local/lib/vito-root/rollback-guard.sh"
 
     # Install systemd units
     log "Installing systemd units..."
     install -m 0644 "${tmp_dir}/systemd/vito-root.socket" "${systemd_dir}/"
     install -m 0644 "${tmp_dir}/systemd/vito-root.service" "${systemd_dir}/"
 
+    # Install the default config file, which upgrades leave alone
+    if [[ ! -e /etc/vito-root/config.toml ]]; then
+        install -D -m 0644 "${tmp_dir}/config/config.toml" /etc/vito-root/config.toml
+    elif ! grep -Eq '^[[:space:]]*log_format[[:space:]]*=' /etc/vito-root/config.toml; then
+        sed -i '1i log_format = "journald"' /etc/vito-root/config.toml
+    fi
+
     # Reload and enable
     systemctl daemon-reload
     systemctl enable vito-root.socket